@@ -0,0 +1,332 @@
+// Package cassandra provides Datatables handlers for Cassandra and ScyllaDB
+// tables.
+//
+// Cassandra only supports a subset of the Datatables operations:
+//
+//   - Paging is sequential using the page state of the driver, records can't
+//     be skipped. The page state is returned in the response and has to be
+//     sent back in the PageStateParam to fetch the next page.
+//   - Only the FilterColumns (clustering or indexed columns) can be searched
+//     and only for equality. The global search is ignored.
+//   - Ordering is only possible on the OrderColumns (clustering columns)
+//     and only when the partition key is restricted by a column search.
+//   - Counting requires a full scan, so it is disabled by default.
+package cassandra
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/basvdlei/godatatables/types"
+	"github.com/gocql/gocql"
+)
+
+// PageStateParam is the request parameter containing the page state of the
+// previous response.
+const PageStateParam = "pageState"
+
+// Session interface contains the *gocql.Session methods used.
+type Session interface {
+	Query(stmt string, values ...interface{}) Query
+}
+
+// Query interface contains the *gocql.Query methods used.
+type Query interface {
+	PageSize(n int) Query
+	PageState(state []byte) Query
+	Iter() Iter
+}
+
+// Iter interface contains the *gocql.Iter methods used.
+type Iter interface {
+	MapScan(m map[string]interface{}) bool
+	PageState() []byte
+	Close() error
+}
+
+// sessionWrapper wraps a *gocql.Session into the Session interface to allow
+// for mocked testing.
+type sessionWrapper struct {
+	s *gocql.Session
+}
+
+// Query wraps *gocql.Session.Query().
+func (w *sessionWrapper) Query(stmt string, values ...interface{}) Query {
+	return &queryWrapper{q: w.s.Query(stmt, values...)}
+}
+
+// queryWrapper wraps a *gocql.Query into the Query interface.
+type queryWrapper struct {
+	q *gocql.Query
+}
+
+// PageSize wraps *gocql.Query.PageSize().
+func (w *queryWrapper) PageSize(n int) Query {
+	return &queryWrapper{q: w.q.PageSize(n)}
+}
+
+// PageState wraps *gocql.Query.PageState().
+func (w *queryWrapper) PageState(state []byte) Query {
+	return &queryWrapper{q: w.q.PageState(state)}
+}
+
+// Iter wraps *gocql.Query.Iter().
+func (w *queryWrapper) Iter() Iter {
+	return w.q.Iter()
+}
+
+// TableHandler provides a HTTP handler for a Cassandra table.
+type TableHandler struct {
+	Session Session
+	// Table is the (optionally keyspace qualified) table name.
+	Table string
+	// Columns contains the columns that can be returned.
+	Columns []string
+	// FilterColumns contains the columns that can be searched for
+	// equality, which are the partition key, clustering and indexed
+	// columns.
+	FilterColumns []string
+	// OrderColumns contains the clustering columns that can be ordered on.
+	OrderColumns []string
+	// AllowFiltering appends ALLOW FILTERING to queries with a column
+	// search. This allows searching columns that would otherwise be
+	// rejected, at the cost of scanning the table.
+	AllowFiltering bool
+	// Count enables counting the records, which scans the whole table or
+	// partition. Without it the records filtered is set to the end of the
+	// page plus one when there are more records.
+	Count bool
+	// DefaultLength is the number of records returned when the request
+	// does not specify a length. Without it such requests return all
+	// records of the page state. Requests with an explicit length of zero
+	// don't get any records.
+	DefaultLength int
+}
+
+// NewTableHandler returns a TableHandler for the given table and columns.
+func NewTableHandler(s *gocql.Session, table string, columns ...string) *TableHandler {
+	return &TableHandler{
+		Session: &sessionWrapper{s: s},
+		Table:   table,
+		Columns: columns,
+	}
+}
+
+// ServeHTTP implements the http.Handler interface
+func (h *TableHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	dtRequest, err := types.ParseURLValues(r.Form)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	dtRequest = dtRequest.WithDefaultLength(h.DefaultLength)
+	state, err := base64.RawURLEncoding.DecodeString(r.Form.Get(PageStateParam))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	dtResponse := h.Response(dtRequest, state)
+	e := json.NewEncoder(w)
+	if err := e.Encode(&dtResponse); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
+
+// Response queries the page of the Datatables Request that starts at the
+// page state, which is empty for the first page. A negative length returns
+// all records from the page state, following the page states of the driver
+// pages until the last one, a length of zero none.
+func (h *TableHandler) Response(r types.Request, state []byte) types.Response {
+	resp := types.Response{
+		Draw: r.Draw,
+		Data: []types.Row{},
+	}
+	stmt, values := h.SelectQuery(r)
+	columns := h.columns(r)
+	var next []byte
+	for {
+		q := h.Session.Query(stmt, values...)
+		if r.Length > 0 {
+			q = q.PageSize(r.Length)
+		}
+		// Setting the page state, even when empty, disables the
+		// automatic fetching of the next pages.
+		q = q.PageState(state)
+		iter := q.Iter()
+		for r.Length < 0 || len(resp.Data) < r.Length {
+			m := make(map[string]interface{}, len(columns))
+			if !iter.MapScan(m) {
+				break
+			}
+			row := types.Row{Data: make(map[string]string, len(columns))}
+			for _, c := range columns {
+				if v := m[c]; v != nil {
+					row.Data[c] = fmt.Sprint(v)
+				} else {
+					row.Data[c] = ""
+				}
+			}
+			resp.Data = append(resp.Data, row)
+		}
+		next = iter.PageState()
+		if err := iter.Close(); err != nil {
+			resp.Error = err.Error()
+			return resp
+		}
+		if r.Length >= 0 || len(next) == 0 {
+			break
+		}
+		state = next
+	}
+	if len(next) > 0 {
+		resp.PageState = base64.RawURLEncoding.EncodeToString(next)
+	}
+	if !h.Count {
+		resp.RecordsFiltered = r.Start + len(resp.Data)
+		if len(next) > 0 {
+			resp.RecordsFiltered++
+		}
+		resp.RecordsTotal = resp.RecordsFiltered
+		return resp
+	}
+	if err := h.count(h.TotalQuery(), nil, &resp.RecordsTotal); err != nil {
+		resp.Error = err.Error()
+	}
+	stmt, values = h.CountQuery(r)
+	if err := h.count(stmt, values, &resp.RecordsFiltered); err != nil {
+		resp.Error = err.Error()
+	}
+	return resp
+}
+
+// count runs the count query and stores the count in n.
+func (h *TableHandler) count(stmt string, values []interface{}, n *int) error {
+	iter := h.Session.Query(stmt, values...).Iter()
+	m := make(map[string]interface{}, 1)
+	if iter.MapScan(m) {
+		switch v := m["count"].(type) {
+		case int64:
+			*n = int(v)
+		case int:
+			*n = v
+		}
+	}
+	return iter.Close()
+}
+
+// SelectQuery returns the CQL statement that selects the records of the
+// Datatables Request.
+func (h *TableHandler) SelectQuery(r types.Request) (stmt string, values []interface{}) {
+	columns := h.columns(r)
+	fields := make([]string, len(columns))
+	for i, c := range columns {
+		fields[i] = quoteIdent(c)
+	}
+	if len(fields) == 0 {
+		fields = []string{"*"}
+	}
+	where, values := h.Where(r)
+	stmt = "SELECT " + strings.Join(fields, ", ") + " FROM " +
+		quoteIdent(h.Table) + where + h.OrderBy(r, len(values) > 0)
+	return stmt + h.allowFiltering(values), values
+}
+
+// TotalQuery returns the CQL statement counting all records.
+func (h *TableHandler) TotalQuery() string {
+	return "SELECT COUNT(*) FROM " + quoteIdent(h.Table)
+}
+
+// CountQuery returns the CQL statement counting the records matching the
+// column searches of the Datatables Request.
+func (h *TableHandler) CountQuery(r types.Request) (stmt string, values []interface{}) {
+	where, values := h.Where(r)
+	stmt = "SELECT COUNT(*) FROM " + quoteIdent(h.Table) + where
+	return stmt + h.allowFiltering(values), values
+}
+
+// Where returns the WHERE clause for the column searches on the
+// FilterColumns. The clause is empty if there is nothing to search for.
+func (h *TableHandler) Where(r types.Request) (clause string, values []interface{}) {
+	var conditions []string
+	for _, c := range r.Columns {
+		if !c.Searchable || c.Search.Value == "" || !contains(h.FilterColumns, c.Data) {
+			continue
+		}
+		conditions = append(conditions, quoteIdent(c.Data)+" = ?")
+		values = append(values, c.Search.Value)
+	}
+	if len(conditions) == 0 {
+		return "", nil
+	}
+	return " WHERE " + strings.Join(conditions, " AND "), values
+}
+
+// OrderBy returns the ORDER BY clause for the OrderColumns of the Datatables
+// Request. Cassandra only allows ordering within a partition, so the clause
+// is only added when the query is restricted.
+func (h *TableHandler) OrderBy(r types.Request, restricted bool) string {
+	if !restricted {
+		return ""
+	}
+	var order []string
+	for _, o := range r.Order {
+		c, ok := r.OrderColumn(o)
+		if !ok || !contains(h.OrderColumns, c.Data) {
+			continue
+		}
+		dir := " ASC"
+		if o.Dir == types.OrderDescending {
+			dir = " DESC"
+		}
+		order = append(order, quoteIdent(c.Data)+dir)
+	}
+	if len(order) == 0 {
+		return ""
+	}
+	return " ORDER BY " + strings.Join(order, ", ")
+}
+
+// allowFiltering returns the ALLOW FILTERING clause if enabled and needed.
+func (h *TableHandler) allowFiltering(values []interface{}) string {
+	if h.AllowFiltering && len(values) > 0 {
+		return " ALLOW FILTERING"
+	}
+	return ""
+}
+
+// columns returns the allowed columns of the Request.
+func (h *TableHandler) columns(r types.Request) []string {
+	columns := make([]string, 0, len(r.Columns))
+	for _, c := range r.Columns {
+		if contains(h.Columns, c.Data) {
+			columns = append(columns, c.Data)
+		}
+	}
+	return columns
+}
+
+// contains reports if s is in list.
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// quoteIdent quotes a (optionally keyspace qualified) identifier.
+func quoteIdent(s string) string {
+	parts := strings.Split(s, ".")
+	for i, p := range parts {
+		parts[i] = `"` + strings.Replace(p, `"`, `""`, -1) + `"`
+	}
+	return strings.Join(parts, ".")
+}
@@ -0,0 +1,324 @@
+package cassandra
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/basvdlei/godatatables/types"
+)
+
+type SessionMock struct {
+	Rows      []map[string]interface{}
+	NextState []byte
+	// Pages are the rows of the driver pages, used instead of the Rows
+	// when set. The page state of a page is its index.
+	Pages   [][]map[string]interface{}
+	Count   int64
+	Queries []*QueryMock
+}
+
+func (s *SessionMock) Query(stmt string, values ...interface{}) Query {
+	q := &QueryMock{session: s, Stmt: stmt, Values: values}
+	s.Queries = append(s.Queries, q)
+	return q
+}
+
+type QueryMock struct {
+	session  *SessionMock
+	Stmt     string
+	Values   []interface{}
+	Size     int
+	State    []byte
+	StateSet bool
+}
+
+func (q *QueryMock) PageSize(n int) Query {
+	q.Size = n
+	return q
+}
+
+func (q *QueryMock) PageState(state []byte) Query {
+	q.State = state
+	q.StateSet = true
+	return q
+}
+
+func (q *QueryMock) Iter() Iter {
+	if strings.HasPrefix(q.Stmt, "SELECT COUNT") {
+		return &IterMock{rows: []map[string]interface{}{{"count": q.session.Count}}}
+	}
+	if pages := q.session.Pages; pages != nil {
+		i := 0
+		if len(q.State) > 0 {
+			i = int(q.State[0])
+		}
+		var next []byte
+		if i+1 < len(pages) {
+			next = []byte{byte(i + 1)}
+		}
+		return &IterMock{rows: pages[i], state: next}
+	}
+	return &IterMock{rows: q.session.Rows, state: q.session.NextState}
+}
+
+type IterMock struct {
+	rows  []map[string]interface{}
+	state []byte
+}
+
+func (i *IterMock) MapScan(m map[string]interface{}) bool {
+	if len(i.rows) == 0 {
+		return false
+	}
+	for k, v := range i.rows[0] {
+		m[k] = v
+	}
+	i.rows = i.rows[1:]
+	return true
+}
+
+func (i *IterMock) PageState() []byte {
+	return i.state
+}
+
+func (i *IterMock) Close() error {
+	return nil
+}
+
+var testRequest = types.Request{
+	Draw:   2,
+	Start:  10,
+	Length: 10,
+	Search: types.Search{Value: "ignored"},
+	Order: []types.Order{
+		{Column: 1, Dir: types.OrderDescending},
+		{Column: 0, Dir: types.OrderAscending},
+	},
+	Columns: []types.Column{
+		{Data: "user_id", Searchable: true, Search: types.Search{Value: "42"}},
+		{Data: "created", Searchable: true, Orderable: true},
+		{Data: "message", Searchable: true, Search: types.Search{Value: "hi"}},
+		{Data: "secret", Searchable: true},
+	},
+}
+
+func newTestHandler() *TableHandler {
+	return &TableHandler{
+		Table:         "chat.messages",
+		Columns:       []string{"user_id", "created", "message"},
+		FilterColumns: []string{"user_id"},
+		OrderColumns:  []string{"created"},
+	}
+}
+
+func TestSelectQuery(t *testing.T) {
+	h := newTestHandler()
+	cases := []struct {
+		Name           string
+		Request        types.Request
+		AllowFiltering bool
+		Stmt           string
+		Values         []interface{}
+	}{
+		{
+			Name:    "partition",
+			Request: testRequest,
+			Stmt:    `SELECT "user_id", "created", "message" FROM "chat"."messages" WHERE "user_id" = ? ORDER BY "created" DESC`,
+			Values:  []interface{}{"42"},
+		},
+		{
+			Name:           "allow-filtering",
+			Request:        testRequest,
+			AllowFiltering: true,
+			Stmt:           `SELECT "user_id", "created", "message" FROM "chat"."messages" WHERE "user_id" = ? ORDER BY "created" DESC ALLOW FILTERING`,
+			Values:         []interface{}{"42"},
+		},
+		{
+			Name: "unrestricted",
+			Request: types.Request{
+				Order:   testRequest.Order,
+				Columns: testRequest.Columns[1:],
+			},
+			Stmt: `SELECT "created", "message" FROM "chat"."messages"`,
+		},
+	}
+	for _, c := range cases {
+		h.AllowFiltering = c.AllowFiltering
+		stmt, values := h.SelectQuery(c.Request)
+		if stmt != c.Stmt {
+			t.Errorf("case %s: statement does not match, want %s, got %s",
+				c.Name, c.Stmt, stmt)
+		}
+		if !reflect.DeepEqual(values, c.Values) {
+			t.Errorf("case %s: values do not match, want %v, got %v",
+				c.Name, c.Values, values)
+		}
+	}
+}
+
+func TestTableHandlerServeHTTP(t *testing.T) {
+	session := &SessionMock{
+		Rows: []map[string]interface{}{
+			{"user_id": 42, "created": "2017-05-27", "message": "hi"},
+			{"user_id": 42, "created": "2017-05-26", "message": nil},
+		},
+		NextState: []byte{0x01, 0x02},
+	}
+	h := newTestHandler()
+	h.Session = session
+	req := &http.Request{
+		Method: "GET",
+		URL:    &url.URL{Path: "/"},
+		Form: url.Values{
+			"draw":                      []string{"3"},
+			"start":                     []string{"2"},
+			"length":                    []string{"2"},
+			"columns[0][data]":          []string{"user_id"},
+			"columns[0][searchable]":    []string{"true"},
+			"columns[0][search][value]": []string{"42"},
+			"columns[1][data]":          []string{"message"},
+			PageStateParam:              []string{base64.RawURLEncoding.EncodeToString([]byte{0x00})},
+		},
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	var dtResponse types.Response
+	if err := json.NewDecoder(w.Result().Body).Decode(&dtResponse); err != nil {
+		t.Fatalf("could not unmarshal response: %v", err)
+	}
+	if len(session.Queries) != 1 {
+		t.Fatalf("unexpected number of queries, want %d, got %d",
+			1, len(session.Queries))
+	}
+	q := session.Queries[0]
+	if want := `SELECT "user_id", "message" FROM "chat"."messages" WHERE "user_id" = ?`; q.Stmt != want {
+		t.Errorf("statement does not match, want %s, got %s", want, q.Stmt)
+	}
+	if q.Size != 2 {
+		t.Errorf("page size does not match, want %d, got %d", 2, q.Size)
+	}
+	if !q.StateSet || !reflect.DeepEqual(q.State, []byte{0x00}) {
+		t.Errorf("page state does not match, want %v, got %v", []byte{0x00}, q.State)
+	}
+	want := types.Response{
+		Draw:            3,
+		RecordsTotal:    5,
+		RecordsFiltered: 5,
+		Data: []types.Row{
+			{Data: map[string]string{"user_id": "42", "message": "hi"}},
+			{Data: map[string]string{"user_id": "42", "message": ""}},
+		},
+		PageState: base64.RawURLEncoding.EncodeToString([]byte{0x01, 0x02}),
+	}
+	if !reflect.DeepEqual(dtResponse, want) {
+		t.Errorf("response does not match, want %+v, got %+v", want, dtResponse)
+	}
+}
+
+func TestTableHandlerCount(t *testing.T) {
+	session := &SessionMock{Count: 7}
+	h := newTestHandler()
+	h.Session = session
+	h.Count = true
+	resp := h.Response(testRequest, nil)
+	if resp.RecordsTotal != 7 || resp.RecordsFiltered != 7 {
+		t.Errorf("counts do not match, want %d, got %d/%d",
+			7, resp.RecordsTotal, resp.RecordsFiltered)
+	}
+	if len(session.Queries) != 3 {
+		t.Fatalf("unexpected number of queries, want %d, got %d",
+			3, len(session.Queries))
+	}
+	if want := `SELECT COUNT(*) FROM "chat"."messages"`; session.Queries[1].Stmt != want {
+		t.Errorf("total statement does not match, want %s, got %s",
+			want, session.Queries[1].Stmt)
+	}
+	if want := `SELECT COUNT(*) FROM "chat"."messages" WHERE "user_id" = ?`; session.Queries[2].Stmt != want {
+		t.Errorf("count statement does not match, want %s, got %s",
+			want, session.Queries[2].Stmt)
+	}
+}
+
+func TestTableHandlerDefaultLength(t *testing.T) {
+	cases := []struct {
+		Name          string
+		DefaultLength int
+		Length        []string
+		Size          int
+		Rows          int
+	}{
+		{Name: "absent", Rows: 3},
+		{Name: "absent-default", DefaultLength: 2, Size: 2, Rows: 2},
+		{Name: "present", DefaultLength: 2, Length: []string{"1"}, Size: 1, Rows: 1},
+		{Name: "present-zero", DefaultLength: 2, Length: []string{"0"}},
+	}
+	for _, c := range cases {
+		session := &SessionMock{
+			Rows: []map[string]interface{}{
+				{"user_id": 1}, {"user_id": 2}, {"user_id": 3},
+			},
+		}
+		h := newTestHandler()
+		h.Session = session
+		h.DefaultLength = c.DefaultLength
+		form := url.Values{
+			"draw":             []string{"1"},
+			"columns[0][data]": []string{"user_id"},
+		}
+		if c.Length != nil {
+			form["length"] = c.Length
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, &http.Request{Method: "GET", URL: &url.URL{Path: "/"}, Form: form})
+		var dtResponse types.Response
+		if err := json.NewDecoder(w.Body).Decode(&dtResponse); err != nil {
+			t.Fatalf("case %s: could not unmarshal response: %v", c.Name, err)
+		}
+		if q := session.Queries[0]; q.Size != c.Size {
+			t.Errorf("case %s: page size does not match, want %d, got %d",
+				c.Name, c.Size, q.Size)
+		}
+		if len(dtResponse.Data) != c.Rows {
+			t.Errorf("case %s: unexpected number of rows, want %d, got %d",
+				c.Name, c.Rows, len(dtResponse.Data))
+		}
+	}
+}
+
+func TestTableHandlerResponseAllPages(t *testing.T) {
+	session := &SessionMock{
+		Pages: [][]map[string]interface{}{
+			{{"user_id": 1}, {"user_id": 2}},
+			{{"user_id": 3}},
+			{{"user_id": 4}},
+		},
+	}
+	h := newTestHandler()
+	h.Session = session
+	r := types.Request{
+		Draw:    1,
+		Length:  -1,
+		Columns: []types.Column{{Data: "user_id"}},
+	}
+	resp := h.Response(r, nil)
+	if resp.Error != "" {
+		t.Fatalf("unexpected error: %s", resp.Error)
+	}
+	if len(resp.Data) != 4 {
+		t.Errorf("unexpected number of rows, want %d, got %d", 4, len(resp.Data))
+	}
+	if len(session.Queries) != 3 {
+		t.Errorf("unexpected number of queries, want %d, got %d",
+			3, len(session.Queries))
+	}
+	if resp.PageState != "" || resp.RecordsFiltered != 4 {
+		t.Errorf("unexpected page state %q or records filtered %d",
+			resp.PageState, resp.RecordsFiltered)
+	}
+}
@@ -0,0 +1,109 @@
+// Package memory provides Datatables processing for in-memory data.
+package memory
+
+import (
+	"regexp"
+	"sort"
+
+	"github.com/basvdlei/godatatables/types"
+)
+
+// Apply filters, sorts and pages the rows according to the Datatables
+// Request. The accessor returns the value of a row for the given column data.
+// It returns the requested page and the number of rows after filtering.
+//
+// The global search is applied to the searchable columns that aren't hidden,
+// like the mongo filter. All searches are case-insensitive. Sorting compares
+// the values as strings.
+func Apply[T any](rows []T, r types.Request, accessor func(T, string) string) ([]T, int) {
+	global := newMatcher(r.Search)
+	columns := make([]*matcher, len(r.Columns))
+	for i, c := range r.Columns {
+		if c.Searchable {
+			columns[i] = newMatcher(c.Search)
+		}
+	}
+	filtered := make([]T, 0, len(rows))
+	for _, row := range rows {
+		if match(row, r, accessor, global, columns) {
+			filtered = append(filtered, row)
+		}
+	}
+	sort.SliceStable(filtered, func(i, j int) bool {
+		for _, o := range r.Order {
+			c, ok := r.OrderColumn(o)
+			if !ok {
+				continue
+			}
+			a, b := accessor(filtered[i], c.Data), accessor(filtered[j], c.Data)
+			if a == b {
+				continue
+			}
+			if o.Dir == types.OrderDescending {
+				return a > b
+			}
+			return a < b
+		}
+		return false
+	})
+	return page(filtered, r.Start, r.Length), len(filtered)
+}
+
+// match reports if the row matches the global and column searches.
+func match[T any](row T, r types.Request, accessor func(T, string) string, global *matcher, columns []*matcher) bool {
+	globalMatch := global == nil
+	for i, c := range r.Columns {
+		if !c.Searchable {
+			continue
+		}
+		v := accessor(row, c.Data)
+		if columns[i] != nil && !columns[i].match(v) {
+			return false
+		}
+		if !globalMatch && c.GlobalSearchable() && global.match(v) {
+			globalMatch = true
+		}
+	}
+	return globalMatch
+}
+
+// page returns the rows in the range of start and length. A negative length
+// returns all rows from start.
+func page[T any](rows []T, start, length int) []T {
+	if start < 0 {
+		start = 0
+	}
+	if start > len(rows) {
+		start = len(rows)
+	}
+	end := len(rows)
+	if length >= 0 && length < end-start {
+		end = start + length
+	}
+	return rows[start:end]
+}
+
+// matcher matches values against a search.
+type matcher struct {
+	re *regexp.Regexp
+}
+
+// newMatcher returns the matcher for the search or nil if there is nothing
+// to search for. Invalid regular expressions are matched literally.
+func newMatcher(s types.Search) *matcher {
+	if s.Value == "" {
+		return nil
+	}
+	pattern := regexp.QuoteMeta(s.Value)
+	if s.Regex {
+		if _, err := regexp.Compile(s.Value); err == nil {
+			pattern = s.Value
+		}
+	}
+	return &matcher{re: regexp.MustCompile("(?i)" + pattern)}
+}
+
+// match reports if the value matches.
+func (m *matcher) match(v string) bool {
+	return m.re.MatchString(v)
+}
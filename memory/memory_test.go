@@ -0,0 +1,168 @@
+package memory
+
+import (
+	"math"
+	"reflect"
+	"strconv"
+	"testing"
+
+	"github.com/basvdlei/godatatables/types"
+)
+
+type person struct {
+	Name string
+	City string
+	Age  int
+}
+
+func personField(p person, field string) string {
+	switch field {
+	case "name":
+		return p.Name
+	case "city":
+		return p.City
+	case "age":
+		return strconv.Itoa(p.Age)
+	}
+	return ""
+}
+
+var people = []person{
+	{Name: "Airi", City: "Tokyo", Age: 33},
+	{Name: "Angelica", City: "London", Age: 47},
+	{Name: "Ashton", City: "San Francisco", Age: 66},
+	{Name: "Bradley", City: "London", Age: 41},
+	{Name: "Brielle", City: "New York", Age: 61},
+}
+
+var hidden = false
+
+var columns = []types.Column{
+	{Data: "name", Searchable: true, Orderable: true},
+	{Data: "city", Searchable: true, Orderable: true},
+	{Data: "age", Searchable: false, Orderable: true},
+}
+
+type ApplyTestCase struct {
+	Name     string
+	Request  types.Request
+	Page     []person
+	Filtered int
+}
+
+var ApplyTests = []ApplyTestCase{
+	{
+		Name: "first-page",
+		Request: types.Request{
+			Start:   0,
+			Length:  2,
+			Columns: columns,
+		},
+		Page:     people[:2],
+		Filtered: 5,
+	},
+	{
+		Name: "global-search-sorted",
+		Request: types.Request{
+			Start:  0,
+			Length: 10,
+			Search: types.Search{Value: "lon"},
+			Order: []types.Order{
+				{Column: 0, Dir: types.OrderDescending},
+			},
+			Columns: columns,
+		},
+		Page:     []person{people[3], people[1]},
+		Filtered: 2,
+	},
+	{
+		Name: "global-search-not-searchable",
+		Request: types.Request{
+			Start:   0,
+			Length:  10,
+			Search:  types.Search{Value: "66"},
+			Columns: columns,
+		},
+		Page:     []person{},
+		Filtered: 0,
+	},
+	{
+		Name: "global-search-hidden",
+		Request: types.Request{
+			Start:  0,
+			Length: 10,
+			Search: types.Search{Value: "lon"},
+			Columns: []types.Column{
+				columns[0],
+				{Data: "city", Searchable: true, Visible: &hidden},
+				columns[2],
+			},
+		},
+		Page:     []person{},
+		Filtered: 0,
+	},
+	{
+		Name: "column-regex-search",
+		Request: types.Request{
+			Start:  0,
+			Length: -1,
+			Columns: []types.Column{
+				{
+					Data:       "name",
+					Searchable: true,
+					Search:     types.Search{Value: "^b", Regex: true},
+				},
+				columns[1],
+				columns[2],
+			},
+			Order: []types.Order{
+				{Column: 2, Dir: types.OrderAscending},
+			},
+		},
+		Page:     []person{people[3], people[4]},
+		Filtered: 2,
+	},
+	{
+		Name: "multi-order-second-page",
+		Request: types.Request{
+			Start:  2,
+			Length: 2,
+			Order: []types.Order{
+				{Column: 1, Dir: types.OrderAscending},
+				{Column: 0, Dir: types.OrderDescending},
+			},
+			Columns: columns,
+		},
+		Page:     []person{people[4], people[2]},
+		Filtered: 5,
+	},
+	{
+		Name: "huge-length",
+		Request: types.Request{
+			Start:   1,
+			Length:  math.MaxInt,
+			Columns: columns,
+		},
+		Page:     people[1:],
+		Filtered: 5,
+	},
+}
+
+func TestApply(t *testing.T) {
+	for _, c := range ApplyTests {
+		in := make([]person, len(people))
+		copy(in, people)
+		page, filtered := Apply(in, c.Request, personField)
+		if !reflect.DeepEqual(page, c.Page) {
+			t.Errorf("case %s: page does not match, want %+v, got %+v",
+				c.Name, c.Page, page)
+		}
+		if filtered != c.Filtered {
+			t.Errorf("case %s: filtered count does not match, want %d, got %d",
+				c.Name, c.Filtered, filtered)
+		}
+		if !reflect.DeepEqual(in, people) {
+			t.Errorf("case %s: input rows were modified", c.Name)
+		}
+	}
+}
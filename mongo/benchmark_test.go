@@ -0,0 +1,106 @@
+package mongo
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/basvdlei/godatatables/types"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// benchmarkRequest returns a Request with n searchable columns.
+func benchmarkRequest(n int) types.Request {
+	r := types.Request{Columns: make([]types.Column, n)}
+	for i := range r.Columns {
+		r.Columns[i] = types.Column{
+			Data:       "field" + strconv.Itoa(i),
+			Searchable: true,
+			Orderable:  true,
+		}
+	}
+	return r
+}
+
+func BenchmarkCreateFilter(b *testing.B) {
+	for _, n := range []int{5, 50} {
+		noSearch := benchmarkRequest(n)
+		column := benchmarkRequest(n)
+		column.Columns[0].Search.Value = "foo"
+		global := benchmarkRequest(n)
+		global.Search.Value = "foo"
+		cases := []struct {
+			Name    string
+			Request types.Request
+		}{
+			{Name: "no-search", Request: noSearch},
+			{Name: "column", Request: column},
+			{Name: "global", Request: global},
+		}
+		for _, c := range cases {
+			b.Run(strconv.Itoa(n)+"/"+c.Name, func(b *testing.B) {
+				b.ReportAllocs()
+				for i := 0; i < b.N; i++ {
+					CreateFilter(c.Request)
+				}
+			})
+		}
+	}
+}
+
+func TestCreateFilterAllocs(t *testing.T) {
+	noSearch := benchmarkRequest(50)
+	column := benchmarkRequest(50)
+	column.Columns[0].Search.Value = "foo"
+	cases := []struct {
+		Name    string
+		Request types.Request
+		Max     float64
+	}{
+		{Name: "no-search", Request: noSearch, Max: 1},
+		{Name: "column", Request: column, Max: 4},
+	}
+	for _, c := range cases {
+		n := testing.AllocsPerRun(100, func() {
+			CreateFilter(c.Request)
+		})
+		if n > c.Max {
+			t.Errorf("case %s: too many allocations, want at most %v, got %v",
+				c.Name, c.Max, n)
+		}
+	}
+}
+
+// wideRequest returns a Request with a global search on n columns of which
+// only the first searchable columns are searchable, like a wide table with
+// mostly display-only columns.
+func wideRequest(n, searchable int) types.Request {
+	r := benchmarkRequest(n)
+	for i := searchable; i < n; i++ {
+		r.Columns[i].Searchable = false
+	}
+	r.Search.Value = "foo"
+	return r
+}
+
+func BenchmarkCreateFilterWideTable(b *testing.B) {
+	for _, searchable := range []int{100, 3} {
+		r := wideRequest(100, searchable)
+		b.Run(strconv.Itoa(searchable)+"-searchable", func(b *testing.B) {
+			b.ReportAllocs()
+			var f bson.M
+			for i := 0; i < b.N; i++ {
+				f = CreateFilter(r)
+			}
+			clauses, _ := f["$or"].([]bson.M)
+			b.ReportMetric(float64(len(clauses)), "clauses")
+		})
+	}
+}
+
+func TestCreateFilterWideTable(t *testing.T) {
+	f := CreateFilter(wideRequest(100, 3))
+	clauses, _ := f["$or"].([]bson.M)
+	if len(f) != 1 || len(clauses) != 3 {
+		t.Errorf("filter is not compact, want an $or of %d clauses, got %+v", 3, f)
+	}
+}
@@ -0,0 +1,80 @@
+package mongo
+
+import (
+	"strings"
+
+	"github.com/basvdlei/godatatables/types"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// ChangeStreamPipeline returns the change stream pipeline matching the
+// changed documents that match the filter of the Datatables Request, for
+// live updating tables. Updates only contain the document when the change
+// stream is opened with the `updateLookup` full document option, and
+// deletes never match since they have no document.
+func (o FilterOptions) ChangeStreamPipeline(r types.Request) []bson.M {
+	return []bson.M{ChangeStreamMatch(o.CreateFilter(r))}
+}
+
+// ChangeStreamMatch returns the change stream $match stage for the filter,
+// which has its field paths moved under the `fullDocument` of the change
+// events. Change streams don't support `$text` queries, so these are left
+// out and the stage matches all changes for the rest of the filter.
+func ChangeStreamMatch(f bson.M) bson.M {
+	return bson.M{"$match": fullDocumentFilter(f)}
+}
+
+// fullDocumentFilter returns a copy of the filter with the field paths
+// prefixed by `fullDocument.`. Logical operators are rewritten recursively
+// and the field paths of `$expr` aggregation expressions are rewritten to
+// `$fullDocument.` paths. `$text` queries are dropped.
+func fullDocumentFilter(f bson.M) bson.M {
+	out := make(bson.M, len(f))
+	for k, v := range f {
+		switch {
+		case k == "$and" || k == "$or" || k == "$nor":
+			if filters, ok := v.([]bson.M); ok {
+				rewritten := make([]bson.M, len(filters))
+				for i, f := range filters {
+					rewritten[i] = fullDocumentFilter(f)
+				}
+				v = rewritten
+			}
+			out[k] = v
+		case k == "$text":
+			continue
+		case k == "$expr":
+			out[k] = fullDocumentExpr(v)
+		case strings.HasPrefix(k, "$"):
+			out[k] = v
+		default:
+			out["fullDocument."+k] = v
+		}
+	}
+	return out
+}
+
+// fullDocumentExpr returns a copy of the aggregation expression with the
+// `$field` paths prefixed by `fullDocument.`. Variables (`$$var`) are kept.
+func fullDocumentExpr(v interface{}) interface{} {
+	switch v := v.(type) {
+	case string:
+		if strings.HasPrefix(v, "$") && !strings.HasPrefix(v, "$$") {
+			return "$fullDocument." + v[1:]
+		}
+		return v
+	case bson.M:
+		out := make(bson.M, len(v))
+		for k, e := range v {
+			out[k] = fullDocumentExpr(e)
+		}
+		return out
+	case []interface{}:
+		out := make([]interface{}, len(v))
+		for i, e := range v {
+			out[i] = fullDocumentExpr(e)
+		}
+		return out
+	}
+	return v
+}
@@ -0,0 +1,115 @@
+package mongo
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/basvdlei/godatatables/types"
+	"gopkg.in/mgo.v2/bson"
+)
+
+func TestChangeStreamMatch(t *testing.T) {
+	cases := []struct {
+		Name   string
+		Filter bson.M
+		Want   bson.M
+	}{
+		{
+			Name:   "empty",
+			Filter: bson.M{},
+			Want:   bson.M{"$match": bson.M{}},
+		},
+		{
+			Name: "nested",
+			Filter: bson.M{"$and": []bson.M{
+				{"$or": []bson.M{
+					{"name": bson.RegEx{Pattern: "foo", Options: "i"}},
+					{"address.city": bson.RegEx{Pattern: "foo", Options: "i"}},
+				}},
+				{"active": bson.M{"$eq": true}},
+			}},
+			Want: bson.M{"$match": bson.M{"$and": []bson.M{
+				{"$or": []bson.M{
+					{"fullDocument.name": bson.RegEx{Pattern: "foo", Options: "i"}},
+					{"fullDocument.address.city": bson.RegEx{Pattern: "foo", Options: "i"}},
+				}},
+				{"fullDocument.active": bson.M{"$eq": true}},
+			}}},
+		},
+		{
+			Name: "expr",
+			Filter: bson.M{
+				"$expr": bson.M{"$regexMatch": bson.M{
+					"input": bson.M{"$concat": []interface{}{
+						bson.M{"$ifNull": []interface{}{"$first_name", ""}},
+						" ",
+						"$$ROOT.last_name",
+					}},
+					"regex": bson.RegEx{Pattern: "john"},
+				}},
+				"$comment": "datatables",
+			},
+			Want: bson.M{"$match": bson.M{
+				"$expr": bson.M{"$regexMatch": bson.M{
+					"input": bson.M{"$concat": []interface{}{
+						bson.M{"$ifNull": []interface{}{"$fullDocument.first_name", ""}},
+						" ",
+						"$$ROOT.last_name",
+					}},
+					"regex": bson.RegEx{Pattern: "john"},
+				}},
+				"$comment": "datatables",
+			}},
+		},
+		{
+			Name: "text",
+			Filter: bson.M{"$and": []bson.M{
+				{"$text": bson.M{"$search": "coffee"}},
+				{"active": true},
+			}},
+			Want: bson.M{"$match": bson.M{"$and": []bson.M{
+				{},
+				{"fullDocument.active": true},
+			}}},
+		},
+	}
+	for _, c := range cases {
+		m := ChangeStreamMatch(c.Filter)
+		if !reflect.DeepEqual(m, c.Want) {
+			t.Errorf("case %s: match does not match, want %+v, got %+v",
+				c.Name, c.Want, m)
+		}
+	}
+}
+
+func TestChangeStreamPipeline(t *testing.T) {
+	r := types.Request{
+		Columns: []types.Column{
+			{Data: "name", Searchable: true, Search: types.Search{Value: "foo"}},
+		},
+	}
+	want := []bson.M{
+		{"$match": bson.M{
+			"fullDocument.name": bson.RegEx{Pattern: "foo", Options: "i"},
+		}},
+	}
+	p := FilterOptions{}.ChangeStreamPipeline(r)
+	if !reflect.DeepEqual(p, want) {
+		t.Errorf("pipeline does not match, want %+v, got %+v", want, p)
+	}
+}
+
+func TestChangeStreamPipelineTextSearch(t *testing.T) {
+	r := types.Request{
+		Search: types.Search{Value: "coffee"},
+		Columns: []types.Column{
+			{Data: "name", Searchable: true},
+		},
+	}
+	p := FilterOptions{TextSearch: true}.ChangeStreamPipeline(r)
+	if strings.Contains(fmt.Sprint(p), "$text") {
+		t.Errorf("pipeline contains a $text query: %+v", p)
+	}
+}
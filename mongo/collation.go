@@ -0,0 +1,255 @@
+package mongo
+
+import (
+	"net/http"
+	"strings"
+
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// CollationCollection is implemented by collections that can be queried with
+// a collation, like the collections of NewCollectionHandler.
+type CollationCollection interface {
+	Collection
+	FindCollation(query interface{}, collation *mgo.Collation) Query
+}
+
+// FindCollation returns a Query using the collation. Since *mgo.Query does
+// not support collations, the query runs the find and count commands itself.
+func (cw *collectionWrapper) FindCollation(query interface{}, collation *mgo.Collation) Query {
+	return &commandQuery{
+		c:         cw.c,
+		filter:    query,
+		collation: collation,
+	}
+}
+
+// collation returns the collation of the request, which is the Collation
+// with the locale of the LocaleFunc if set.
+func (ch *CollectionHandler) collation(r *http.Request) *mgo.Collation {
+	if ch.LocaleFunc == nil {
+		return ch.Collation
+	}
+	locale := ch.LocaleFunc(r)
+	if !validLocale(locale) {
+		return ch.Collation
+	}
+	var c mgo.Collation
+	if ch.Collation != nil {
+		c = *ch.Collation
+	}
+	c.Locale = locale
+	return &c
+}
+
+// validLocale reports if the locale only consists of the characters of ICU
+// locales like "de", "zh_Hant" or "de@collation=phonebook". It keeps clients
+// from injecting anything else into the collation.
+func validLocale(locale string) bool {
+	if locale == "" || len(locale) > 64 {
+		return false
+	}
+	for _, r := range locale {
+		switch {
+		case 'a' <= r && r <= 'z', 'A' <= r && r <= 'Z', '0' <= r && r <= '9':
+		case r == '_' || r == '@' || r == '=' || r == '-':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// AcceptLanguageLocale is a LocaleFunc returning the language of the first
+// language range of the Accept-Language header, eg "de" for "de-CH, en;q=0.5".
+// Only the language is used since MongoDB rejects most regional locales.
+func AcceptLanguageLocale(r *http.Request) string {
+	for _, v := range strings.Split(r.Header.Get("Accept-Language"), ",") {
+		tag := strings.TrimSpace(strings.SplitN(v, ";", 2)[0])
+		if tag == "" || tag == "*" {
+			continue
+		}
+		return strings.ToLower(strings.SplitN(tag, "-", 2)[0])
+	}
+	return ""
+}
+
+// ParamLocale returns a LocaleFunc returning the value of the query
+// parameter, eg ParamLocale("locale") for "?locale=sv".
+func ParamLocale(param string) func(r *http.Request) string {
+	return func(r *http.Request) string {
+		return r.FormValue(param)
+	}
+}
+
+// commandQuery is a Query that runs the find and count commands directly, for
+// the options that *mgo.Query does not support.
+type commandQuery struct {
+	c         *mgo.Collection
+	filter    interface{}
+	collation *mgo.Collation
+	sort      []string
+	hint      []string
+	skip      int
+	limit     int
+	batch     int
+}
+
+// All runs the find command and unmarshals all results.
+func (q *commandQuery) All(result interface{}) error {
+	return q.iter().All(result)
+}
+
+// Batch sets the batch size of the find command.
+func (q *commandQuery) Batch(n int) Query {
+	c := *q
+	c.batch = n
+	return &c
+}
+
+// Count runs the count command.
+func (q *commandQuery) Count() (n int, err error) {
+	var result struct {
+		N int `bson:"n"`
+	}
+	err = q.c.Database.Run(q.countCommand(), &result)
+	return result.N, err
+}
+
+// Distinct runs the distinct command.
+func (q *commandQuery) Distinct(key string, result interface{}) error {
+	cmd := bson.D{
+		{Name: "distinct", Value: q.c.Name},
+		{Name: "key", Value: key},
+		{Name: "query", Value: q.filter},
+	}
+	if q.collation != nil {
+		cmd = append(cmd, bson.DocElem{Name: "collation", Value: q.collation})
+	}
+	var doc struct {
+		Values bson.Raw `bson:"values"`
+	}
+	if err := q.c.Database.Run(cmd, &doc); err != nil {
+		return err
+	}
+	return doc.Values.Unmarshal(result)
+}
+
+// Hint sets the index of the commands.
+func (q *commandQuery) Hint(indexKey ...string) Query {
+	c := *q
+	c.hint = indexKey
+	return &c
+}
+
+// Iter runs the find command.
+func (q *commandQuery) Iter() Iter {
+	return q.iter()
+}
+
+// Limit sets the limit of the commands.
+func (q *commandQuery) Limit(n int) Query {
+	c := *q
+	c.limit = n
+	return &c
+}
+
+// Skip sets the number of documents the commands skip.
+func (q *commandQuery) Skip(n int) Query {
+	c := *q
+	c.skip = n
+	return &c
+}
+
+// Sort sets the sort of the find command, using the field format of
+// *mgo.Query.Sort().
+func (q *commandQuery) Sort(fields ...string) Query {
+	c := *q
+	c.sort = fields
+	return &c
+}
+
+// iter runs the find command and returns the iterator over the cursor. Like
+// *mgo.Pipe the command runs on a non eventual session, so the cursor is
+// read from the same server.
+func (q *commandQuery) iter() *mgo.Iter {
+	session := q.c.Database.Session
+	cloned := session.Clone()
+	defer cloned.Close()
+	if cloned.Mode() == mgo.Eventual {
+		cloned.SetMode(mgo.Monotonic, false)
+	}
+	c := q.c.With(cloned)
+	var result struct {
+		Cursor struct {
+			FirstBatch []bson.Raw `bson:"firstBatch"`
+			ID         int64      `bson:"id"`
+		} `bson:"cursor"`
+	}
+	err := c.Database.Run(q.findCommand(), &result)
+	return c.NewIter(session, result.Cursor.FirstBatch, result.Cursor.ID, err)
+}
+
+// findCommand returns the find command of the query.
+func (q *commandQuery) findCommand() bson.D {
+	cmd := bson.D{
+		{Name: "find", Value: q.c.Name},
+		{Name: "filter", Value: q.filter},
+	}
+	if len(q.sort) > 0 {
+		cmd = append(cmd, bson.DocElem{Name: "sort", Value: keyDocument(q.sort)})
+	}
+	cmd = append(cmd, q.options()...)
+	if q.batch > 0 {
+		cmd = append(cmd, bson.DocElem{Name: "batchSize", Value: q.batch})
+	}
+	return cmd
+}
+
+// countCommand returns the count command of the query.
+func (q *commandQuery) countCommand() bson.D {
+	cmd := bson.D{
+		{Name: "count", Value: q.c.Name},
+		{Name: "query", Value: q.filter},
+	}
+	return append(cmd, q.options()...)
+}
+
+// options returns the options shared by the find and count commands.
+func (q *commandQuery) options() bson.D {
+	var opts bson.D
+	if len(q.hint) > 0 {
+		opts = append(opts, bson.DocElem{Name: "hint", Value: keyDocument(q.hint)})
+	}
+	if q.skip > 0 {
+		opts = append(opts, bson.DocElem{Name: "skip", Value: q.skip})
+	}
+	if q.limit > 0 {
+		opts = append(opts, bson.DocElem{Name: "limit", Value: q.limit})
+	}
+	if q.collation != nil {
+		opts = append(opts, bson.DocElem{Name: "collation", Value: q.collation})
+	}
+	return opts
+}
+
+// keyDocument returns the document of the key fields in the format used by
+// *mgo.Query.Sort(), where a `-` prefix indicates descending order and a
+// `$textScore:` prefix the text score.
+func keyDocument(fields []string) bson.D {
+	doc := make(bson.D, len(fields))
+	for i, f := range fields {
+		if strings.HasPrefix(f, textScorePrefix) {
+			doc[i] = bson.DocElem{
+				Name:  strings.TrimPrefix(f, textScorePrefix),
+				Value: bson.M{"$meta": "textScore"},
+			}
+		} else if strings.HasPrefix(f, "-") {
+			doc[i] = bson.DocElem{Name: f[1:], Value: -1}
+		} else {
+			doc[i] = bson.DocElem{Name: strings.TrimPrefix(f, "+"), Value: 1}
+		}
+	}
+	return doc
+}
@@ -0,0 +1,191 @@
+package mongo
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"testing"
+
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+type CollationCollectionMock struct {
+	*CollectionMock
+	collation *mgo.Collation
+}
+
+func (c *CollationCollectionMock) FindCollation(query interface{}, collation *mgo.Collation) Query {
+	c.collation = collation
+	return c.Find(query)
+}
+
+func TestCollectionHandlerCollation(t *testing.T) {
+	collation := &mgo.Collation{Locale: "en", Strength: 1}
+	collection := &CollationCollectionMock{
+		CollectionMock: &CollectionMock{query: &QueryMock{}},
+	}
+	ch := &CollectionHandler{
+		Collection: collection,
+		Collation:  collation,
+		Filter: FilterOptions{
+			ExactColumns: map[string]bool{"title": true},
+		},
+	}
+	req := &http.Request{
+		Method: "GET",
+		URL:    &url.URL{Path: "/"},
+		Form: url.Values{
+			"draw":                      []string{"1"},
+			"columns[0][data]":          []string{"title"},
+			"columns[0][searchable]":    []string{"true"},
+			"columns[0][search][value]": []string{"resume"},
+		},
+	}
+	ch.ServeHTTP(httptest.NewRecorder(), req)
+	if collection.collation != collation {
+		t.Errorf("collation not applied, want %+v, got %+v",
+			collation, collection.collation)
+	}
+	want := bson.M{"title": bson.M{"$eq": "resume"}}
+	if !reflect.DeepEqual(collection.filter, want) {
+		t.Errorf("filter does not match, want %+v, got %+v",
+			want, collection.filter)
+	}
+}
+
+func TestCollectionHandlerLocaleFunc(t *testing.T) {
+	base := &mgo.Collation{Locale: "en", Strength: 1}
+	cases := []struct {
+		Name       string
+		Collation  *mgo.Collation
+		LocaleFunc func(r *http.Request) string
+		Header     string
+		Param      string
+		Want       *mgo.Collation
+	}{
+		{
+			Name:       "accept-language",
+			Collation:  base,
+			LocaleFunc: AcceptLanguageLocale,
+			Header:     "de-CH, en;q=0.5",
+			Want:       &mgo.Collation{Locale: "de", Strength: 1},
+		},
+		{
+			Name:       "accept-language-wildcard",
+			Collation:  base,
+			LocaleFunc: AcceptLanguageLocale,
+			Header:     "*, sv;q=0.8",
+			Want:       &mgo.Collation{Locale: "sv", Strength: 1},
+		},
+		{
+			Name:       "param",
+			Collation:  base,
+			LocaleFunc: ParamLocale("locale"),
+			Param:      "fr",
+			Want:       &mgo.Collation{Locale: "fr", Strength: 1},
+		},
+		{
+			Name:       "param-without-collation",
+			LocaleFunc: ParamLocale("locale"),
+			Param:      "de@collation=phonebook",
+			Want:       &mgo.Collation{Locale: "de@collation=phonebook"},
+		},
+		{
+			Name:       "no-locale",
+			Collation:  base,
+			LocaleFunc: ParamLocale("locale"),
+			Want:       base,
+		},
+		{
+			Name:       "invalid-locale",
+			Collation:  base,
+			LocaleFunc: ParamLocale("locale"),
+			Param:      "fr\"}",
+			Want:       base,
+		},
+		{
+			Name:       "no-collation",
+			LocaleFunc: AcceptLanguageLocale,
+		},
+	}
+	for _, c := range cases {
+		collection := &CollationCollectionMock{
+			CollectionMock: &CollectionMock{query: &QueryMock{}},
+		}
+		ch := &CollectionHandler{
+			Collection: collection,
+			Collation:  c.Collation,
+			LocaleFunc: c.LocaleFunc,
+		}
+		req := &http.Request{
+			Method: "GET",
+			URL:    &url.URL{Path: "/"},
+			Header: http.Header{},
+			Form: url.Values{
+				"draw":             []string{"1"},
+				"columns[0][data]": []string{"title"},
+			},
+		}
+		if c.Header != "" {
+			req.Header.Set("Accept-Language", c.Header)
+		}
+		if c.Param != "" {
+			req.Form.Set("locale", c.Param)
+		}
+		ch.ServeHTTP(httptest.NewRecorder(), req)
+		if !reflect.DeepEqual(collection.collation, c.Want) {
+			t.Errorf("case %s: collation does not match, want %+v, got %+v",
+				c.Name, c.Want, collection.collation)
+		}
+	}
+	if base.Locale != "en" {
+		t.Errorf("handler collation modified, got %+v", base)
+	}
+}
+
+func TestCommandQuery(t *testing.T) {
+	collation := &mgo.Collation{Locale: "fr", Strength: 1}
+	cw := &collectionWrapper{c: &mgo.Collection{Name: "people"}}
+	f := bson.M{"title": bson.M{"$eq": "resume"}}
+	q := cw.FindCollation(f, collation).
+		Sort("-created", "name").
+		Skip(10).
+		Limit(5).
+		Hint("title").
+		Batch(100).(*commandQuery)
+	wantFind := bson.D{
+		{Name: "find", Value: "people"},
+		{Name: "filter", Value: f},
+		{Name: "sort", Value: bson.D{
+			{Name: "created", Value: -1},
+			{Name: "name", Value: 1},
+		}},
+		{Name: "hint", Value: bson.D{{Name: "title", Value: 1}}},
+		{Name: "skip", Value: 10},
+		{Name: "limit", Value: 5},
+		{Name: "collation", Value: collation},
+		{Name: "batchSize", Value: 100},
+	}
+	if cmd := q.findCommand(); !reflect.DeepEqual(cmd, wantFind) {
+		t.Errorf("find command does not match, want %+v, got %+v", wantFind, cmd)
+	}
+	wantCount := bson.D{
+		{Name: "count", Value: "people"},
+		{Name: "query", Value: f},
+		{Name: "hint", Value: bson.D{{Name: "title", Value: 1}}},
+		{Name: "skip", Value: 10},
+		{Name: "limit", Value: 5},
+		{Name: "collation", Value: collation},
+	}
+	if cmd := q.countCommand(); !reflect.DeepEqual(cmd, wantCount) {
+		t.Errorf("count command does not match, want %+v, got %+v", wantCount, cmd)
+	}
+	// The options return copies, like a fresh query for every request.
+	base := cw.FindCollation(f, collation).(*commandQuery)
+	base.Limit(1)
+	if base.limit != 0 {
+		t.Errorf("query was modified")
+	}
+}
@@ -0,0 +1,53 @@
+package mongo
+
+import (
+	"github.com/basvdlei/godatatables/types"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// ColumnFilters returns the filters of the active column searches of the
+// Datatables Request by column data, each matching just that column. Columns
+// whose search is ignored are left out.
+func (o FilterOptions) ColumnFilters(r types.Request) map[string]bson.M {
+	var filters map[string]bson.M
+	for _, c := range r.Columns {
+		if !c.Searchable || c.Search.Value == "" {
+			continue
+		}
+		if _, ok := filters[c.Data]; ok {
+			continue
+		}
+		m, ok := o.columnFilter(c)
+		if !ok {
+			continue
+		}
+		if filters == nil {
+			filters = make(map[string]bson.M)
+		}
+		filters[c.Data] = m
+	}
+	return filters
+}
+
+// columnCounts counts the documents matching each of the column filters on
+// its own. The modified since clause, view filter and FilterHook are applied
+// to every filter, so the counts are restricted in the same way as the
+// records.
+func (ch *CollectionHandler) columnCounts(c Collection, r types.Request, p queryParams) (map[string]int, error) {
+	filters := p.opts.ColumnFilters(r)
+	if len(filters) == 0 {
+		return nil, nil
+	}
+	counts := make(map[string]int, len(filters))
+	for data, f := range filters {
+		if p.polling {
+			f = ModifiedSinceFilter(f, ch.ModifiedField, p.since)
+		}
+		n, err := ch.count(c, p.restrict(f), p.collation, 0)
+		if err != nil {
+			return counts, err
+		}
+		counts[data] = n
+	}
+	return counts, nil
+}
@@ -0,0 +1,179 @@
+package mongo
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"reflect"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/basvdlei/godatatables/types"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+var columnCountsFixture = []map[string]string{
+	{"name": "Airi Satou", "office": "Tokyo", "position": "Accountant", "updated": "2017-05-01T00:00:00Z"},
+	{"name": "Angelica Ramos", "office": "London", "position": "Chief Executive Officer", "updated": "2017-06-01T00:00:00Z"},
+	{"name": "Ashton Cox", "office": "San Francisco", "position": "Junior Technical Author", "updated": "2017-05-01T00:00:00Z"},
+	{"name": "Bradley Greer", "office": "London", "position": "Software Engineer", "updated": "2017-05-01T00:00:00Z"},
+	{"name": "Brenden Wagner", "office": "San Francisco", "position": "Software Engineer", "updated": "2017-06-01T00:00:00Z"},
+}
+
+// FixtureCollectionMock counts the fixture documents matching the filters,
+// supporting the equality, regular expression, `$gt` time, `$and` and `$or`
+// filters.
+type FixtureCollectionMock struct {
+	docs    []map[string]string
+	filters []bson.M
+}
+
+func (c *FixtureCollectionMock) Count() (n int, err error) {
+	return len(c.docs), nil
+}
+func (c *FixtureCollectionMock) Find(query interface{}) Query {
+	f := query.(bson.M)
+	c.filters = append(c.filters, f)
+	n := 0
+	for _, d := range c.docs {
+		if matchFixture(d, f) {
+			n++
+		}
+	}
+	return &QueryMock{CountValue: n}
+}
+
+func matchFixture(d map[string]string, f bson.M) bool {
+	for k, v := range f {
+		switch k {
+		case "$and", "$or":
+			any := false
+			for _, sub := range v.([]bson.M) {
+				m := matchFixture(d, sub)
+				if k == "$and" && !m {
+					return false
+				}
+				any = any || m
+			}
+			if k == "$or" && !any {
+				return false
+			}
+		default:
+			if s, ok := v.(string); ok {
+				if d[k] != s {
+					return false
+				}
+				continue
+			}
+			if m, ok := v.(bson.M); ok {
+				t, _ := time.Parse(time.RFC3339, d[k])
+				if !t.After(m["$gt"].(time.Time)) {
+					return false
+				}
+				continue
+			}
+			re := v.(bson.RegEx)
+			if !regexp.MustCompile("(?" + re.Options + ")" + re.Pattern).MatchString(d[k]) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func TestCollectionHandlerColumnCounts(t *testing.T) {
+	collection := &FixtureCollectionMock{docs: columnCountsFixture}
+	ch := &CollectionHandler{
+		Collection:   collection,
+		ColumnCounts: true,
+	}
+	req := httptest.NewRequest("GET", "/?draw=1"+
+		"&columns[0][data]=name&columns[0][searchable]=true"+
+		"&columns[1][data]=office&columns[1][searchable]=true"+
+		"&columns[1][search][value]=london"+
+		"&columns[2][data]=position&columns[2][searchable]=true"+
+		"&columns[2][search][value]=engineer"+
+		"&columns[3][data]=salary&columns[3][searchable]=false"+
+		"&columns[3][search][value]=100", nil)
+	w := httptest.NewRecorder()
+	ch.ServeHTTP(w, req)
+	var dtResponse types.Response
+	if err := json.NewDecoder(w.Body).Decode(&dtResponse); err != nil {
+		t.Fatalf("could not unmarshal response: %v", err)
+	}
+	if dtResponse.RecordsFiltered != 1 {
+		t.Errorf("unexpected records filtered %d", dtResponse.RecordsFiltered)
+	}
+	want := map[string]int{"office": 2, "position": 2}
+	if !reflect.DeepEqual(dtResponse.ColumnCounts, want) {
+		t.Errorf("column counts do not match, want %v, got %v",
+			want, dtResponse.ColumnCounts)
+	}
+
+	// The filter hook restricts the column counts as well.
+	ch.FilterHook = func(f bson.M) bson.M {
+		return bson.M{"$and": []bson.M{
+			f,
+			{"name": bson.RegEx{Pattern: "^B"}},
+		}}
+	}
+	w = httptest.NewRecorder()
+	ch.ServeHTTP(w, req)
+	dtResponse = types.Response{}
+	if err := json.NewDecoder(w.Body).Decode(&dtResponse); err != nil {
+		t.Fatalf("could not unmarshal response: %v", err)
+	}
+	want = map[string]int{"office": 1, "position": 2}
+	if !reflect.DeepEqual(dtResponse.ColumnCounts, want) {
+		t.Errorf("column counts do not match, want %v, got %v",
+			want, dtResponse.ColumnCounts)
+	}
+}
+
+func TestCollectionHandlerColumnCountsModifiedSince(t *testing.T) {
+	ch := &CollectionHandler{
+		Collection:    &FixtureCollectionMock{docs: columnCountsFixture},
+		ColumnCounts:  true,
+		ModifiedField: "updated",
+	}
+	req := httptest.NewRequest("GET", "/?draw=1"+
+		"&modifiedSince=2017-05-27T10:00:00Z"+
+		"&columns[0][data]=office&columns[0][searchable]=true"+
+		"&columns[0][search][value]=london"+
+		"&columns[1][data]=position&columns[1][searchable]=true"+
+		"&columns[1][search][value]=engineer", nil)
+	w := httptest.NewRecorder()
+	ch.ServeHTTP(w, req)
+	var dtResponse types.Response
+	if err := json.NewDecoder(w.Body).Decode(&dtResponse); err != nil {
+		t.Fatalf("could not unmarshal response: %v", err)
+	}
+	if dtResponse.RecordsFiltered != 0 {
+		t.Errorf("unexpected records filtered %d", dtResponse.RecordsFiltered)
+	}
+	// The column counts only count the modified documents as well.
+	want := map[string]int{"office": 1, "position": 1}
+	if !reflect.DeepEqual(dtResponse.ColumnCounts, want) {
+		t.Errorf("column counts do not match, want %v, got %v",
+			want, dtResponse.ColumnCounts)
+	}
+}
+
+func TestCollectionHandlerColumnCountsDisabled(t *testing.T) {
+	ch := &CollectionHandler{
+		Collection: &FixtureCollectionMock{docs: columnCountsFixture},
+	}
+	req := httptest.NewRequest("GET", "/?draw=1"+
+		"&columns[0][data]=office&columns[0][searchable]=true"+
+		"&columns[0][search][value]=london", nil)
+	w := httptest.NewRecorder()
+	ch.ServeHTTP(w, req)
+	var raw map[string]interface{}
+	if err := json.NewDecoder(w.Body).Decode(&raw); err != nil {
+		t.Fatalf("could not unmarshal response: %v", err)
+	}
+	if _, ok := raw["_columnCounts"]; ok {
+		t.Errorf("unexpected column counts in response: %v", raw)
+	}
+}
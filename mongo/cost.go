@@ -0,0 +1,42 @@
+package mongo
+
+import (
+	"errors"
+	"unicode/utf8"
+
+	"github.com/basvdlei/godatatables/types"
+)
+
+// ErrTooExpensive is returned for requests whose estimated cost exceeds the
+// MaxCost of the handler.
+var ErrTooExpensive = errors.New("request exceeds the cost budget")
+
+// UnboundedRows is the number of rows EstimateCost counts for requests of
+// all records.
+const UnboundedRows = 100000
+
+// EstimateCost returns a rough estimate of the cost of the Datatables
+// Request. It is the sum of:
+//
+//   - the number of requested rows, or UnboundedRows for all records;
+//   - 10 for every column;
+//   - 10 for every character of a search times the number of columns it
+//     is matched against, since every column search is a regular
+//     expression scan.
+func EstimateCost(r types.Request) int {
+	rows := r.Length
+	if rows < 0 {
+		rows = UnboundedRows
+	}
+	cost := rows + 10*len(r.Columns)
+	global := utf8.RuneCountInString(r.Search.Value)
+	for _, c := range r.Columns {
+		if global > 0 && c.GlobalSearchable() {
+			cost += 10 * global
+		}
+		if c.Searchable {
+			cost += 10 * utf8.RuneCountInString(c.Search.Value)
+		}
+	}
+	return cost
+}
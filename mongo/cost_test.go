@@ -0,0 +1,114 @@
+package mongo
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/basvdlei/godatatables/types"
+)
+
+func TestEstimateCost(t *testing.T) {
+	columns := []types.Column{
+		{Data: "name", Searchable: true},
+		{Data: "city", Searchable: true, Search: types.Search{Value: "ams"}},
+		{Data: "notes"},
+	}
+	cases := []struct {
+		Name    string
+		Request types.Request
+		Cost    int
+	}{
+		{
+			Name:    "rows-and-columns",
+			Request: types.Request{Length: 10, Columns: columns[:1]},
+			Cost:    10 + 10,
+		},
+		{
+			Name:    "all-rows",
+			Request: types.Request{Length: -1},
+			Cost:    UnboundedRows,
+		},
+		{
+			Name: "searches",
+			Request: types.Request{
+				Length:  10,
+				Search:  types.Search{Value: "foo"},
+				Columns: columns,
+			},
+			// Global search on 2 columns and a column search.
+			Cost: 10 + 3*10 + 2*3*10 + 3*10,
+		},
+	}
+	for _, c := range cases {
+		if cost := EstimateCost(c.Request); cost != c.Cost {
+			t.Errorf("case %s: cost does not match, want %d, got %d",
+				c.Name, c.Cost, cost)
+		}
+	}
+}
+
+func TestCollectionHandlerMaxCost(t *testing.T) {
+	form := "/?draw=2&length=10&search[value]=" + strings.Repeat("x", 100) +
+		"&columns[0][data]=name&columns[0][searchable]=true"
+	cases := []struct {
+		Name       string
+		Accept     string
+		MaxCost    int
+		StatusCode int
+		Rejected   bool
+	}{
+		{
+			Name:       "within-budget",
+			MaxCost:    5000,
+			StatusCode: http.StatusOK,
+		},
+		{
+			Name:       "datatables",
+			MaxCost:    500,
+			StatusCode: http.StatusOK,
+			Rejected:   true,
+		},
+		{
+			Name:       "plain-text",
+			Accept:     "text/plain",
+			MaxCost:    500,
+			StatusCode: http.StatusRequestEntityTooLarge,
+			Rejected:   true,
+		},
+	}
+	for _, c := range cases {
+		collection := &CollectionMock{query: &QueryMock{}}
+		ch := &CollectionHandler{
+			Collection: collection,
+			MaxCost:    c.MaxCost,
+		}
+		req := httptest.NewRequest("GET", form, nil)
+		if c.Accept != "" {
+			req.Header.Set("Accept", c.Accept)
+		}
+		w := httptest.NewRecorder()
+		ch.ServeHTTP(w, req)
+		if w.Code != c.StatusCode {
+			t.Errorf("case %s: unexpected statuscode, want %d, got %d",
+				c.Name, c.StatusCode, w.Code)
+		}
+		queried := collection.filter != nil || collection.countCalled > 0
+		if queried == c.Rejected {
+			t.Errorf("case %s: unexpected query execution %v", c.Name, queried)
+		}
+		if !c.Rejected || c.Accept != "" {
+			continue
+		}
+		var dtResponse types.Response
+		if err := json.NewDecoder(w.Body).Decode(&dtResponse); err != nil {
+			t.Fatalf("case %s: could not unmarshal response: %v", c.Name, err)
+		}
+		if !strings.HasPrefix(dtResponse.Error, ErrTooExpensive.Error()) ||
+			dtResponse.Draw != 2 {
+			t.Errorf("case %s: unexpected response %+v", c.Name, dtResponse)
+		}
+	}
+}
@@ -0,0 +1,50 @@
+package mongo
+
+import (
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// pipeCollection returns the Collection as a PipeCollection when the
+// CountDocuments option is set and the Collection supports aggregations.
+func (ch *CollectionHandler) pipeCollection(c Collection) (PipeCollection, bool) {
+	if !ch.CountDocuments {
+		return nil, false
+	}
+	pc, ok := c.(PipeCollection)
+	return pc, ok
+}
+
+// count returns the number of documents matching the filter, up to the limit
+// if positive. It uses an aggregation when the CountDocuments option is set.
+func (ch *CollectionHandler) count(c Collection, f bson.M, collation *mgo.Collation, limit int) (int, error) {
+	if pc, ok := ch.pipeCollection(c); ok {
+		return countDocuments(pc, f, limit)
+	}
+	q := ch.find(c, f, collation)
+	if limit > 0 {
+		q = q.Limit(limit)
+	}
+	return q.Count()
+}
+
+// countDocuments counts the documents matching the filter, up to the limit if
+// positive, using a `$match` and `$count` aggregation like the countDocuments
+// of the official drivers. Unlike the count command this is exact and works
+// the same for collections and views.
+func countDocuments(c PipeCollection, f bson.M, limit int) (int, error) {
+	pipeline := []bson.M{{"$match": f}}
+	if limit > 0 {
+		pipeline = append(pipeline, bson.M{"$limit": limit})
+	}
+	pipeline = append(pipeline, bson.M{"$count": "n"})
+	var result struct {
+		N int `bson:"n"`
+	}
+	err := c.Pipe(pipeline).One(&result)
+	if err == mgo.ErrNotFound {
+		// $count returns no document when nothing matches.
+		return 0, nil
+	}
+	return result.N, err
+}
@@ -0,0 +1,145 @@
+package mongo
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/basvdlei/godatatables/types"
+
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// ViewCollectionMock emulates a collection on a MongoDB view, which can only
+// be counted with an aggregation.
+type ViewCollectionMock struct {
+	*CollectionMock
+	pipe      *PipeMock
+	pipelines [][]bson.M
+}
+
+func (c *ViewCollectionMock) Pipe(pipeline interface{}) Pipe {
+	c.pipelines = append(c.pipelines, pipeline.([]bson.M))
+	return c.pipe
+}
+
+// errorPipe is a Pipe failing with the error.
+type errorPipe struct {
+	err error
+}
+
+func (p errorPipe) All(result interface{}) error { return p.err }
+func (p errorPipe) One(result interface{}) error { return p.err }
+
+// errorPipeCollection is a PipeCollection whose pipes fail with the error.
+type errorPipeCollection struct {
+	err error
+}
+
+func (c errorPipeCollection) Count() (int, error)            { return 0, c.err }
+func (c errorPipeCollection) Pipe(pipeline interface{}) Pipe { return errorPipe{c.err} }
+
+func TestCollectionHandlerCountDocuments(t *testing.T) {
+	viewErr := &mgo.QueryError{
+		Code:    166,
+		Message: "Namespace test.view is a view, not a collection",
+	}
+	cases := []struct {
+		Name             string
+		CountDocuments   bool
+		MaxFilteredCount int
+		Error            string
+		RecordsTotal     int
+		RecordsFiltered  int
+		Pipelines        [][]bson.M
+	}{
+		{
+			Name:  "count-command",
+			Error: viewErr.Error(),
+		},
+		{
+			Name:            "count-documents",
+			CountDocuments:  true,
+			RecordsTotal:    7,
+			RecordsFiltered: 7,
+			Pipelines: [][]bson.M{
+				{
+					{"$match": bson.M{"name": bson.RegEx{Pattern: "foo", Options: "i"}}},
+					{"$count": "n"},
+				},
+				{
+					{"$match": bson.M{}},
+					{"$count": "n"},
+				},
+			},
+		},
+		{
+			Name:             "count-documents-limit",
+			CountDocuments:   true,
+			MaxFilteredCount: 5,
+			RecordsTotal:     7,
+			RecordsFiltered:  7,
+			Pipelines: [][]bson.M{
+				{
+					{"$match": bson.M{"name": bson.RegEx{Pattern: "foo", Options: "i"}}},
+					{"$limit": 5},
+					{"$count": "n"},
+				},
+				{
+					{"$match": bson.M{}},
+					{"$count": "n"},
+				},
+			},
+		},
+	}
+	for _, c := range cases {
+		query := &QueryMock{}
+		collection := &ViewCollectionMock{
+			CollectionMock: &CollectionMock{err: viewErr, query: query},
+			pipe:           &PipeMock{Count: 7},
+		}
+		ch := &CollectionHandler{
+			Collection:       collection,
+			CountDocuments:   c.CountDocuments,
+			MaxFilteredCount: c.MaxFilteredCount,
+		}
+		w := httptest.NewRecorder()
+		ch.ServeHTTP(w, httptest.NewRequest("GET",
+			"/?draw=1&columns[0][data]=name&columns[0][searchable]=true"+
+				"&columns[0][search][value]=foo", nil))
+		var dtResponse types.Response
+		if err := json.NewDecoder(w.Body).Decode(&dtResponse); err != nil {
+			t.Fatalf("case %s: could not unmarshal response: %v", c.Name, err)
+		}
+		if dtResponse.Error != c.Error {
+			t.Errorf("case %s: unexpected error, want %q, got %q",
+				c.Name, c.Error, dtResponse.Error)
+		}
+		if c.CountDocuments && (query.CountCalled || collection.countCalled > 0) {
+			t.Errorf("case %s: count command used", c.Name)
+		}
+		if c.Error == "" && (dtResponse.RecordsTotal != c.RecordsTotal ||
+			dtResponse.RecordsFiltered != c.RecordsFiltered) {
+			t.Errorf("case %s: unexpected counts, want total %d filtered %d, got %d %d",
+				c.Name, c.RecordsTotal, c.RecordsFiltered,
+				dtResponse.RecordsTotal, dtResponse.RecordsFiltered)
+		}
+		if !reflect.DeepEqual(collection.pipelines, c.Pipelines) {
+			t.Errorf("case %s: pipelines do not match, want %+v, got %+v",
+				c.Name, c.Pipelines, collection.pipelines)
+		}
+	}
+}
+
+func TestCountDocumentsNoMatch(t *testing.T) {
+	n, err := countDocuments(errorPipeCollection{mgo.ErrNotFound}, bson.M{}, 0)
+	if err != nil || n != 0 {
+		t.Errorf("unexpected count, want 0, got %d (%v)", n, err)
+	}
+	queryErr := &mgo.QueryError{Code: 2, Message: "bad query"}
+	if _, err := countDocuments(errorPipeCollection{queryErr}, bson.M{}, 0); err != queryErr {
+		t.Errorf("unexpected error, want %v, got %v", queryErr, err)
+	}
+}
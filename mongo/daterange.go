@@ -0,0 +1,91 @@
+package mongo
+
+import (
+	"strings"
+	"time"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+// RelativeRange returns the bounds of a date range relative to now. The
+// range includes from and excludes to.
+type RelativeRange func(now time.Time) (from, to time.Time)
+
+// DefaultRelativeRanges contains the commonly used quick filters of
+// dashboards. The days start at midnight in the location of the clock.
+var DefaultRelativeRanges = map[string]RelativeRange{
+	"today":      LastDays(1),
+	"yesterday":  yesterday,
+	"last7days":  LastDays(7),
+	"last30days": LastDays(30),
+	"thismonth":  thisMonth,
+}
+
+// LastDays returns the RelativeRange of the last n days including today.
+func LastDays(n int) RelativeRange {
+	return func(now time.Time) (time.Time, time.Time) {
+		tomorrow := midnight(now).AddDate(0, 0, 1)
+		return tomorrow.AddDate(0, 0, -n), tomorrow
+	}
+}
+
+// yesterday returns the half-open range of the day before now, in the
+// location of now.
+func yesterday(now time.Time) (time.Time, time.Time) {
+	today := midnight(now)
+	return today.AddDate(0, 0, -1), today
+}
+
+// thisMonth returns the half-open range of the month of now, in the location
+// of now.
+func thisMonth(now time.Time) (time.Time, time.Time) {
+	first := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location())
+	return first, first.AddDate(0, 1, 0)
+}
+
+// midnight returns the start of the day of t.
+func midnight(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+}
+
+// DateRangeColumn configures the relative range tokens that can be used as
+// column search value of a date column.
+type DateRangeColumn struct {
+	// Field is the date field of the documents that is filtered on.
+	// Defaults to the column data.
+	Field string
+	// Ranges maps the tokens to their range, eg DefaultRelativeRanges.
+	// The tokens are matched case-insensitively.
+	Ranges map[string]RelativeRange
+}
+
+// dateRangeFilter returns the `$gte`/`$lt` filter for the relative range
+// token in the column search of a date range column. It returns false if
+// the column has no date ranges or the token is unknown.
+func (o FilterOptions) dateRangeFilter(data, value string) (bson.M, bool) {
+	d, ok := o.DateRangeColumns[data]
+	if !ok {
+		return nil, false
+	}
+	token := strings.ToLower(strings.TrimSpace(value))
+	var rr RelativeRange
+	for k, v := range d.Ranges {
+		if strings.ToLower(k) == token {
+			rr = v
+			break
+		}
+	}
+	if rr == nil {
+		return nil, false
+	}
+	now := time.Now
+	if o.Now != nil {
+		now = o.Now
+	}
+	from, to := rr(now())
+	field := d.Field
+	if field == "" {
+		field = data
+	}
+	return bson.M{field: bson.M{"$gte": from, "$lt": to}}, true
+}
@@ -0,0 +1,97 @@
+package mongo
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/basvdlei/godatatables/types"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+func TestCreateFilterDateRangeColumns(t *testing.T) {
+	now := time.Date(2020, time.March, 2, 15, 4, 5, 0, time.UTC)
+	o := FilterOptions{
+		DateRangeColumns: map[string]DateRangeColumn{
+			"created": {Field: "created_at", Ranges: DefaultRelativeRanges},
+			"updated": {Ranges: map[string]RelativeRange{"week": LastDays(7)}},
+		},
+		Now: func() time.Time { return now },
+	}
+	day := func(month time.Month, d int) time.Time {
+		return time.Date(2020, month, d, 0, 0, 0, 0, time.UTC)
+	}
+	cases := []struct {
+		Name   string
+		Column string
+		Value  string
+		Want   bson.M
+	}{
+		{
+			Name:   "last7days",
+			Column: "created",
+			Value:  "last7days",
+			Want: bson.M{"created_at": bson.M{
+				"$gte": day(time.February, 25),
+				"$lt":  day(time.March, 3),
+			}},
+		},
+		{
+			Name:   "today",
+			Column: "created",
+			Value:  " Today ",
+			Want: bson.M{"created_at": bson.M{
+				"$gte": day(time.March, 2),
+				"$lt":  day(time.March, 3),
+			}},
+		},
+		{
+			Name:   "yesterday",
+			Column: "created",
+			Value:  "yesterday",
+			Want: bson.M{"created_at": bson.M{
+				"$gte": day(time.March, 1),
+				"$lt":  day(time.March, 2),
+			}},
+		},
+		{
+			Name:   "thismonth",
+			Column: "created",
+			Value:  "thismonth",
+			Want: bson.M{"created_at": bson.M{
+				"$gte": day(time.March, 1),
+				"$lt":  day(time.April, 1),
+			}},
+		},
+		{
+			Name:   "column-data-field",
+			Column: "updated",
+			Value:  "week",
+			Want: bson.M{"updated": bson.M{
+				"$gte": day(time.February, 25),
+				"$lt":  day(time.March, 3),
+			}},
+		},
+		{
+			Name:   "unknown-token",
+			Column: "updated",
+			Value:  "last7days",
+			Want:   bson.M{},
+		},
+	}
+	for _, c := range cases {
+		r := types.Request{
+			Columns: []types.Column{{
+				Data:       c.Column,
+				Searchable: true,
+				Search:     types.Search{Value: c.Value},
+			}},
+		}
+		f := o.CreateFilter(r)
+		if !reflect.DeepEqual(f, c.Want) {
+			t.Errorf("case %s: filter does not match, want %+v, got %+v",
+				c.Name, c.Want, f)
+		}
+	}
+}
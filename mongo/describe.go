@@ -0,0 +1,128 @@
+package mongo
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+// comparisons maps the comparison operators to their description.
+var comparisons = map[string]string{
+	"$eq":  "=",
+	"$ne":  "!=",
+	"$gt":  ">",
+	"$gte": ">=",
+	"$lt":  "<",
+	"$lte": "<=",
+	"$in":  "IN",
+	"$nin": "NOT IN",
+}
+
+// Describe renders the filter into a human readable description for admin
+// interfaces and debugging, eg `(foo ~ "bar" OR baz ~ "bar") AND qux = 1`.
+// Regular expressions are described with `~`, followed by their options
+// like `~i` for case-insensitive. The fields of a document are described in
+// alphabetical order. An empty filter is described as `ALL`.
+func Describe(f bson.M) string {
+	if len(f) == 0 {
+		return "ALL"
+	}
+	return describe(f, false)
+}
+
+// describe returns the description of the filter, in parentheses when it
+// is nested and combines multiple conditions.
+func describe(f bson.M, nested bool) string {
+	keys := make([]string, 0, len(f))
+	for k := range f {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	parts := make([]string, 0, len(keys))
+	for _, k := range keys {
+		parts = append(parts, describeKey(k, f[k], nested || len(keys) > 1))
+	}
+	return group(parts, " AND ", nested && len(parts) > 1)
+}
+
+// describeKey returns the description of a single key of a filter.
+func describeKey(k string, v interface{}, nested bool) string {
+	switch k {
+	case "$and", "$or", "$nor":
+		filters, _ := v.([]bson.M)
+		parts := make([]string, len(filters))
+		for i, f := range filters {
+			parts[i] = describe(f, true)
+		}
+		if k == "$nor" {
+			return "NOT " + group(parts, " OR ", true)
+		}
+		return group(parts, " "+strings.ToUpper(k[1:])+" ", nested && len(parts) > 1)
+	case "$text":
+		if m, ok := v.(bson.M); ok {
+			return "TEXT " + describeValue(m["$search"])
+		}
+	case "$expr":
+		return "EXPR " + fmt.Sprint(v)
+	}
+	switch v := v.(type) {
+	case bson.RegEx:
+		return k + " ~" + v.Options + " " + strconv.Quote(v.Pattern)
+	case bson.M:
+		ops := make([]string, 0, len(v))
+		for op := range v {
+			ops = append(ops, op)
+		}
+		sort.Strings(ops)
+		parts := make([]string, 0, len(ops))
+		for _, op := range ops {
+			if c, ok := comparisons[op]; ok {
+				parts = append(parts, k+" "+c+" "+describeValue(v[op]))
+			} else {
+				parts = append(parts, k+" "+op+" "+describeValue(v[op]))
+			}
+		}
+		return group(parts, " AND ", nested && len(parts) > 1)
+	}
+	return k + " = " + describeValue(v)
+}
+
+// describeValue returns the description of a value, with strings quoted.
+func describeValue(v interface{}) string {
+	switch v := v.(type) {
+	case string:
+		return strconv.Quote(v)
+	case bson.RegEx:
+		return "/" + v.Pattern + "/" + v.Options
+	case time.Time:
+		return v.Format(time.RFC3339)
+	case bson.ObjectId:
+		return v.Hex()
+	case []interface{}:
+		parts := make([]string, len(v))
+		for i, e := range v {
+			parts[i] = describeValue(e)
+		}
+		return "[" + strings.Join(parts, ", ") + "]"
+	case []string:
+		parts := make([]string, len(v))
+		for i, e := range v {
+			parts[i] = strconv.Quote(e)
+		}
+		return "[" + strings.Join(parts, ", ") + "]"
+	}
+	return fmt.Sprint(v)
+}
+
+// group joins the parts with the separator, in parentheses if requested.
+func group(parts []string, sep string, parens bool) string {
+	s := strings.Join(parts, sep)
+	if parens {
+		return "(" + s + ")"
+	}
+	return s
+}
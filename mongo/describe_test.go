@@ -0,0 +1,81 @@
+package mongo
+
+import (
+	"testing"
+	"time"
+
+	"github.com/basvdlei/godatatables/types"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+func TestDescribe(t *testing.T) {
+	cases := []struct {
+		Name   string
+		Filter bson.M
+		Want   string
+	}{
+		{
+			Name:   "empty",
+			Filter: bson.M{},
+			Want:   "ALL",
+		},
+		{
+			Name: "global-and-column",
+			Filter: bson.M{"$and": []bson.M{
+				{"$or": []bson.M{
+					{"foo": bson.RegEx{Pattern: "bar"}},
+					{"baz": bson.RegEx{Pattern: "bar"}},
+				}},
+				{"qux": bson.M{"$eq": 1}},
+			}},
+			Want: `(foo ~ "bar" OR baz ~ "bar") AND qux = 1`,
+		},
+		{
+			Name: "create-filter",
+			Filter: CreateFilter(types.Request{
+				Search: types.Search{Value: "a.b"},
+				Columns: []types.Column{
+					{Data: "name", Searchable: true},
+					{Data: "city", Searchable: true, Search: types.Search{Value: "ams"}},
+				},
+			}),
+			Want: `(name ~i "a\\.b" OR city ~i "a\\.b") AND city ~i "ams"`,
+		},
+		{
+			Name: "range",
+			Filter: bson.M{"created": bson.M{
+				"$gte": time.Date(2020, 3, 1, 0, 0, 0, 0, time.UTC),
+				"$lt":  time.Date(2020, 4, 1, 0, 0, 0, 0, time.UTC),
+			}},
+			Want: `created >= 2020-03-01T00:00:00Z AND created < 2020-04-01T00:00:00Z`,
+		},
+		{
+			Name: "nested-range",
+			Filter: bson.M{"$or": []bson.M{
+				{"active": true},
+				{"age": bson.M{"$gt": 18, "$lte": 65}},
+			}},
+			Want: `active = true OR (age > 18 AND age <= 65)`,
+		},
+		{
+			Name:   "fields",
+			Filter: bson.M{"b": "x", "a": bson.M{"$in": []interface{}{"y", 2}}},
+			Want:   `a IN ["y", 2] AND b = "x"`,
+		},
+		{
+			Name: "nor-and-text",
+			Filter: bson.M{
+				"$nor":  []bson.M{{"deleted": true}},
+				"$text": bson.M{"$search": "coffee"},
+			},
+			Want: `NOT (deleted = true) AND TEXT "coffee"`,
+		},
+	}
+	for _, c := range cases {
+		if got := Describe(c.Filter); got != c.Want {
+			t.Errorf("case %s: description does not match, want %s, got %s",
+				c.Name, c.Want, got)
+		}
+	}
+}
@@ -0,0 +1,50 @@
+package mongo
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+)
+
+// DistinctResponse contains the distinct values of a column, for building
+// select based column filters. It is returned instead of the Datatables
+// Response for requests with the DistinctParam.
+type DistinctResponse struct {
+	// Draw counter of the request.
+	Draw int `json:"draw"`
+	// Values are the sorted distinct values of the column within the
+	// filtered records.
+	Values []string `json:"values"`
+	// Error message, empty if there was no error.
+	Error string `json:"error,omitempty"`
+}
+
+// serveDistinct responds with the distinct values of the column data for the
+// filtered query.
+func (ch *CollectionHandler) serveDistinct(w http.ResponseWriter, r *http.Request, q Query, draw int, data string) {
+	if n, err := strconv.Atoi(data); err == nil && n >= 0 && n < len(ch.FieldOrder) {
+		data = ch.FieldOrder[n]
+	}
+	if err := ValidateField(data, ch.Fields...); err != nil {
+		ch.invalidRequest(w, r, draw, err)
+		return
+	}
+	resp := DistinctResponse{Draw: draw, Values: []string{}}
+	var values []interface{}
+	if err := q.Distinct(data, &values); err != nil {
+		resp.Error = err.Error()
+	}
+	vf := ch.valueFormat()
+	for _, v := range values {
+		resp.Values = append(resp.Values, vf.value(v))
+	}
+	sort.Strings(resp.Values)
+	if resp.Error != "" && plainText(r) {
+		http.Error(w, "query failed: "+resp.Error,
+			http.StatusInternalServerError)
+		return
+	}
+	if err := ch.encoder().Encode(w, &resp); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,91 @@
+package mongo
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"testing"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+func TestCollectionHandlerDistinct(t *testing.T) {
+	// The fixture is the result of the filter.
+	query := &QueryMock{
+		Result: []map[string]string{
+			{"name": "Airi", "office": "Tokyo"},
+			{"name": "Ashton", "office": "San Francisco"},
+			{"name": "Bradley", "office": "London"},
+			{"name": "Brielle", "office": "New York"},
+			{"name": "Bruno", "office": "London"},
+		},
+	}
+	collection := &CollectionMock{query: query}
+	ch := &CollectionHandler{Collection: collection}
+	req := &http.Request{
+		Method: "GET",
+		URL:    &url.URL{Path: "/"},
+		Form: url.Values{
+			"draw":                      []string{"2"},
+			"distinct":                  []string{"office"},
+			"columns[0][data]":          []string{"name"},
+			"columns[0][searchable]":    []string{"true"},
+			"columns[0][search][value]": []string{"b"},
+			"columns[1][data]":          []string{"office"},
+		},
+	}
+	w := httptest.NewRecorder()
+	ch.ServeHTTP(w, req)
+	var resp DistinctResponse
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatalf("could not unmarshal response: %v", err)
+	}
+	want := DistinctResponse{
+		Draw:   2,
+		Values: []string{"London", "New York", "San Francisco", "Tokyo"},
+	}
+	if !reflect.DeepEqual(resp, want) {
+		t.Errorf("response does not match, want %+v, got %+v", want, resp)
+	}
+	if query.DistinctKey != "office" {
+		t.Errorf("distinct key does not match, want %q, got %q",
+			"office", query.DistinctKey)
+	}
+	wantFilter := bson.M{"name": bson.RegEx{Pattern: "b", Options: "i"}}
+	if !reflect.DeepEqual(collection.filter, wantFilter) {
+		t.Errorf("filter does not match, want %+v, got %+v",
+			wantFilter, collection.filter)
+	}
+	if query.AllCalled {
+		t.Errorf("unexpected data query")
+	}
+}
+
+func TestCollectionHandlerDistinctInvalidField(t *testing.T) {
+	for _, field := range []string{"$where", "salary"} {
+		query := &QueryMock{}
+		ch := &CollectionHandler{
+			Collection: &CollectionMock{query: query},
+			Fields:     []string{"name", "office"},
+		}
+		req := &http.Request{
+			Method: "GET",
+			URL:    &url.URL{Path: "/"},
+			Form: url.Values{
+				"draw":     []string{"1"},
+				"distinct": []string{field},
+			},
+		}
+		w := httptest.NewRecorder()
+		ch.ServeHTTP(w, req)
+		var resp DistinctResponse
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatalf("field %s: could not unmarshal response: %v", field, err)
+		}
+		if resp.Error == "" || query.DistinctKey != "" {
+			t.Errorf("field %s: distinct not rejected: %+v", field, resp)
+		}
+	}
+}
@@ -0,0 +1,34 @@
+package mongo
+
+import (
+	"encoding/json"
+	"io"
+)
+
+// Encoder writes the encoded Datatables response to w. This allows the use
+// of a faster JSON library than encoding/json.
+type Encoder interface {
+	Encode(w io.Writer, v interface{}) error
+}
+
+// EncoderFunc is an adapter to allow the use of ordinary functions as
+// Encoder.
+type EncoderFunc func(w io.Writer, v interface{}) error
+
+// Encode calls f(w, v).
+func (f EncoderFunc) Encode(w io.Writer, v interface{}) error {
+	return f(w, v)
+}
+
+// JSONEncoder is the default Encoder using encoding/json.
+var JSONEncoder Encoder = EncoderFunc(func(w io.Writer, v interface{}) error {
+	return json.NewEncoder(w).Encode(v)
+})
+
+// PrettyJSONEncoder is an Encoder using encoding/json with indentation,
+// which makes the responses easier to read during development.
+var PrettyJSONEncoder Encoder = EncoderFunc(func(w io.Writer, v interface{}) error {
+	e := json.NewEncoder(w)
+	e.SetIndent("", "  ")
+	return e.Encode(v)
+})
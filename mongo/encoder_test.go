@@ -0,0 +1,73 @@
+package mongo
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/basvdlei/godatatables/types"
+)
+
+func TestEncoder(t *testing.T) {
+	var encoded interface{}
+	ch := CollectionHandler{
+		Collection: &CollectionMock{query: &QueryMock{}},
+		Encoder: EncoderFunc(func(w io.Writer, v interface{}) error {
+			encoded = v
+			_, err := io.WriteString(w, "encoded")
+			return err
+		}),
+	}
+	req := &http.Request{
+		Method: "GET",
+		URL:    &url.URL{Path: "/"},
+		Form:   url.Values{"draw": []string{"3"}},
+	}
+	w := httptest.NewRecorder()
+	ch.ServeHTTP(w, req)
+	if body := w.Body.String(); body != "encoded" {
+		t.Errorf("unexpected body, want %q, got %q", "encoded", body)
+	}
+	resp, ok := encoded.(*types.Response)
+	if !ok {
+		t.Fatalf("unexpected encoded value %T", encoded)
+	}
+	if resp.Draw != 3 {
+		t.Errorf("unexpected draw, want %d, got %d", 3, resp.Draw)
+	}
+}
+
+func TestPretty(t *testing.T) {
+	cases := []struct {
+		Pretty bool
+		Body   string
+	}{
+		{
+			Pretty: false,
+			Body:   `{"draw":1,"recordsTotal":0,"recordsFiltered":0,"data":[]}` + "\n",
+		},
+		{
+			Pretty: true,
+			Body:   "{\n  \"draw\": 1,\n  \"recordsTotal\": 0,\n  \"recordsFiltered\": 0,\n  \"data\": []\n}\n",
+		},
+	}
+	for _, c := range cases {
+		ch := &CollectionHandler{
+			Collection: &CollectionMock{query: &QueryMock{}},
+			Pretty:     c.Pretty,
+		}
+		req := &http.Request{
+			Method: "GET",
+			URL:    &url.URL{Path: "/"},
+			Form:   url.Values{"draw": []string{"1"}},
+		}
+		w := httptest.NewRecorder()
+		ch.ServeHTTP(w, req)
+		if body := w.Body.String(); body != c.Body {
+			t.Errorf("pretty %v: unexpected body, want %q, got %q",
+				c.Pretty, c.Body, body)
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package mongo
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/basvdlei/godatatables/types"
+)
+
+var (
+	// ErrInvalidField is returned for column data that can't safely be
+	// used as document field name.
+	ErrInvalidField = errors.New("invalid column data")
+	// ErrFieldNotAllowed is returned for column data that is not one of
+	// the allowed fields.
+	ErrFieldNotAllowed = errors.New("field not allowed")
+)
+
+// ValidateFields checks that the column data of the Request can safely be
+// used as document field names. Since the column data is sent by the client,
+// names containing operators (eg `$where` or `a.$ne`) are rejected. When
+// allowed fields are given the column data must be one of them as well.
+// Columns without data are ignored. The errors report the column index
+// instead of the column data, so client input is not echoed back.
+func ValidateFields(r types.Request, allowed ...string) error {
+	for i, c := range r.Columns {
+		if c.Data == "" {
+			continue
+		}
+		if err := ValidateField(c.Data, allowed...); err != nil {
+			return fmt.Errorf("%w: column %d", err, i)
+		}
+	}
+	return nil
+}
+
+// ValidateField checks that the column data can safely be used as document
+// field name, like ValidateFields. It returns ErrInvalidField or
+// ErrFieldNotAllowed.
+func ValidateField(data string, allowed ...string) error {
+	if !validField(data) {
+		return ErrInvalidField
+	}
+	if len(allowed) > 0 && !contains(allowed, data) {
+		return ErrFieldNotAllowed
+	}
+	return nil
+}
+
+// ResolveFields returns a copy of the Request where integer column data, as
+// sent by array sourced tables, is replaced by the field at that position in
+// fields. Other column data is left as is.
+func ResolveFields(r types.Request, fields []string) types.Request {
+	columns := make([]types.Column, len(r.Columns))
+	for i, c := range r.Columns {
+		if n, err := strconv.Atoi(c.Data); err == nil && n >= 0 && n < len(fields) {
+			c.Data = fields[n]
+		}
+		columns[i] = c
+	}
+	r.Columns = columns
+	return r
+}
+
+// validField reports if none of the dot separated parts of the field name
+// are empty, start with a `$` or contain a null character.
+func validField(name string) bool {
+	for _, p := range strings.Split(name, ".") {
+		if p == "" || strings.HasPrefix(p, "$") || strings.ContainsRune(p, 0) {
+			return false
+		}
+	}
+	return true
+}
+
+// contains reports if s is in list.
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,106 @@
+package mongo
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"testing"
+
+	"github.com/basvdlei/godatatables/types"
+	"gopkg.in/mgo.v2/bson"
+)
+
+func TestValidateFields(t *testing.T) {
+	cases := []struct {
+		Name    string
+		Data    []string
+		Allowed []string
+		Valid   bool
+		Error   string
+	}{
+		{Name: "plain", Data: []string{"name", "address.city", ""}, Valid: true},
+		{Name: "where", Data: []string{"name", "$where"}, Valid: false, Error: "invalid column data: column 1"},
+		{Name: "nested-operator", Data: []string{"address.$ne"}, Valid: false},
+		{Name: "empty-part", Data: []string{"address..city"}, Valid: false},
+		{Name: "null", Data: []string{"name\x00"}, Valid: false},
+		{Name: "allowed", Data: []string{"name"}, Allowed: []string{"name", "city"}, Valid: true},
+		{Name: "not-allowed", Data: []string{"password"}, Allowed: []string{"name", "city"}, Valid: false, Error: "field not allowed: column 0"},
+	}
+	for _, c := range cases {
+		var r types.Request
+		for _, d := range c.Data {
+			r.Columns = append(r.Columns, types.Column{Data: d})
+		}
+		err := ValidateFields(r, c.Allowed...)
+		if (err == nil) != c.Valid {
+			t.Errorf("case %s: unexpected result, want valid %v, got %v",
+				c.Name, c.Valid, err)
+		}
+		if c.Error != "" && (err == nil || err.Error() != c.Error) {
+			t.Errorf("case %s: unexpected error, want %q, got %v",
+				c.Name, c.Error, err)
+		}
+	}
+}
+
+func TestCollectionHandlerRejectsOperatorFields(t *testing.T) {
+	query := &QueryMock{}
+	ch := &CollectionHandler{
+		Collection: &CollectionMock{query: query},
+	}
+	req := &http.Request{
+		Method: "GET",
+		URL:    &url.URL{Path: "/"},
+		Form: url.Values{
+			"draw":                      []string{"1"},
+			"columns[0][data]":          []string{"$where"},
+			"columns[0][search][value]": []string{"sleep(1000)"},
+		},
+	}
+	w := httptest.NewRecorder()
+	ch.ServeHTTP(w, req)
+	var dtResponse types.Response
+	if err := json.NewDecoder(w.Body).Decode(&dtResponse); err != nil {
+		t.Fatalf("could not unmarshal response: %v", err)
+	}
+	if dtResponse.Error == "" {
+		t.Errorf("expected an error in the response")
+	}
+	if query.AllCalled {
+		t.Errorf("unexpected query for rejected request")
+	}
+}
+
+func TestCollectionHandlerFieldOrder(t *testing.T) {
+	query := &QueryMock{}
+	collection := &CollectionMock{query: query}
+	ch := &CollectionHandler{
+		Collection: collection,
+		FieldOrder: []string{"name", "address.city"},
+	}
+	req := &http.Request{
+		Method: "GET",
+		URL:    &url.URL{Path: "/"},
+		Form: url.Values{
+			"draw":                      []string{"1"},
+			"columns[0][data]":          []string{"0"},
+			"columns[0][searchable]":    []string{"true"},
+			"columns[0][search][value]": []string{"foo"},
+			"columns[1][data]":          []string{"1"},
+			"columns[2][data]":          []string{"2"},
+			"order[0][column]":          []string{"1"},
+			"order[0][dir]":             []string{"desc"},
+		},
+	}
+	ch.ServeHTTP(httptest.NewRecorder(), req)
+	want := bson.M{"name": bson.RegEx{Pattern: "foo", Options: "i"}}
+	if !reflect.DeepEqual(collection.filter, want) {
+		t.Errorf("filter does not match, want %+v, got %+v",
+			want, collection.filter)
+	}
+	if sort := []string{"-address.city", "_id"}; !reflect.DeepEqual(query.SortValue, sort) {
+		t.Errorf("sort does not match, want %v, got %v", sort, query.SortValue)
+	}
+}
@@ -0,0 +1,70 @@
+package mongo
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+
+	"github.com/basvdlei/godatatables/types"
+)
+
+// flightCall is a query in flight whose result is shared.
+type flightCall struct {
+	done       chan struct{}
+	resp       types.Response
+	deepPaging bool
+}
+
+// flightGroup deduplicates concurrent queries by key.
+type flightGroup struct {
+	mu    sync.Mutex
+	calls map[string]*flightCall
+}
+
+// do runs fn unless a call for the key is already in flight, in which case
+// it waits for and returns the result of that call.
+func (g *flightGroup) do(key string, fn func() (types.Response, bool)) (types.Response, bool) {
+	g.mu.Lock()
+	if call, ok := g.calls[key]; ok {
+		g.mu.Unlock()
+		<-call.done
+		return call.resp, call.deepPaging
+	}
+	if g.calls == nil {
+		g.calls = make(map[string]*flightCall)
+	}
+	call := &flightCall{done: make(chan struct{})}
+	g.calls[key] = call
+	g.mu.Unlock()
+
+	defer func() {
+		g.mu.Lock()
+		delete(g.calls, key)
+		g.mu.Unlock()
+		close(call.done)
+	}()
+	call.resp, call.deepPaging = fn()
+	return call.resp, call.deepPaging
+}
+
+// coalesce runs the query function once for the concurrent requests for the
+// same records. The filter, sort, keyset and collation are part of the key
+// since they can differ between requests for the same Datatables Request, eg
+// of views.
+// The query runs without the cancellation of the request, since other
+// requests may be waiting for it.
+func (ch *CollectionHandler) coalesce(r *http.Request, dtRequest types.Request, p queryParams, fn func(ctx context.Context) (types.Response, bool)) (types.Response, bool) {
+	key, err := dtRequest.CacheKey()
+	if err != nil {
+		return fn(r.Context())
+	}
+	key += "\x00" + fmt.Sprint(p.filter) + "\x00" +
+		fmt.Sprint(p.opts.SortFields(dtRequest)) + "\x00" + p.keyset
+	if p.collation != nil {
+		key += "\x00" + fmt.Sprintf("%+v", *p.collation)
+	}
+	return ch.flight.do(key, func() (types.Response, bool) {
+		return fn(context.WithoutCancel(r.Context()))
+	})
+}
@@ -0,0 +1,85 @@
+package mongo
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/basvdlei/godatatables/types"
+)
+
+// flightCollectionMock counts the executed queries, which are slow enough
+// for concurrent requests to overlap.
+type flightCollectionMock struct {
+	counts int32
+}
+
+func (c *flightCollectionMock) Count() (n int, err error) {
+	return 100, nil
+}
+func (c *flightCollectionMock) Find(query interface{}) Query {
+	return &flightQueryMock{
+		QueryMock: &QueryMock{
+			Result:     RequestTests[0].Result,
+			CountValue: 2,
+			Delay:      100 * time.Millisecond,
+		},
+		counts: &c.counts,
+	}
+}
+
+// flightQueryMock counts the filtered counts, which run once per query.
+type flightQueryMock struct {
+	*QueryMock
+	counts *int32
+}
+
+func (q *flightQueryMock) Count() (int, error) {
+	atomic.AddInt32(q.counts, 1)
+	return q.QueryMock.Count()
+}
+
+func TestCollectionHandlerCoalesce(t *testing.T) {
+	collection := &flightCollectionMock{}
+	ch := &CollectionHandler{
+		Collection: collection,
+		Coalesce:   true,
+	}
+	const n = 5
+	responses := make([]types.Response, n)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			req := httptest.NewRequest("GET", "/?draw="+
+				string(rune('1'+i))+"&columns[0][data]=name", nil)
+			w := httptest.NewRecorder()
+			ch.ServeHTTP(w, req)
+			if err := json.NewDecoder(w.Body).Decode(&responses[i]); err != nil {
+				t.Errorf("request %d: could not unmarshal response: %v", i, err)
+			}
+		}(i)
+	}
+	wg.Wait()
+	if counts := atomic.LoadInt32(&collection.counts); counts != 1 {
+		t.Errorf("query ran %d times, want 1", counts)
+	}
+	for i, resp := range responses {
+		if resp.Draw != i+1 {
+			t.Errorf("request %d: unexpected draw %d", i, resp.Draw)
+		}
+		if resp.RecordsFiltered != 2 || len(resp.Data) != len(RequestTests[0].Result) {
+			t.Errorf("request %d: unexpected response %+v", i, resp)
+		}
+	}
+
+	// Requests after the query completed run it again.
+	ch.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/?draw=9", nil))
+	if counts := atomic.LoadInt32(&collection.counts); counts != 2 {
+		t.Errorf("query ran %d times, want 2", counts)
+	}
+}
@@ -0,0 +1,57 @@
+package mongo
+
+import (
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// foldGroups contains the case variants that are not covered by simple case
+// folding, like the Turkish dotted and dotless i and the German sharp s.
+var foldGroups = [][]string{
+	{"i", "I", "İ", "ı"},
+	{"ß", "ẞ", "ss", "SS", "Ss", "sS"},
+}
+
+// FoldPattern returns a regular expression matching the value literally while
+// matching all case variants of its characters explicitly.
+//
+// The MongoDB `i` regex option only applies simple case folding, which misses
+// variants like `İ` for `i` or `ss` for `ß`. The returned pattern covers those
+// without depending on the `i` option. Variants that span multiple characters
+// in the value (eg `ss` in the value matching `ß`) are not matched.
+func FoldPattern(value string) string {
+	var b strings.Builder
+	for _, r := range value {
+		if g := foldGroup(r); g != nil {
+			alternatives := make([]string, len(g))
+			for i, v := range g {
+				alternatives[i] = regexp.QuoteMeta(v)
+			}
+			b.WriteString("(?:" + strings.Join(alternatives, "|") + ")")
+			continue
+		}
+		variants := []rune{r}
+		for f := unicode.SimpleFold(r); f != r; f = unicode.SimpleFold(f) {
+			variants = append(variants, f)
+		}
+		if len(variants) == 1 {
+			b.WriteString(regexp.QuoteMeta(string(r)))
+			continue
+		}
+		b.WriteString("[" + string(variants) + "]")
+	}
+	return b.String()
+}
+
+// foldGroup returns the fold group containing the rune, if any.
+func foldGroup(r rune) []string {
+	for _, g := range foldGroups {
+		for _, v := range g {
+			if v == string(r) {
+				return g
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,94 @@
+package mongo
+
+import (
+	"regexp"
+	"testing"
+
+	"github.com/basvdlei/godatatables/types"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+type FoldTestCase struct {
+	Value    string
+	Pattern  string
+	Match    []string
+	NotMatch []string
+}
+
+var FoldTests = []FoldTestCase{
+	{
+		Value:    "istanbul",
+		Pattern:  "(?:i|I|İ|ı)[s\u017fS][tT][aA][nN][bB][uU][lL]",
+		Match:    []string{"İSTANBUL", "ıstanbul", "Istanbul"},
+		NotMatch: []string{"stanbul"},
+	},
+	{
+		Value:    "straße",
+		Pattern:  "[s\u017fS][tT][rR][aA](?:ß|ẞ|ss|SS|Ss|sS)[eE]",
+		Match:    []string{"STRASSE", "Straße", "STRAẞE"},
+		NotMatch: []string{"strase"},
+	},
+	{
+		Value:    "\u03a9k.",
+		Pattern:  "[\u03a9\u03c9\u2126][k\u212aK]\\.",
+		Match:    []string{"ωk.", "ΩK."},
+		NotMatch: []string{"ωkx"},
+	},
+	{
+		Value:   "42",
+		Pattern: "42",
+		Match:   []string{"42"},
+	},
+}
+
+func TestFoldPattern(t *testing.T) {
+	for _, c := range FoldTests {
+		p := FoldPattern(c.Value)
+		if p != c.Pattern {
+			t.Errorf("case %s: pattern does not match, want %s, got %s",
+				c.Value, c.Pattern, p)
+		}
+		re := regexp.MustCompile(p)
+		for _, m := range c.Match {
+			if !re.MatchString(m) {
+				t.Errorf("case %s: pattern %s does not match %s",
+					c.Value, p, m)
+			}
+		}
+		for _, m := range c.NotMatch {
+			if re.MatchString(m) {
+				t.Errorf("case %s: pattern %s matches %s",
+					c.Value, p, m)
+			}
+		}
+	}
+}
+
+func TestCreateFilterFoldCase(t *testing.T) {
+	o := FilterOptions{FoldCase: true}
+	r := types.Request{
+		Columns: []types.Column{
+			{
+				Data:       "city",
+				Searchable: true,
+				Search:     types.Search{Value: "ıi"},
+			},
+			{
+				Data:       "street",
+				Searchable: true,
+				Search:     types.Search{Value: "^a", Regex: true},
+			},
+		},
+	}
+	f := o.CreateFilter(r)
+	column := f["$and"].([]bson.M)
+	want := bson.RegEx{Pattern: "(?:i|I|İ|ı)(?:i|I|İ|ı)", Options: ""}
+	if re := column[0]["city"]; re != want {
+		t.Errorf("folded regex does not match, want %+v, got %+v", want, re)
+	}
+	want = bson.RegEx{Pattern: "^a", Options: "i"}
+	if re := column[1]["street"]; re != want {
+		t.Errorf("regex search does not match, want %+v, got %+v", want, re)
+	}
+}
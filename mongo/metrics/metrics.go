@@ -0,0 +1,83 @@
+// Package metrics provides Prometheus instrumentation for the mongo
+// Datatables handlers.
+package metrics
+
+import (
+	"github.com/basvdlei/godatatables/mongo"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Observer is a mongo.Observer that records the observations as Prometheus
+// metrics. It implements prometheus.Collector so it can be registered
+// directly.
+type Observer struct {
+	requests        *prometheus.CounterVec
+	duration        prometheus.Histogram
+	recordsTotal    prometheus.Gauge
+	recordsFiltered prometheus.Gauge
+}
+
+// NewObserver returns an Observer with metrics in the given namespace. The
+// constant labels are added to all metrics, eg to distinguish between
+// multiple handlers.
+func NewObserver(namespace string, labels prometheus.Labels) *Observer {
+	return &Observer{
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace:   namespace,
+			Subsystem:   "datatables",
+			Name:        "requests_total",
+			Help:        "Number of handled Datatables requests.",
+			ConstLabels: labels,
+		}, []string{"result"}),
+		duration: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace:   namespace,
+			Subsystem:   "datatables",
+			Name:        "request_duration_seconds",
+			Help:        "Time spent querying Datatables requests.",
+			ConstLabels: labels,
+			Buckets:     prometheus.DefBuckets,
+		}),
+		recordsTotal: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace:   namespace,
+			Subsystem:   "datatables",
+			Name:        "records_total",
+			Help:        "Total records of the last Datatables request.",
+			ConstLabels: labels,
+		}),
+		recordsFiltered: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace:   namespace,
+			Subsystem:   "datatables",
+			Name:        "records_filtered",
+			Help:        "Filtered records of the last Datatables request.",
+			ConstLabels: labels,
+		}),
+	}
+}
+
+// Observe implements the mongo.Observer interface.
+func (o *Observer) Observe(obs mongo.Observation) {
+	if obs.Error != "" {
+		o.requests.WithLabelValues("error").Inc()
+	} else {
+		o.requests.WithLabelValues("success").Inc()
+	}
+	o.duration.Observe(obs.Duration.Seconds())
+	o.recordsTotal.Set(float64(obs.RecordsTotal))
+	o.recordsFiltered.Set(float64(obs.RecordsFiltered))
+}
+
+// Describe implements the prometheus.Collector interface.
+func (o *Observer) Describe(ch chan<- *prometheus.Desc) {
+	o.requests.Describe(ch)
+	o.duration.Describe(ch)
+	o.recordsTotal.Describe(ch)
+	o.recordsFiltered.Describe(ch)
+}
+
+// Collect implements the prometheus.Collector interface.
+func (o *Observer) Collect(ch chan<- prometheus.Metric) {
+	o.requests.Collect(ch)
+	o.duration.Collect(ch)
+	o.recordsTotal.Collect(ch)
+	o.recordsFiltered.Collect(ch)
+}
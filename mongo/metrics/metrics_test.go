@@ -0,0 +1,67 @@
+package metrics
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/basvdlei/godatatables/mongo"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+var _ mongo.Observer = &Observer{}
+
+func TestObserver(t *testing.T) {
+	o := NewObserver("test", prometheus.Labels{"table": "users"})
+	reg := prometheus.NewRegistry()
+	if err := reg.Register(o); err != nil {
+		t.Fatal(err)
+	}
+
+	o.Observe(mongo.Observation{
+		Draw:            1,
+		Duration:        20 * time.Millisecond,
+		RecordsTotal:    100,
+		RecordsFiltered: 10,
+	})
+	o.Observe(mongo.Observation{
+		Draw:            2,
+		Duration:        30 * time.Millisecond,
+		RecordsTotal:    100,
+		RecordsFiltered: 5,
+	})
+	if n := testutil.ToFloat64(o.recordsFiltered); n != 5 {
+		t.Errorf("unexpected records filtered, want %d, got %v", 5, n)
+	}
+
+	expected := `
+# HELP test_datatables_records_total Total records of the last Datatables request.
+# TYPE test_datatables_records_total gauge
+test_datatables_records_total{table="users"} 100
+`
+	err := testutil.GatherAndCompare(reg, strings.NewReader(expected),
+		"test_datatables_records_total")
+	if err != nil {
+		t.Error(err)
+	}
+
+	o.Observe(mongo.Observation{
+		Draw:  3,
+		Error: "no reachable servers",
+	})
+
+	if n := testutil.ToFloat64(o.requests.WithLabelValues("success")); n != 2 {
+		t.Errorf("unexpected success count, want %d, got %v", 2, n)
+	}
+	if n := testutil.ToFloat64(o.requests.WithLabelValues("error")); n != 1 {
+		t.Errorf("unexpected error count, want %d, got %v", 1, n)
+	}
+	count, err := testutil.GatherAndCount(reg, "test_datatables_request_duration_seconds")
+	if err != nil {
+		t.Error(err)
+	}
+	if count != 1 {
+		t.Errorf("unexpected number of histograms, want %d, got %d", 1, count)
+	}
+}
@@ -2,9 +2,18 @@
 package mongo
 
 import (
-	"encoding/json"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net"
 	"net/http"
 	"regexp"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
 
 	"github.com/basvdlei/godatatables/types"
 	"gopkg.in/mgo.v2"
@@ -14,12 +23,28 @@ import (
 // Query interface defines the *mgo.Query methods used.
 type Query interface {
 	All(result interface{}) error
+	Batch(n int) Query
 	Count() (n int, err error)
+	Distinct(key string, result interface{}) error
+	Hint(indexKey ...string) Query
+	Iter() Iter
 	Limit(n int) Query
 	Skip(n int) Query
 	Sort(fields ...string) Query
 }
 
+// Iter interface contains the *mgo.Iter methods used.
+type Iter interface {
+	Next(result interface{}) bool
+	Close() error
+}
+
+// Results interface contains the method used to retrieve all results of a
+// *mgo.Query or *mgo.Pipe.
+type Results interface {
+	All(result interface{}) error
+}
+
 // Collection interface contains the *mgo.Collection methods used.
 type Collection interface {
 	Count() (n int, err error)
@@ -37,11 +62,35 @@ func (w *queryWrapper) All(result interface{}) error {
 	return w.q.All(result)
 }
 
+// Batch wraps *mgo.Query.Batch().
+func (w *queryWrapper) Batch(n int) Query {
+	return &queryWrapper{
+		q: w.q.Batch(n),
+	}
+}
+
 // Count wraps *mgo.Query.Count().
 func (w *queryWrapper) Count() (n int, err error) {
 	return w.q.Count()
 }
 
+// Distinct wraps *mgo.Query.Distinct().
+func (w *queryWrapper) Distinct(key string, result interface{}) error {
+	return w.q.Distinct(key, result)
+}
+
+// Hint wraps *mgo.Query.Hint().
+func (w *queryWrapper) Hint(indexKey ...string) Query {
+	return &queryWrapper{
+		q: w.q.Hint(indexKey...),
+	}
+}
+
+// Iter wraps *mgo.Query.Iter().
+func (w *queryWrapper) Iter() Iter {
+	return w.q.Iter()
+}
+
 // Limit wraps *mgo.Query.Limit().
 func (w *queryWrapper) Limit(n int) Query {
 	return &queryWrapper{
@@ -81,9 +130,275 @@ func (cw *collectionWrapper) Find(query interface{}) Query {
 	}
 }
 
+// WithMode returns the collection on a copy of the session using the
+// consistency mode. The returned function closes the session copy.
+func (cw *collectionWrapper) WithMode(mode mgo.Mode) (Collection, func()) {
+	s := cw.c.Database.Session.Copy()
+	s.SetMode(mode, true)
+	return &collectionWrapper{c: cw.c.With(s)}, s.Close
+}
+
+// ModeCollection is implemented by collections that can be queried with a
+// different consistency mode, like the collections of NewCollectionHandler.
+type ModeCollection interface {
+	Collection
+	WithMode(mode mgo.Mode) (c Collection, close func())
+}
+
+const (
+	// ModifiedSinceParam is the request parameter containing the RFC 3339
+	// timestamp for "modified since" polling.
+	ModifiedSinceParam = "modifiedSince"
+	// KeysetParam is the request parameter containing the keyset
+	// pagination token, the KeysetField value of the last record of the
+	// previous page.
+	KeysetParam = "after"
+	// DistinctParam is the request parameter containing the column data
+	// to return the distinct values of, see DistinctResponse.
+	DistinctParam = "distinct"
+	// Base64Param is the request parameter containing the whole Datatables
+	// request as base64 encoded JSON, see types.ParseBase64. When present
+	// the other Datatables parameters are ignored.
+	Base64Param = "dt"
+)
+
+// StaleTotal configures how a cached records total that is lower than the
+// live records filtered is handled, which happens when the collection grew
+// since the total was cached and confuses the pager of DataTables.
+type StaleTotal int
+
+const (
+	// StaleTotalRefresh counts the records total again and caches it.
+	StaleTotalRefresh StaleTotal = iota
+	// StaleTotalClamp lowers the records filtered to the cached total.
+	StaleTotalClamp
+	// StaleTotalIgnore returns the counts as they are.
+	StaleTotalIgnore
+)
+
+// SearchCombine configures how the global search and the column searches of a
+// request are combined.
+type SearchCombine int
+
+const (
+	// SearchCombineAnd matches the records matching both the global search
+	// and all column searches.
+	SearchCombineAnd SearchCombine = iota
+	// SearchCombineOr matches the records matching either the global
+	// search or all column searches.
+	SearchCombineOr
+)
+
 // CollectionHandler provides a HTTP handler for a mgo collection.
 type CollectionHandler struct {
 	Collection Collection
+	// Filter configures how the filter is created from the request.
+	Filter FilterOptions
+	// ModifiedField is the document field containing the modification
+	// time. When set, requests carrying the ModifiedSinceParam only return
+	// documents that were modified after the given timestamp. This allows
+	// frequently polling dashboards to skip fetching unchanged data.
+	ModifiedField string
+	// MaxCost rejects requests whose cost, as estimated by the CostFunc,
+	// exceeds it before querying the collection. Plain text clients get a
+	// 413 Payload Too Large, others a Datatables error with ErrTooExpensive.
+	MaxCost int
+	// CostFunc estimates the cost of a request for the MaxCost. Defaults
+	// to EstimateCost.
+	CostFunc func(r types.Request) int
+	// ErrorCodes adds the errors with a stable machine readable code to
+	// the responses, see types.ErrorDetail.
+	ErrorCodes bool
+	// ColumnCounts adds the number of records matching each active column
+	// search on its own, ignoring the other searches, to the responses.
+	// This is useful for "N matches" badges on the column filters, but
+	// runs an extra count per active column search.
+	ColumnCounts bool
+	// Coalesce shares the result of a query between the concurrent
+	// requests for the same records, as identified by the CacheKey of the
+	// request, so identical draws (eg of a dashboard opened in many tabs)
+	// run the query once. The draw counter is set per response. The shared
+	// query is not canceled when one of the requests is.
+	Coalesce bool
+	// ETag enables weak entity tags for the responses. GET requests with a
+	// matching If-None-Match header get a 304 Not Modified without body.
+	// The tags are computed over the data and counts only, excluding the
+	// draw counter.
+	ETag bool
+	// Observer is notified after every queried request.
+	Observer Observer
+	// DetailFields are moved from the row data into DT_RowData, making
+	// them available to child rows without showing them as columns.
+	DetailFields []string
+	// IDFormat formats the ObjectIds of the row data, defaults to their
+	// hexadecimal representation.
+	IDFormat func(id bson.ObjectId) string
+	// DateFormat is the time layout of the dates of the row data,
+	// defaults to time.RFC3339Nano.
+	DateFormat string
+	// DefaultLength is the number of records returned when the request
+	// does not specify a length. Without it such requests return all
+	// records. Requests with an explicit length of zero only get the
+	// counts.
+	DefaultLength int
+	// Debug adds the generated filter and sort fields to the responses.
+	// Never enable this in production since it exposes query details.
+	Debug bool
+	// StringCounters encodes the draw and records counters as strings.
+	StringCounters bool
+	// MaxFormSize limits the size of POST request bodies in bytes. By
+	// default url encoded bodies are limited to 10 MB by
+	// *http.Request.ParseForm, which tables with many columns can exceed.
+	MaxFormSize int64
+	// Legacy serves DataTables 1.9 clients, which use the legacy request
+	// parameter and response field names.
+	Legacy bool
+	// ResponsePath nests the responses under the dot separated key path,
+	// eg `result` for {"result":{"data":[...]}}, for front ends with a
+	// custom ajax dataSrc.
+	ResponsePath string
+	// EchoSearch adds the applied search values to the responses.
+	EchoSearch bool
+	// ServerTime adds the time spent processing the request to the
+	// responses, which excludes the encoding of the response itself.
+	ServerTime bool
+	// EchoSort adds the applied sort fields to the responses, for clients
+	// that don't track the order themselves.
+	EchoSort bool
+	// EchoColumns adds the column configuration that was used to the
+	// responses as the non-standard `columns` field, for generic front
+	// ends that build their columns from the server. The data of the
+	// columns is resolved using the FieldOrder.
+	EchoColumns bool
+	// MaxFilteredCount caps the number of filtered records that are
+	// counted. Counting stops at the cap, which is a lot cheaper for large
+	// result sets. The returned records filtered is then at most the cap
+	// and DataTables will only page up to it.
+	MaxFilteredCount int
+	// DeepPagingThreshold is the start offset above which skipping
+	// becomes too expensive. Such requests carrying a KeysetParam token
+	// use a range scan on the KeysetField instead. Without a token (or
+	// when ordered on other columns) the Observer is notified of the deep
+	// paging request.
+	DeepPagingThreshold int
+	// KeysetField is the indexed field used for keyset pagination,
+	// defaults to `_id`.
+	KeysetField string
+	// Encoder encodes the responses, defaults to JSONEncoder.
+	Encoder Encoder
+	// FilterHook is called with the generated filter before it is used for
+	// querying and returns the filter to use instead, eg to add a
+	// `$comment` for profiling. A hook narrowing the query, eg scoping it
+	// to a tenant, scopes the records total as well unless a
+	// TotalCountFunc is set.
+	FilterHook func(f bson.M) bson.M
+	// Pretty indents the responses using the PrettyJSONEncoder when no
+	// Encoder is set. Only use this during development since it bloats
+	// the responses.
+	Pretty bool
+	// ErrorStatus is the status code of responses to invalid requests,
+	// defaults to 200 OK since not all DataTables versions show the error
+	// of other responses. The error is always included in the body.
+	ErrorStatus int
+	// HardRowCap is the maximum number of records returned for a single
+	// request, regardless of the requested length. Responses with more
+	// records are truncated and flagged as such.
+	HardRowCap int
+	// BatchSize fetches the data with a cursor in batches of this size
+	// instead of loading the whole page at once, which bounds the memory
+	// used for large pages. Fetching stops when the request is cancelled.
+	BatchSize int
+	// PartialOnTimeout returns the records fetched so far when the cursor
+	// of the BatchSize times out, instead of an error. The response is
+	// flagged as truncated and contains a warning.
+	PartialOnTimeout bool
+	// SlowQueryThreshold reports requests whose queries take longer than
+	// this duration to the SlowQueryFunc.
+	SlowQueryThreshold time.Duration
+	// SlowQueryFunc is called for slow queries, defaults to logging them
+	// using the standard logger.
+	SlowQueryFunc func(q SlowQuery)
+	// Collation is used for the queries when the Collection is a
+	// CollationCollection. Eg `&mgo.Collation{Locale: "en", Strength: 1}`
+	// matches the searches of the FilterOptions ExactColumns case and
+	// accent insensitively, so "resume" matches "résumé". Regular
+	// expressions ignore the collation. The queries can only use indexes
+	// with the same collation.
+	Collation *mgo.Collation
+	// LocaleFunc returns the collation locale of a request, eg
+	// AcceptLanguageLocale or ParamLocale. The locale replaces the
+	// Locale of the Collation, or is used without other options when the
+	// Collation is nil. Requests without a locale use the Collation.
+	LocaleFunc func(r *http.Request) string
+	// Hint forces the queries to use the index with these key fields, in
+	// the format of the index key, eg `[]string{"name", "-created"}`.
+	Hint []string
+	// Fields restricts the column data to these document fields. Column
+	// data containing operators is always rejected.
+	Fields []string
+	// ReadMode sets the consistency mode used for the queries when the
+	// Collection is a ModeCollection, eg mgo.SecondaryPreferred to offload
+	// the primary. Secondaries can lag behind the primary, so recent
+	// changes might not be visible yet and the counts and data can be
+	// read from different members.
+	ReadMode *mgo.Mode
+	// ArrayRows encodes the rows as arrays in the order of the request
+	// columns instead of objects, for tables that render the columns by
+	// position. The DT_ row properties (and so the DetailFields) are not
+	// available for array rows.
+	ArrayRows bool
+	// FieldOrder contains the document fields of array sourced tables by
+	// position. Integer column data is resolved to these fields before
+	// filtering and sorting. Combine it with ArrayRows to return the rows
+	// as arrays again.
+	FieldOrder []string
+	// EstimateFiltered skips counting the filtered records, which can be
+	// expensive for large collections. Instead one extra record is fetched
+	// to detect if there are more, and the records filtered is set to the
+	// end of the page plus one if so. This is enough for DataTables to
+	// enable the next button, but the pager can't show the number of
+	// pages.
+	EstimateFiltered bool
+	// RequireSearchable rejects requests with a global search when none of
+	// the columns are searchable, instead of silently returning all
+	// records. This helps to spot misconfigured tables during setup.
+	RequireSearchable bool
+	// CacheTotal reuses the records total for the given duration instead
+	// of counting the whole collection on every request. The total is
+	// always counted on the first draw, later draws (eg while typing a
+	// search) get the cached value. The tradeoff is that the total shown
+	// by DataTables can be stale by up to this duration. The totals of
+	// views are cached separately.
+	CacheTotal time.Duration
+	// StaleTotal configures how a cached total lower than the records
+	// filtered is handled. Defaults to StaleTotalRefresh.
+	StaleTotal StaleTotal
+	// TotalCountFunc returns the records total instead of counting the
+	// Collection, eg from a maintained counter document. The CacheTotal
+	// applies to it as well. It takes precedence over a FilterHook, but is
+	// not used for the totals of views, which are counted with the view
+	// filter.
+	TotalCountFunc func() (int, error)
+	// SkipTotalCount never counts the records total, which is meaningless
+	// or too expensive for eg event and log tables. The records total is
+	// set to the records filtered instead, so DataTables pages using the
+	// filtered count only.
+	SkipTotalCount bool
+	// CountDocuments counts the records with a `$match` and `$count`
+	// aggregation, like the countDocuments of the official drivers,
+	// instead of the count command. Use it for collections that are
+	// MongoDB views, whose counts are computed from the view pipeline
+	// anyway and can't use the collection metadata. Requires a Collection
+	// that is a PipeCollection, like the collections of
+	// NewCollectionHandler, the count command is used otherwise. The Hint
+	// and Collation don't apply to the aggregation.
+	CountDocuments bool
+
+	flight    flightGroup
+	totalMu   sync.Mutex
+	totals    map[string]cachedTotal
+	closeOnce sync.Once
+	closeErr  error
 }
 
 // NewCollectionHandler returns a CollectionHandler for the given collection.
@@ -93,116 +408,1327 @@ func NewCollectionHandler(c *mgo.Collection) *CollectionHandler {
 	}
 }
 
+// Close releases the resources of the handler when it is retired. It drops
+// the cached total and closes the Collection if it implements io.Closer.
+// Close is safe to call multiple times, the handler should not be used after
+// it has been closed.
+func (ch *CollectionHandler) Close() error {
+	ch.closeOnce.Do(func() {
+		ch.totalMu.Lock()
+		ch.totals = nil
+		ch.totalMu.Unlock()
+		if c, ok := ch.Collection.(io.Closer); ok {
+			ch.closeErr = c.Close()
+		}
+	})
+	return ch.closeErr
+}
+
 // ServeHTTP implements the http.Handler interface
 func (ch *CollectionHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	received := time.Now()
+	if ch.MaxFormSize > 0 && r.Body != nil {
+		r.Body = http.MaxBytesReader(w, r.Body, ch.MaxFormSize)
+	}
 	if err := r.ParseForm(); err != nil {
-		w.WriteHeader(http.StatusBadRequest)
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			err = fmt.Errorf("request body exceeds the limit of %d bytes",
+				tooLarge.Limit)
+		}
+		ch.invalidRequest(w, r, 0, err)
 		return
 	}
-	dtRequest, err := types.ParseURLValues(r.Form)
+	parse := types.ParseURLValues
+	if ch.Legacy {
+		parse = types.ParseLegacyURLValues
+	}
+	var dtRequest types.Request
+	var err error
+	if v := r.Form.Get(Base64Param); v != "" {
+		dtRequest, err = types.ParseBase64(v)
+	} else {
+		dtRequest, err = parse(r.Form)
+	}
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
+		ch.invalidRequest(w, r, 0, err)
+		return
+	}
+	if ch.FieldOrder != nil {
+		dtRequest = ResolveFields(dtRequest, ch.FieldOrder)
+	}
+	if err := ValidateFields(dtRequest, ch.Filter.allowedFields(ch.Fields)...); err != nil {
+		ch.invalidRequest(w, r, dtRequest.Draw, err)
 		return
 	}
+	if ch.RequireSearchable && len(ch.Filter.SearchFields) == 0 &&
+		!searchable(dtRequest) {
+		ch.invalidRequest(w, r, dtRequest.Draw, ErrNotSearchable)
+		return
+	}
+	if err := ch.Filter.ValidateSearch(dtRequest); err != nil {
+		ch.invalidRequest(w, r, dtRequest.Draw, err)
+		return
+	}
+	dtRequest = dtRequest.WithDefaultLength(ch.DefaultLength)
+	if ch.MaxCost > 0 {
+		cost := ch.CostFunc
+		if cost == nil {
+			cost = EstimateCost
+		}
+		if n := cost(dtRequest); n > ch.MaxCost {
+			err := fmt.Errorf("%w: cost %d exceeds %d", ErrTooExpensive,
+				n, ch.MaxCost)
+			if plainText(r) {
+				http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+				return
+			}
+			ch.invalidRequest(w, r, dtRequest.Draw, err)
+			return
+		}
+	}
+	start := time.Now()
 	var dtResponse types.Response
+	p := queryParams{
+		opts:      ch.Filter,
+		keyset:    r.Form.Get(KeysetParam),
+		collation: ch.collation(r),
+	}
+	view, _ := r.Context().Value(viewKey{}).(*ViewHandler)
+	if view != nil && len(view.Sort) > 0 {
+		p.opts.DefaultSort = view.Sort
+	}
+	p.viewFilter = view != nil && len(view.Filter) > 0
+	p.restrict = func(f bson.M) bson.M {
+		if view != nil && len(view.Filter) > 0 {
+			f = bson.M{"$and": []bson.M{view.Filter, f}}
+		}
+		if ch.FilterHook != nil {
+			f = ch.FilterHook(f)
+		}
+		return f
+	}
+	f := p.opts.CreateFilter(dtRequest)
+	if v := r.Form.Get(ModifiedSinceParam); v != "" && ch.ModifiedField != "" {
+		since, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			ch.invalidRequest(w, r, dtRequest.Draw, err)
+			return
+		}
+		f = ModifiedSinceFilter(f, ch.ModifiedField, since)
+		p.polling = true
+		p.since = since
+	}
+	f = p.restrict(f)
+	p.filter = f
+	c := ch.Collection
+	if mc, ok := c.(ModeCollection); ok && ch.ReadMode != nil {
+		var done func()
+		c, done = mc.WithMode(*ch.ReadMode)
+		defer done()
+	}
+	q := ch.find(c, f, p.collation)
+	if v := r.Form.Get(DistinctParam); v != "" {
+		ch.serveDistinct(w, r, q, dtRequest.Draw, v)
+		return
+	}
+	var deepPaging bool
+	if ch.Coalesce {
+		dtResponse, deepPaging = ch.coalesce(r, dtRequest, p, func(ctx context.Context) (types.Response, bool) {
+			return ch.query(ctx, c, q, dtRequest, p)
+		})
+	} else {
+		dtResponse, deepPaging = ch.query(r.Context(), c, q, dtRequest, p)
+	}
 	dtResponse.Draw = dtRequest.Draw
-	f := CreateFilter(dtRequest)
-	q := ch.Collection.Find(f)
-	dtResponse.RecordsFiltered, err = q.Count()
-	if err != nil {
-		dtResponse.Error = err.Error()
+	if errors.Is(r.Context().Err(), context.Canceled) {
+		// DataTables cancels the requests of previous draws, eg while
+		// the user is typing, which is not an error. Deadlines are.
+		dtResponse.Data = []types.Row{}
+		dtResponse.Error = ""
 	}
-	dtResponse.RecordsTotal, err = ch.Collection.Count()
-	if err != nil {
-		dtResponse.Error = err.Error()
+	if ch.ErrorCodes && dtResponse.Error != "" {
+		code := types.ErrorCodeQueryFailed
+		if dtResponse.ErrorDetail != nil {
+			code = dtResponse.ErrorDetail.Code
+		}
+		if isTimeout(r.Context().Err()) {
+			code = types.ErrorCodeTimeout
+		}
+		dtResponse.ErrorDetail = &types.ErrorDetail{
+			Code:    code,
+			Message: dtResponse.Error,
+		}
 	}
-	q = SortQuery(q, dtRequest)
-	q = RangeQuery(q, dtRequest)
-	dtResponse.Data, err = ResponseData(q)
-	if err != nil {
-		dtResponse.Error = err.Error()
+	if ch.EchoSearch {
+		dtResponse.Search = p.opts.AppliedSearch(dtRequest)
+	}
+	if ch.EchoSort {
+		dtResponse.Sort = AppliedSort(p.opts.SortFields(dtRequest))
+	}
+	if ch.EchoColumns {
+		dtResponse.Columns = dtRequest.AppliedColumns()
 	}
-	e := json.NewEncoder(w)
-	err = e.Encode(&dtResponse)
+	if ch.Debug {
+		dtResponse.Debug = &types.Debug{
+			Filter: f,
+			Sort:   p.opts.SortFields(dtRequest),
+		}
+	}
+	duration := time.Since(start)
+	if ch.SlowQueryThreshold > 0 && duration > ch.SlowQueryThreshold {
+		slow := ch.SlowQueryFunc
+		if slow == nil {
+			slow = logSlowQuery
+		}
+		slow(SlowQuery{
+			Draw:     dtRequest.Draw,
+			Filter:   f,
+			Sort:     p.opts.SortFields(dtRequest),
+			Duration: duration,
+		})
+	}
+	if ch.Observer != nil {
+		o := observation(dtResponse, duration)
+		o.DeepPaging = deepPaging
+		ch.Observer.Observe(o)
+	}
+	if ch.ETag && dtResponse.Error == "" {
+		if tag, err := dtResponse.ETag(); err == nil {
+			w.Header().Set("ETag", tag)
+			conditional := r.Method == http.MethodGet || r.Method == http.MethodHead
+			if conditional && matchETag(r.Header.Get("If-None-Match"), tag) {
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+		}
+	}
+	if dtResponse.Error != "" && plainText(r) {
+		http.Error(w, "query failed: "+dtResponse.Error,
+			http.StatusInternalServerError)
+		return
+	}
+	if ch.ServerTime {
+		ms := float64(time.Since(received)) / float64(time.Millisecond)
+		dtResponse.ServerTime = &ms
+	}
+	err = ch.encode(w, &dtResponse)
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 	}
 }
 
+// queryParams contains the parameters of a query that depend on the request
+// besides the Datatables Request.
+type queryParams struct {
+	// opts are the FilterOptions of the handler with the DefaultSort of
+	// the view.
+	opts FilterOptions
+	// filter is the filter of the records.
+	filter bson.M
+	// restrict applies the view filter and FilterHook to a filter.
+	restrict func(f bson.M) bson.M
+	// viewFilter is set when restrict applies a view filter.
+	viewFilter bool
+	// polling is set for "modified since" polling requests.
+	polling bool
+	// since is the time of a "modified since" polling request.
+	since time.Time
+	// keyset is the keyset pagination token.
+	keyset string
+	// collation is the collation of the request.
+	collation *mgo.Collation
+}
+
+// query counts and fetches the records of the Datatables Request. It reports
+// if this was a deep paging request that could not use the keyset.
+func (ch *CollectionHandler) query(ctx context.Context, c Collection, q Query, dtRequest types.Request, p queryParams) (dtResponse types.Response, deepPaging bool) {
+	var err error
+	if !ch.EstimateFiltered {
+		dtResponse.RecordsFiltered, err = ch.countFiltered(c, q, p)
+		if err != nil {
+			ch.setError(&dtResponse, err)
+		}
+	}
+	if !ch.SkipTotalCount {
+		dtResponse.RecordsTotal, err = ch.recordsTotal(c, dtRequest.Draw, p)
+		if err != nil {
+			ch.setError(&dtResponse, err)
+		}
+	}
+	if ch.ColumnCounts {
+		dtResponse.ColumnCounts, err = ch.columnCounts(c, dtRequest, p)
+		if err != nil {
+			ch.setError(&dtResponse, err)
+		}
+	}
+	if p.polling && !ch.EstimateFiltered &&
+		dtResponse.RecordsFiltered == 0 && dtResponse.Error == "" {
+		// Nothing changed, no need to fetch the data.
+		dtResponse.Data = []types.Row{}
+	} else if dtRequest.Length == 0 {
+		// Only the counts were requested.
+		dtResponse.Data = []types.Row{}
+	} else {
+		pageRequest := dtRequest
+		capped := ch.HardRowCap > 0 &&
+			(pageRequest.Length < 0 || pageRequest.Length > ch.HardRowCap)
+		if capped {
+			// Fetch one extra record to detect if the cap is exceeded.
+			pageRequest.Length = ch.HardRowCap + 1
+		} else if ch.EstimateFiltered && pageRequest.Length >= 0 {
+			// Fetch one extra record to detect if there are more.
+			pageRequest.Length++
+		}
+		q, deepPaging = ch.pageQuery(c, q, pageRequest, p)
+		if ch.BatchSize > 0 && ch.PartialOnTimeout {
+			dtResponse.Data, err = iterData(ctx,
+				q.Batch(ch.BatchSize).Iter(), ch.valueFormat())
+			if err != nil && isTimeout(err) {
+				dtResponse.Warning = "query timed out, the data is incomplete"
+				dtResponse.Truncated = true
+				err = nil
+			} else if err != nil {
+				dtResponse.Data = nil
+			}
+		} else if ch.BatchSize > 0 {
+			dtResponse.Data, err = iterData(ctx,
+				q.Batch(ch.BatchSize).Iter(), ch.valueFormat())
+			if err != nil {
+				dtResponse.Data = nil
+			}
+		} else {
+			dtResponse.Data, err = responseData(q, ch.valueFormat())
+		}
+		if err != nil {
+			ch.setError(&dtResponse, err)
+		}
+		if ch.EstimateFiltered {
+			dtResponse.Data, dtResponse.RecordsFiltered =
+				estimateFiltered(dtResponse.Data, dtRequest)
+		}
+		if capped && len(dtResponse.Data) > ch.HardRowCap {
+			dtResponse.Data = dtResponse.Data[:ch.HardRowCap]
+			dtResponse.Truncated = true
+		}
+		DetailData(dtResponse.Data, ch.DetailFields...)
+		if ch.ArrayRows {
+			dtRequest.ArrayRows(dtResponse.Data)
+		}
+	}
+	if ch.SkipTotalCount {
+		dtResponse.RecordsTotal = dtResponse.RecordsFiltered
+	} else if ch.CacheTotal > 0 && dtResponse.Error == "" &&
+		dtResponse.RecordsFiltered > dtResponse.RecordsTotal {
+		ch.reconcileTotal(c, &dtResponse, p)
+	}
+	return dtResponse, deepPaging
+}
+
+// setError sets the error of the query on the Response. With ErrorCodes the
+// error code is set as well, where timeouts are detected like isTimeout.
+func (ch *CollectionHandler) setError(resp *types.Response, err error) {
+	resp.Error = err.Error()
+	if !ch.ErrorCodes {
+		return
+	}
+	code := types.ErrorCodeQueryFailed
+	if isTimeout(err) {
+		code = types.ErrorCodeTimeout
+	}
+	resp.ErrorDetail = &types.ErrorDetail{Code: code, Message: resp.Error}
+}
+
+// reconcileTotal handles a stale cached total that is lower than the
+// records filtered according to the StaleTotal option.
+func (ch *CollectionHandler) reconcileTotal(c Collection, resp *types.Response, p queryParams) {
+	switch ch.StaleTotal {
+	case StaleTotalRefresh:
+		// The total is always counted on the first draw.
+		n, err := ch.recordsTotal(c, 1, p)
+		if err != nil {
+			ch.setError(resp, err)
+			return
+		}
+		resp.RecordsTotal = n
+	case StaleTotalClamp:
+		resp.RecordsFiltered = resp.RecordsTotal
+	}
+}
+
+// encoder returns the Encoder for the responses.
+func (ch *CollectionHandler) encoder() Encoder {
+	switch {
+	case ch.Encoder != nil:
+		return ch.Encoder
+	case ch.Pretty:
+		return PrettyJSONEncoder
+	}
+	return JSONEncoder
+}
+
+// invalidRequest responds with the ErrorStatus containing the error in a
+// Datatables Response, so DataTables shows the details. Plain text clients
+// receive a 400 Bad Request with the reason.
+func (ch *CollectionHandler) invalidRequest(w http.ResponseWriter, r *http.Request, draw int, err error) {
+	if plainText(r) {
+		badRequest(w, r, err)
+		return
+	}
+	resp := types.Response{
+		Draw:  draw,
+		Data:  []types.Row{},
+		Error: err.Error(),
+	}
+	if ch.ErrorCodes {
+		resp.ErrorDetail = &types.ErrorDetail{
+			Code:    types.ErrorCodeBadRequest,
+			Message: resp.Error,
+		}
+	}
+	w.WriteHeader(ch.errorStatus())
+	ch.encode(w, &resp)
+}
+
+// encode writes the Response using the Legacy, StringCounters and
+// ResponsePath options.
+func (ch *CollectionHandler) encode(w io.Writer, resp *types.Response) error {
+	var v interface{} = resp
+	switch {
+	case ch.Legacy:
+		v = types.LegacyResponse(*resp)
+	case ch.StringCounters:
+		v = types.StringCountersResponse(*resp)
+	}
+	if ch.ResponsePath != "" {
+		v = types.Nested{Path: ch.ResponsePath, Value: v}
+	}
+	return ch.encoder().Encode(w, v)
+}
+
+// errorStatus returns the status code for error responses.
+func (ch *CollectionHandler) errorStatus() int {
+	return errorStatus(ch.ErrorStatus)
+}
+
+// errorStatus returns the configured status code of invalid requests,
+// defaulting to 200 OK.
+func errorStatus(status int) int {
+	if status == 0 {
+		return http.StatusOK
+	}
+	return status
+}
+
+// cachedTotal is a records total cached for the CacheTotal.
+type cachedTotal struct {
+	n    int
+	time time.Time
+}
+
+// recordsTotal counts all documents reachable through the handler, or returns
+// the cached total for later draws if CacheTotal is set. The view filter and
+// a narrowing FilterHook apply to the total like to the records, so the size
+// of the rest of the collection is not revealed. The totals are cached by the
+// filter.
+func (ch *CollectionHandler) recordsTotal(c Collection, draw int, p queryParams) (int, error) {
+	var key string
+	count := c.Count
+	if f := p.restrict(bson.M{}); narrows(f) &&
+		(p.viewFilter || ch.TotalCountFunc == nil) {
+		key = fmt.Sprint(f)
+		count = func() (int, error) {
+			return ch.count(c, f, p.collation, 0)
+		}
+	} else if ch.TotalCountFunc != nil {
+		count = ch.TotalCountFunc
+	} else if pc, ok := ch.pipeCollection(c); ok {
+		count = func() (int, error) {
+			return countDocuments(pc, bson.M{}, 0)
+		}
+	}
+	if ch.CacheTotal <= 0 {
+		return count()
+	}
+	ch.totalMu.Lock()
+	if t, ok := ch.totals[key]; ok && draw > 1 &&
+		time.Since(t.time) < ch.CacheTotal {
+		ch.totalMu.Unlock()
+		return t.n, nil
+	}
+	ch.totalMu.Unlock()
+	n, err := count()
+	if err != nil {
+		return n, err
+	}
+	ch.totalMu.Lock()
+	if ch.totals == nil {
+		ch.totals = make(map[string]cachedTotal)
+	}
+	ch.totals[key] = cachedTotal{n: n, time: time.Now()}
+	ch.totalMu.Unlock()
+	return n, nil
+}
+
+// narrows reports if the filter has any condition, besides a `$comment`
+// which matches all documents.
+func narrows(f bson.M) bool {
+	for k := range f {
+		if k != "$comment" {
+			return true
+		}
+	}
+	return false
+}
+
+// estimateFiltered trims the extra record fetched beyond the requested length
+// and returns the estimated records filtered.
+func estimateFiltered(data []types.Row, r types.Request) ([]types.Row, int) {
+	n := r.Start + len(data)
+	if r.Length >= 0 && len(data) > r.Length {
+		data = data[:r.Length]
+		n = r.Start + r.Length + 1
+	}
+	return data, n
+}
+
+// find returns the query for the filter using the collation and Hint if set.
+func (ch *CollectionHandler) find(c Collection, f bson.M, collation *mgo.Collation) Query {
+	var q Query
+	if cc, ok := c.(CollationCollection); ok && collation != nil {
+		q = cc.FindCollation(f, collation)
+	} else {
+		q = c.Find(f)
+	}
+	if len(ch.Hint) > 0 {
+		q = q.Hint(ch.Hint...)
+	}
+	return q
+}
+
+// countFiltered counts the documents matching the filter, up to the
+// MaxFilteredCount if set.
+func (ch *CollectionHandler) countFiltered(c Collection, q Query, p queryParams) (int, error) {
+	if _, ok := ch.pipeCollection(c); ok || ch.MaxFilteredCount > 0 {
+		// Use a separate query since Limit modifies the query.
+		return ch.count(c, p.filter, p.collation, ch.MaxFilteredCount)
+	}
+	return q.Count()
+}
+
+// pageQuery sorts and ranges the query. Deep paging requests with a keyset
+// token use a range scan on the KeysetField instead of skipping. It reports
+// if this was a deep paging request that could not use the keyset.
+func (ch *CollectionHandler) pageQuery(c Collection, q Query, r types.Request, p queryParams) (Query, bool) {
+	if ch.DeepPagingThreshold <= 0 || r.Start <= ch.DeepPagingThreshold {
+		return RangeQuery(q.Sort(p.opts.SortFields(r)...), r), false
+	}
+	field := ch.KeysetField
+	if field == "" {
+		field = "_id"
+	}
+	if p.keyset == "" || !keysetOrder(p.opts, r, field) {
+		return RangeQuery(q.Sort(p.opts.SortFields(r)...), r), true
+	}
+	q = ch.find(c, bson.M{"$and": []bson.M{
+		p.filter,
+		{field: bson.M{"$gt": keysetValue(p.keyset)}},
+	}}, p.collation)
+	q = q.Sort(field)
+	if r.Length >= 0 {
+		q = q.Limit(r.Length)
+	}
+	return q, false
+}
+
+// keysetOrder reports if the Request is ordered in a way that allows keyset
+// pagination on the field, which is no order or ascending on the field. The
+// DefaultSort applies to requests without an order.
+func keysetOrder(o FilterOptions, r types.Request, field string) bool {
+	sort := SortFields(r)
+	if len(sort) == 0 {
+		sort = o.DefaultSort
+	}
+	return len(sort) == 0 || (len(sort) == 1 && sort[0] == field)
+}
+
+// keysetValue returns the value of a keyset token, ObjectIds are sent as
+// their hex representation.
+func keysetValue(token string) interface{} {
+	if bson.IsObjectIdHex(token) {
+		return bson.ObjectIdHex(token)
+	}
+	return token
+}
+
+// observation returns the Observation of the response.
+func observation(resp types.Response, d time.Duration) Observation {
+	return Observation{
+		Draw:            resp.Draw,
+		Duration:        d,
+		RecordsTotal:    resp.RecordsTotal,
+		RecordsFiltered: resp.RecordsFiltered,
+		Error:           resp.Error,
+	}
+}
+
+// plainText reports if the client prefers a plain text response, which is
+// the case when text/plain is the first media type of the Accept header.
+func plainText(r *http.Request) bool {
+	accept := strings.SplitN(r.Header.Get("Accept"), ",", 2)[0]
+	mediaType := strings.SplitN(accept, ";", 2)[0]
+	return strings.TrimSpace(mediaType) == "text/plain"
+}
+
+// ErrSearchTooLong is returned for requests with a search value longer than
+// the MaxSearchLength when RejectLongSearch is set.
+var ErrSearchTooLong = errors.New("search value too long")
+
+// ValidateSearch returns ErrSearchTooLong if RejectLongSearch is set and a
+// global or column search value of the Request is longer than the
+// MaxSearchLength.
+func (o FilterOptions) ValidateSearch(r types.Request) error {
+	if !o.RejectLongSearch || o.MaxSearchLength <= 0 {
+		return nil
+	}
+	if utf8.RuneCountInString(r.Search.Value) > o.MaxSearchLength {
+		return ErrSearchTooLong
+	}
+	for i, c := range r.Columns {
+		if utf8.RuneCountInString(c.Search.Value) > o.MaxSearchLength {
+			return fmt.Errorf("%w: column %d", ErrSearchTooLong, i)
+		}
+	}
+	return nil
+}
+
+// truncateSearch returns the Request with the global and column search
+// values truncated to the MaxSearchLength. The columns are copied when
+// changed, so the Request of the caller is not modified.
+func (o FilterOptions) truncateSearch(r types.Request) types.Request {
+	if o.MaxSearchLength <= 0 {
+		return r
+	}
+	r.Search.Value = truncate(r.Search.Value, o.MaxSearchLength)
+	copied := false
+	for i, c := range r.Columns {
+		v := truncate(c.Search.Value, o.MaxSearchLength)
+		if v == c.Search.Value {
+			continue
+		}
+		if !copied {
+			r.Columns = append([]types.Column(nil), r.Columns...)
+			copied = true
+		}
+		r.Columns[i].Search.Value = v
+	}
+	return r
+}
+
+// truncate returns the first n characters of v.
+func truncate(v string, n int) string {
+	if len(v) <= n {
+		return v
+	}
+	i := 0
+	for j := range v {
+		if i == n {
+			return v[:j]
+		}
+		i++
+	}
+	return v
+}
+
+// ErrNotSearchable is returned for requests with a global search when none
+// of the columns are searchable.
+var ErrNotSearchable = errors.New("global search requires at least one searchable column")
+
+// searchable reports if the global search of the Request can be applied,
+// which is when there is none or when at least one column is searchable.
+func searchable(r types.Request) bool {
+	if r.Search.Value == "" {
+		return true
+	}
+	for _, c := range r.Columns {
+		if c.GlobalSearchable() {
+			return true
+		}
+	}
+	return false
+}
+
+// badRequest writes a 400 Bad Request response. Plain text clients also
+// receive the reason.
+func badRequest(w http.ResponseWriter, r *http.Request, err error) {
+	if plainText(r) {
+		http.Error(w, "invalid datatables request: "+err.Error(),
+			http.StatusBadRequest)
+		return
+	}
+	w.WriteHeader(http.StatusBadRequest)
+}
+
+// matchETag reports if the If-None-Match header value matches the tag using
+// the weak comparison.
+func matchETag(header, tag string) bool {
+	for _, v := range strings.Split(header, ",") {
+		v = strings.TrimSpace(v)
+		if v == "*" || strings.TrimPrefix(v, "W/") == strings.TrimPrefix(tag, "W/") {
+			return true
+		}
+	}
+	return false
+}
+
 // ResponseData returns the data for a given query that can be used in a
 // Datatables Response.
-func ResponseData(q Query) (data []types.Row, err error) {
-	var results []map[string]string
+func ResponseData(q Results) (data []types.Row, err error) {
+	return responseData(q, valueFormat{})
+}
+
+// responseData returns the data for the query with the values formatted
+// using the valueFormat.
+func responseData(q Results, vf valueFormat) (data []types.Row, err error) {
+	var results []bson.M
 	if err = q.All(&results); err != nil {
 		return nil, err
 	}
 	data = make([]types.Row, len(results))
 	for i, r := range results {
-		data[i].Data = r
+		data[i].Data = vf.data(r)
 	}
 	return
 }
 
+// ResponseDataFromIter returns the data of the iterator that can be used in
+// a Datatables Response. The iterator is closed when done.
+func ResponseDataFromIter(iter Iter) (data []types.Row, err error) {
+	return ResponseDataFromIterContext(context.Background(), iter)
+}
+
+// ResponseDataFromIterContext returns the data of the iterator like
+// ResponseDataFromIter, but stops with the context error when the context
+// is done. The iterator is closed when done.
+func ResponseDataFromIterContext(ctx context.Context, iter Iter) (data []types.Row, err error) {
+	data, err = iterData(ctx, iter, valueFormat{})
+	if err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// iterData returns the data of the iterator until it is exhausted or the
+// context is done. On errors the data fetched so far is returned with the
+// error. The values are formatted using the valueFormat. The iterator is
+// closed when done.
+func iterData(ctx context.Context, iter Iter, vf valueFormat) (data []types.Row, err error) {
+	data = []types.Row{}
+	var result bson.M
+	for iter.Next(&result) {
+		data = append(data, types.Row{Data: vf.data(result)})
+		result = nil
+		if err = ctx.Err(); err != nil {
+			iter.Close()
+			return data, err
+		}
+	}
+	return data, iter.Close()
+}
+
+// isTimeout reports if the error is caused by a timeout of the context, the
+// connection or the server (the ExceededTimeLimit error of maxTimeMS).
+func isTimeout(err error) bool {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+	var queryErr *mgo.QueryError
+	return errors.As(err, &queryErr) && queryErr.Code == 50
+}
+
+// valueFormat configures the string representation of ObjectIds and dates.
+// The zero value uses the defaults.
+type valueFormat struct {
+	id   func(id bson.ObjectId) string
+	date string
+}
+
+// valueFormat returns the valueFormat of the IDFormat and DateFormat.
+func (ch *CollectionHandler) valueFormat() valueFormat {
+	return valueFormat{id: ch.IDFormat, date: ch.DateFormat}
+}
+
+// data converts the fields of a document to strings, so a field of an
+// unexpected type does not fail the whole response.
+func (vf valueFormat) data(doc bson.M) map[string]string {
+	data := make(map[string]string, len(doc))
+	for k, v := range doc {
+		data[k] = vf.value(v)
+	}
+	return data
+}
+
+// value returns the best-effort string representation of a document value.
+// Binary data is base64 encoded, ObjectIds are hex encoded and dates use
+// RFC 3339 unless formatted otherwise.
+func (vf valueFormat) value(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case bson.ObjectId:
+		if vf.id != nil {
+			return vf.id(v)
+		}
+		return v.Hex()
+	case []byte:
+		return base64.StdEncoding.EncodeToString(v)
+	case bson.Binary:
+		return base64.StdEncoding.EncodeToString(v.Data)
+	case time.Time:
+		if vf.date != "" {
+			return v.Format(vf.date)
+		}
+		return v.Format(time.RFC3339Nano)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// DetailData moves the given fields of the rows from Data into RowData.
+func DetailData(data []types.Row, fields ...string) {
+	if len(fields) == 0 {
+		return
+	}
+	for i := range data {
+		for _, f := range fields {
+			v, ok := data[i].Data[f]
+			if !ok {
+				continue
+			}
+			if data[i].RowData == nil {
+				data[i].RowData = make(map[string]string, len(fields))
+			}
+			data[i].RowData[f] = v
+			delete(data[i].Data, f)
+		}
+	}
+}
+
 // SortQuery sets the queries sort options based on the Request.
 func SortQuery(in Query, r types.Request) (out Query) {
-	sort := make([]string, len(r.Order))
-	for i, o := range r.Order {
+	out = in.Sort(SortFields(r)...)
+	return
+}
+
+// SortFields returns the sort fields for the Request in the format used by
+// *mgo.Query.Sort(). Only the first order of a column is used.
+func SortFields(r types.Request) []string {
+	sort := make([]string, 0, len(r.Order))
+	seen := make(map[string]bool, len(r.Order))
+	for _, o := range r.Order {
+		c, ok := r.OrderColumn(o)
+		if !ok || seen[c.Data] {
+			continue
+		}
+		seen[c.Data] = true
 		prefix := ""
 		if o.Dir == types.OrderDescending {
 			prefix = "-"
 		}
-		sort[i] = prefix + r.Columns[o.Column].Data
+		sort = append(sort, prefix+c.Data)
 	}
-	out = in.Sort(sort...)
-	return
+	return sort
+}
+
+// SortFields returns the sort fields for the Request like SortFields, where
+// the RelevanceColumn sorts by the text score (see TextSearch), requests
+// without an order use the DefaultSort and `_id` is appended as the final
+// ascending tiebreaker unless UnstableSort is set.
+func (o FilterOptions) SortFields(r types.Request) []string {
+	sort := o.relevanceSort(r, SortFields(r))
+	if len(sort) == 0 {
+		sort = append(sort, o.DefaultSort...)
+	}
+	if o.UnstableSort {
+		return sort
+	}
+	for _, f := range sort {
+		if f == "_id" || f == "-_id" {
+			return sort
+		}
+	}
+	return append(sort, "_id")
+}
+
+// AppliedSort converts the sort fields as returned by SortFields to the
+// applied orders of a Response.
+func AppliedSort(fields []string) []types.AppliedOrder {
+	sort := make([]types.AppliedOrder, len(fields))
+	for i, f := range fields {
+		if strings.HasPrefix(f, textScorePrefix) {
+			sort[i] = types.AppliedOrder{
+				Data: strings.TrimPrefix(f, textScorePrefix),
+				Dir:  types.OrderDescending,
+			}
+		} else if strings.HasPrefix(f, "-") {
+			sort[i] = types.AppliedOrder{Data: f[1:], Dir: types.OrderDescending}
+		} else {
+			sort[i] = types.AppliedOrder{Data: f, Dir: types.OrderAscending}
+		}
+	}
+	return sort
+}
+
+// AppliedSearch returns the searches of the Datatables Request that are used
+// by CreateFilter, with the values truncated to the MaxSearchLength. Searches
+// that are too short, on columns that aren't searchable or that can't be used
+// for the column are left out.
+func (o FilterOptions) AppliedSearch(r types.Request) *types.AppliedSearch {
+	r = o.truncateSearch(r)
+	s := &types.AppliedSearch{}
+	if r.Search.Value != "" && !tooShort(r.Search.Value, o.MinSearchLength) &&
+		(o.TextSearch || len(o.globalFields(r)) > 0) {
+		s.Global = r.Search
+	}
+	for _, c := range r.Columns {
+		if !c.Searchable || c.Search.Value == "" {
+			continue
+		}
+		if _, ok := o.columnFilter(c); !ok {
+			continue
+		}
+		if s.Columns == nil {
+			s.Columns = make(map[string]types.Search)
+		}
+		s.Columns[c.Data] = c.Search
+	}
+	return s
+}
+
+// SortDocument returns the ordered sort document for the Datatables Request,
+// with 1 for ascending and -1 for descending fields, as used by drivers
+// that take the sort as a document.
+func SortDocument(r types.Request) bson.D {
+	return keyDocument(SortFields(r))
 }
 
 // RangeQuery sets range of items to return based on the Datatables Request.
+// A negative length returns all records.
 func RangeQuery(in Query, r types.Request) (out Query) {
 	out = in.Skip(r.Start)
-	out = out.Limit(r.Length)
+	if r.Length >= 0 {
+		out = out.Limit(r.Length)
+	}
 	return
 }
 
+// ColumnType specifies how the values of a column are searched.
+type ColumnType int
+
+const (
+	// ColumnString columns are searched using regular expressions.
+	ColumnString ColumnType = iota
+	// ColumnBool columns contain booleans. Column searches match values
+	// equal to the boolean of the search value, which can be any of the
+	// values sent by checkboxes (eg `on`/`off`, `true`/`false` or `1`/`0`).
+	// They are excluded from the global search.
+	ColumnBool
+	// ColumnIDList columns contain IDs, eg `_id` or foreign keys. Column
+	// searches are comma-separated lists of IDs, eg of selected rows,
+	// matching any of the values literally using `$in`. Hexadecimal
+	// ObjectIds are matched as ObjectIds. They are excluded from the
+	// global search.
+	ColumnIDList
+)
+
+// FilterOptions configures how filters are created from a Datatables
+// Request.
+type FilterOptions struct {
+	// ColumnTypes contains the types of the columns by column data.
+	// Columns without a type are ColumnString.
+	ColumnTypes map[string]ColumnType
+	// CaseSensitiveColumns contains the column data fields that are
+	// searched case-sensitively. All other columns are searched
+	// case-insensitively.
+	CaseSensitiveColumns map[string]bool
+	// FoldCase matches the case variants of non-regex searches explicitly
+	// using FoldPattern instead of the `i` regex option, which misses
+	// variants like the Turkish dotless i. Regex searches still use the
+	// `i` option.
+	FoldCase bool
+	// AnchoredColumns contains the column data fields whose searches are
+	// anchored at the start of the value (`^value`). This changes the
+	// matching to a prefix match, but allows MongoDB to use an index on
+	// the field instead of scanning all values.
+	AnchoredColumns map[string]bool
+	// PrefixColumns contains the column data fields of autocomplete
+	// columns, whose searches always match the start of the value
+	// literally and case-sensitively (`^value`, without options). Unlike
+	// AnchoredColumns this ignores regex searches and the `i` option, so
+	// the pattern is a simple prefix that MongoDB can use index bounds for.
+	PrefixColumns map[string]bool
+	// MinSearchLength ignores global searches shorter than this number of
+	// characters, since searching all columns for a single character is
+	// expensive and rarely narrows down the results.
+	MinSearchLength int
+	// MinColumnSearchLength ignores string column searches shorter than
+	// this number of characters.
+	MinColumnSearchLength int
+	// WordPrefixSearch splits the global search into words that must each
+	// match the start of at least one searchable column, for type-ahead
+	// tables. The words are searched for literally.
+	WordPrefixSearch bool
+	// SearchCombine configures how the global search and the column
+	// searches are combined, defaults to SearchCombineAnd. Text searches
+	// and fixed searches are always combined using `$and`, since `$text`
+	// can't be part of an `$or` with unindexed clauses.
+	SearchCombine SearchCombine
+	// ExactColumns contains the column data fields of enum-like columns,
+	// whose column searches match the value exactly using `$eq` instead of
+	// a regular expression. The global search still uses a regular
+	// expression for these columns.
+	ExactColumns map[string]bool
+	// VirtualFields are computed values that are searched using `$expr`
+	// when the request contains a column with their name as data.
+	VirtualFields []VirtualField
+	// DateRangeColumns contains the column data fields of date columns
+	// whose column search is a relative range token, like `last7days`,
+	// that is converted to the bounds of the range. Unknown tokens are
+	// ignored.
+	DateRangeColumns map[string]DateRangeColumn
+	// Now returns the current time the relative ranges are computed from.
+	// Defaults to time.Now.
+	Now func() time.Time
+	// SearchFields are the fields the global search applies to instead of
+	// the searchable columns, eg an indexed subset of a wide table. The
+	// requested columns are still returned and column searches still
+	// apply to their column.
+	SearchFields []string
+	// DefaultSort contains the sort fields, in the format used by
+	// *mgo.Query.Sort(), of requests without an order.
+	DefaultSort []string
+	// UnstableSort disables the `_id` tiebreaker that is appended to every
+	// sort by default. Without it records with equal sort values, or all
+	// records when there is no order, can be returned in any order, so
+	// paging can duplicate or skip records.
+	UnstableSort bool
+	// MaxSearchLength limits the global and column search values to this
+	// number of characters, since long values become giant regular
+	// expressions. Longer values are truncated when creating the filter,
+	// or rejected by ValidateSearch when RejectLongSearch is set.
+	MaxSearchLength int
+	// RejectLongSearch rejects requests with search values longer than
+	// the MaxSearchLength instead of truncating them.
+	RejectLongSearch bool
+	// TextSearch uses a `$text` query for the global search instead of
+	// regular expressions, which requires a text index on the collection.
+	// In a pipeline the `$text` query must be in the first stage, so it
+	// can't be combined with Lookups.
+	TextSearch bool
+	// RelevanceColumn is the column data of a column without a document
+	// field that sorts by the text score while a TextSearch is active, see
+	// FilterOptions.SortFields.
+	RelevanceColumn string
+}
+
+// VirtualField is a searchable computed value, eg a full name of the first
+// and last name fields. The `$expr` queries can't use an index.
+type VirtualField struct {
+	// Name is the column data of the virtual field.
+	Name string
+	// Concat contains the parts of the value, field paths are prefixed
+	// with `$`. Eg []string{"$first_name", " ", "$last_name"}. Missing
+	// fields are concatenated as empty strings.
+	Concat []string
+}
+
+// filter returns the `$expr` filter matching the value with the RegEx.
+func (v VirtualField) filter(re bson.RegEx) bson.M {
+	parts := make([]interface{}, len(v.Concat))
+	for i, p := range v.Concat {
+		if strings.HasPrefix(p, "$") {
+			parts[i] = bson.M{"$ifNull": []interface{}{p, ""}}
+		} else {
+			parts[i] = p
+		}
+	}
+	return bson.M{"$expr": bson.M{"$regexMatch": bson.M{
+		"input": bson.M{"$concat": parts},
+		"regex": re,
+	}}}
+}
+
 // CreateFilter creates a BSON query from a Datatables Request.
 func CreateFilter(r types.Request) bson.M {
-	global := make([]bson.M, len(r.Columns))
-	column := make([]bson.M, 0, len(r.Columns))
-	for i, c := range r.Columns {
-		// Global search
-		global[i] = make(bson.M, 1)
-		if r.Search.Regex {
-			global[i][c.Data] = bson.RegEx{
-				Pattern: r.Search.Value,
-				Options: "i",
+	return FilterOptions{}.CreateFilter(r)
+}
+
+// CreateFilter creates a BSON query from a Datatables Request using the
+// options.
+func (o FilterOptions) CreateFilter(r types.Request) bson.M {
+	r = o.truncateSearch(r)
+	globalSearch := r.Search.Value != "" &&
+		!tooShort(r.Search.Value, o.MinSearchLength)
+	var extra []bson.M
+	// The slices are only allocated when there is something to search.
+	var global, column []bson.M
+	if globalSearch && o.TextSearch {
+		extra = append(extra, bson.M{"$text": bson.M{"$search": r.Search.Value}})
+		globalSearch = false
+	}
+	if globalSearch && o.WordPrefixSearch {
+		if f := o.wordPrefixFilter(r); f != nil && o.SearchCombine == SearchCombineOr {
+			global = append(global, f)
+		} else if f != nil {
+			extra = append(extra, f)
+		}
+		globalSearch = false
+	}
+	if globalSearch {
+		for _, field := range o.globalFields(r) {
+			global = append(global, o.match(field, o.regEx(field, r.Search)))
+		}
+	}
+	for _, c := range r.Columns {
+		// Column specific search
+		if c.Searchable && c.Search.Value != "" {
+			if m, ok := o.columnFilter(c); ok {
+				column = append(column, m)
 			}
-		} else {
-			global[i][c.Data] = bson.RegEx{
-				Pattern: regexp.QuoteMeta(r.Search.Value),
-				Options: "i",
+		}
+	}
+	q := combineFilter(global, column, o.SearchCombine)
+	extra = append(extra, o.fixedFilters(r)...)
+	if len(extra) == 0 {
+		return q
+	}
+	if len(q) > 0 {
+		extra = append([]bson.M{q}, extra...)
+	}
+	if len(extra) == 1 {
+		return extra[0]
+	}
+	return bson.M{"$and": extra}
+}
+
+// wordPrefixFilter returns the filter requiring every word of the global
+// search to match the start of at least one searchable column, or nil if
+// there is nothing to search.
+func (o FilterOptions) wordPrefixFilter(r types.Request) bson.M {
+	var words []bson.M
+	for _, word := range strings.Fields(r.Search.Value) {
+		var match []bson.M
+		for _, field := range o.globalFields(r) {
+			re := o.regEx(field, types.Search{Value: word})
+			if !strings.HasPrefix(re.Pattern, "^") {
+				re.Pattern = "^" + re.Pattern
 			}
+			match = append(match, o.match(field, re))
 		}
-		// Column specific search
-		if c.Search.Value != "" {
-			m := make(bson.M, 1)
-			if c.Search.Regex {
-				m[c.Data] = bson.RegEx{
-					Pattern: c.Search.Value,
-					Options: "i",
-				}
-			} else {
-				m[c.Data] = bson.RegEx{
-					Pattern: regexp.QuoteMeta(c.Search.Value),
-					Options: "i",
-				}
+		if len(match) > 0 {
+			words = append(words, bson.M{"$or": match})
+		}
+	}
+	switch len(words) {
+	case 0:
+		return nil
+	case 1:
+		return words[0]
+	}
+	return bson.M{"$and": words}
+}
+
+// globalFields returns the fields the global searches apply to, which are
+// the SearchFields if set and otherwise the global searchable string columns.
+func (o FilterOptions) globalFields(r types.Request) []string {
+	if len(o.SearchFields) > 0 {
+		return o.SearchFields
+	}
+	var fields []string
+	for _, c := range r.Columns {
+		if c.GlobalSearchable() && o.ColumnTypes[c.Data] == ColumnString {
+			if fields == nil {
+				fields = make([]string, 0, len(r.Columns))
 			}
-			column = append(column, m)
+			fields = append(fields, c.Data)
 		}
 	}
-	q := bson.M{"$or": global}
-	if len(column) > 0 {
-		columnfind := bson.M{"$and": column}
-		q = bson.M{"$and": []bson.M{q, columnfind}}
+	return fields
+}
+
+// combineFilter returns the filter matching any of the global and all of the
+// column conditions, leaving out the clauses that are not needed. The groups
+// are combined using the SearchCombine.
+func combineFilter(global, column []bson.M, mode SearchCombine) bson.M {
+	switch {
+	case len(global) == 0 && len(column) == 0:
+		return bson.M{}
+	case len(global) == 0 && len(column) == 1:
+		return column[0]
+	case len(global) == 0:
+		return bson.M{"$and": column}
+	case len(column) == 0:
+		return bson.M{"$or": global}
+	case mode == SearchCombineOr && len(column) == 1:
+		return bson.M{"$or": append(global, column[0])}
+	case mode == SearchCombineOr:
+		return bson.M{"$or": append(global, bson.M{"$and": column})}
 	}
-	return q
+	return bson.M{"$and": []bson.M{
+		{"$or": global},
+		{"$and": column},
+	}}
+}
+
+// fixedFilters returns the filters of the fixed searches. Global fixed terms
+// match any of the searchable columns, column fixed terms match the column.
+// The terms are searched for literally.
+func (o FilterOptions) fixedFilters(r types.Request) []bson.M {
+	var filters []bson.M
+	for _, f := range r.Search.Fixed {
+		if f.Term == "" {
+			continue
+		}
+		var global []bson.M
+		for _, field := range o.globalFields(r) {
+			global = append(global, o.match(field, o.regEx(field,
+				types.Search{Value: f.Term})))
+		}
+		if len(global) > 0 {
+			filters = append(filters, bson.M{"$or": global})
+		}
+	}
+	for _, c := range r.Columns {
+		if !c.Searchable {
+			continue
+		}
+		for _, f := range c.Search.Fixed {
+			if f.Term == "" {
+				continue
+			}
+			c.Search = types.Search{Value: f.Term}
+			if m, ok := o.columnFilter(c); ok {
+				filters = append(filters, m)
+			}
+		}
+	}
+	return filters
+}
+
+// columnFilter returns the filter for the column specific search. It returns
+// false if the search value can't be used for the column.
+func (o FilterOptions) columnFilter(c types.Column) (bson.M, bool) {
+	if _, ok := o.DateRangeColumns[c.Data]; ok {
+		return o.dateRangeFilter(c.Data, c.Search.Value)
+	}
+	if o.ExactColumns[c.Data] {
+		return bson.M{c.Data: bson.M{"$eq": c.Search.Value}}, true
+	}
+	switch o.ColumnTypes[c.Data] {
+	case ColumnBool:
+		b, ok := parseBool(c.Search.Value)
+		if !ok {
+			return nil, false
+		}
+		return bson.M{c.Data: bson.M{"$eq": b}}, true
+	case ColumnIDList:
+		ids := idList(c.Search.Value)
+		if len(ids) == 0 {
+			return nil, false
+		}
+		return bson.M{c.Data: bson.M{"$in": ids}}, true
+	default:
+		if tooShort(c.Search.Value, o.MinColumnSearchLength) {
+			return nil, false
+		}
+		return o.match(c.Data, o.regEx(c.Data, c.Search)), true
+	}
+}
+
+// match returns the filter matching the field with the RegEx, using `$expr`
+// for virtual fields.
+func (o FilterOptions) match(field string, re bson.RegEx) bson.M {
+	for _, v := range o.VirtualFields {
+		if v.Name == field {
+			return v.filter(re)
+		}
+	}
+	return bson.M{field: re}
+}
+
+// tooShort reports if the search value is shorter than the minimum length,
+// a minimum of 0 or less disables the check.
+func tooShort(v string, n int) bool {
+	return n > 0 && utf8.RuneCountInString(v) < n
+}
+
+// idList parses the comma-separated IDs, ignoring empty elements.
+func idList(v string) []interface{} {
+	var ids []interface{}
+	for _, id := range strings.Split(v, ",") {
+		if id = strings.TrimSpace(id); id != "" {
+			ids = append(ids, keysetValue(id))
+		}
+	}
+	return ids
+}
+
+// parseBool parses the common truthy and falsy values of checkboxes and
+// boolean selects. It returns false if the value is not recognized.
+func parseBool(v string) (b bool, ok bool) {
+	switch strings.ToLower(strings.TrimSpace(v)) {
+	case "on", "true", "1", "yes", "checked":
+		return true, true
+	case "off", "false", "0", "no", "":
+		return false, true
+	}
+	return false, false
+}
+
+// regEx returns the RegEx to search the field with. Regex searches that do
+// not compile are searched for literally, so MongoDB does not reject the
+// query while the user is still typing the expression.
+func (o FilterOptions) regEx(field string, s types.Search) bson.RegEx {
+	if o.PrefixColumns[field] {
+		return bson.RegEx{Pattern: "^" + regexp.QuoteMeta(s.Value)}
+	}
+	if s.Regex {
+		if _, err := regexp.Compile(s.Value); err != nil {
+			s.Regex = false
+		}
+	}
+	re := bson.RegEx{
+		Pattern: s.Value,
+		Options: "i",
+	}
+	if !s.Regex {
+		re.Pattern = regexp.QuoteMeta(s.Value)
+	}
+	if o.CaseSensitiveColumns[field] {
+		re.Options = ""
+	} else if o.FoldCase && !s.Regex {
+		re.Pattern = FoldPattern(s.Value)
+		re.Options = ""
+	}
+	if o.AnchoredColumns[field] && !strings.HasPrefix(re.Pattern, "^") {
+		if s.Regex {
+			// Group the pattern so alternations are anchored as well.
+			re.Pattern = "^(?:" + re.Pattern + ")"
+		} else {
+			re.Pattern = "^" + re.Pattern
+		}
+	}
+	return re
+}
+
+// ModifiedSinceFilter restricts the filter to documents where field is
+// after the given time.
+func ModifiedSinceFilter(f bson.M, field string, since time.Time) bson.M {
+	return bson.M{"$and": []bson.M{f, {field: bson.M{"$gt": since}}}}
 }
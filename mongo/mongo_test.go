@@ -1,14 +1,20 @@
 package mongo
 
 import (
+	"context"
+	"encoding/base64"
 	"encoding/json"
 	"errors"
+	"io"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
 	"reflect"
+	"regexp"
 	"strconv"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/basvdlei/godatatables/types"
 
@@ -88,12 +94,6 @@ var RequestTests = []RequestTestCase{
 						Options: "i",
 					},
 				},
-				{
-					"bar": bson.RegEx{
-						Pattern: "test",
-						Options: "i",
-					},
-				},
 			},
 		},
 	},
@@ -201,24 +201,85 @@ var RequestTests = []RequestTestCase{
 
 type QueryMock struct {
 	Result      []map[string]string
+	CountValue  int
 	CountCalled bool
+	// CountLimit is the limit that was set when Count was called.
+	CountLimit  int
+	AllCalled   bool
+	LimitCalled bool
 	LimitValue  int
 	SkipValue   int
 	SortValue   []string
+	HintValue   []string
+	BatchValue  int
+	DistinctKey string
+	// IterErr is returned by the Close of the iterator.
+	IterErr error
+	// Delay is slept by All to simulate slow queries.
+	Delay time.Duration
+	// Docs are returned after the Result, for documents with non string
+	// fields.
+	Docs []bson.M
+}
+
+// documents converts the string results to documents.
+func documents(result []map[string]string) []bson.M {
+	docs := make([]bson.M, len(result))
+	for i, r := range result {
+		docs[i] = make(bson.M, len(r))
+		for k, v := range r {
+			docs[i][k] = v
+		}
+	}
+	return docs
 }
 
 func (q *QueryMock) All(result interface{}) error {
-	if v, ok := result.(*[]map[string]string); ok {
-		*v = append(*v, q.Result...)
+	q.AllCalled = true
+	time.Sleep(q.Delay)
+	if v, ok := result.(*[]bson.M); ok {
+		*v = append(*v, documents(q.Result)...)
+		*v = append(*v, q.Docs...)
 		return nil
 	}
 	return errors.New("unknown type")
 }
+func (q *QueryMock) Batch(n int) Query {
+	q.BatchValue = n
+	return q
+}
 func (q *QueryMock) Count() (n int, err error) {
 	q.CountCalled = true
-	return
+	q.CountLimit = q.LimitValue
+	if q.LimitValue > 0 && q.CountValue > q.LimitValue {
+		return q.LimitValue, nil
+	}
+	return q.CountValue, nil
+}
+func (q *QueryMock) Distinct(key string, result interface{}) error {
+	q.DistinctKey = key
+	v, ok := result.(*[]interface{})
+	if !ok {
+		return errors.New("unknown type")
+	}
+	seen := make(map[string]bool)
+	for _, r := range q.Result {
+		if s, ok := r[key]; ok && !seen[s] {
+			seen[s] = true
+			*v = append(*v, s)
+		}
+	}
+	return nil
+}
+func (q *QueryMock) Hint(indexKey ...string) Query {
+	q.HintValue = indexKey
+	return q
+}
+func (q *QueryMock) Iter() Iter {
+	return &IterMock{Result: q.Result, Err: q.IterErr}
 }
 func (q *QueryMock) Limit(n int) Query {
+	q.LimitCalled = true
 	q.LimitValue = n
 	return q
 }
@@ -231,19 +292,63 @@ func (q *QueryMock) Sort(fields ...string) Query {
 	return q
 }
 
+type IterMock struct {
+	Result []map[string]string
+	// Err is returned by Close after the Result has been iterated.
+	Err    error
+	Closed bool
+	next   int
+}
+
+func (i *IterMock) Next(result interface{}) bool {
+	v, ok := result.(*bson.M)
+	if !ok || i.next >= len(i.Result) {
+		return false
+	}
+	*v = make(bson.M, len(i.Result[i.next]))
+	for k, s := range i.Result[i.next] {
+		(*v)[k] = s
+	}
+	i.next++
+	return true
+}
+func (i *IterMock) Close() error {
+	i.Closed = true
+	return i.Err
+}
+
 type CollectionMock struct {
-	count int
-	err   error
-	query *QueryMock
+	count       int
+	countCalled int
+	err         error
+	query       *QueryMock
+	filter      interface{}
+	// filters are the filters of all Find calls, the first one is the
+	// filter of the records.
+	filters []interface{}
 }
 
 func (c *CollectionMock) Count() (n int, err error) {
+	c.countCalled++
 	return c.count, c.err
 }
 func (c *CollectionMock) Find(query interface{}) Query {
+	c.filter = query
+	c.filters = append(c.filters, query)
 	return c.query
 }
 
+type ModeCollectionMock struct {
+	*CollectionMock
+	mode   *mgo.Mode
+	closed bool
+}
+
+func (c *ModeCollectionMock) WithMode(mode mgo.Mode) (Collection, func()) {
+	c.mode = &mode
+	return c.CollectionMock, func() { c.closed = true }
+}
+
 func TestCollectionHandlerServeHTTP(t *testing.T) {
 	for i, c := range RequestTests {
 		var totalRecords = 100
@@ -295,73 +400,3189 @@ func TestCollectionHandlerServeHTTP(t *testing.T) {
 	}
 }
 
-func TestResponseData(t *testing.T) {
-	for i, c := range RequestTests {
-		q := &QueryMock{
-			Result: c.Result,
+func TestCollectionHandlerDebug(t *testing.T) {
+	for _, debug := range []bool{false, true} {
+		ch := &CollectionHandler{
+			Collection: &CollectionMock{
+				query: &QueryMock{},
+			},
+			Debug: debug,
 		}
-		data, err := ResponseData(q)
-		if err != nil {
-			t.Errorf("case %d: error %v", i, err)
+		req := &http.Request{
+			Method: "GET",
+			URL:    &url.URL{Path: "/"},
+			Form: url.Values{
+				"draw":                   []string{"1"},
+				"search[value]":          []string{"foo"},
+				"columns[0][data]":       []string{"name"},
+				"columns[0][searchable]": []string{"true"},
+				"order[0][column]":       []string{"0"},
+				"order[0][dir]":          []string{"desc"},
+			},
 		}
-		if !reflect.DeepEqual(data, c.ResponseData) {
-			t.Errorf("case %d: data does not match, want %+v, got %+v",
-				i, c.ResponseData, data)
+		w := httptest.NewRecorder()
+		ch.ServeHTTP(w, req)
+		var raw map[string]json.RawMessage
+		if err := json.Unmarshal(w.Body.Bytes(), &raw); err != nil {
+			t.Fatalf("debug %v: could not unmarshal response: %v", debug, err)
+		}
+		d, ok := raw["_debug"]
+		if ok != debug {
+			t.Fatalf("debug %v: unexpected debug field presence: %s",
+				debug, w.Body.String())
+		}
+		if !debug {
+			continue
+		}
+		var info struct {
+			Filter map[string][]map[string]bson.RegEx `json:"filter"`
+			Sort   []string                           `json:"sort"`
+		}
+		if err := json.Unmarshal(d, &info); err != nil {
+			t.Fatalf("debug %v: could not unmarshal debug info: %v", debug, err)
+		}
+		wantFilter := map[string][]map[string]bson.RegEx{
+			"$or": {{"name": {Pattern: "foo", Options: "i"}}},
+		}
+		if !reflect.DeepEqual(info.Filter, wantFilter) {
+			t.Errorf("debug %v: filter does not match, want %+v, got %+v",
+				debug, wantFilter, info.Filter)
+		}
+		if !reflect.DeepEqual(info.Sort, []string{"-name", "_id"}) {
+			t.Errorf("debug %v: sort does not match, want %v, got %v",
+				debug, []string{"-name", "_id"}, info.Sort)
 		}
 	}
 }
 
-func TestSortQuery(t *testing.T) {
-	for i, c := range RequestTests {
-		q := SortQuery(&QueryMock{}, c.Request)
-		if v, ok := q.(*QueryMock); ok {
-			if len(v.SortValue) != len(c.SortColumns) {
-				t.Errorf("case %d: sort columns count does not match, want %d, got %d",
-					i, len(c.SortColumns), len(v.SortValue))
+func TestCollectionHandlerStringCounters(t *testing.T) {
+	ch := &CollectionHandler{
+		Collection: &CollectionMock{
+			count: 100,
+			query: &QueryMock{CountValue: 2},
+		},
+		StringCounters: true,
+	}
+	req := &http.Request{
+		Method: "GET",
+		URL:    &url.URL{Path: "/"},
+		Form: url.Values{
+			"draw": []string{"5"},
+		},
+	}
+	w := httptest.NewRecorder()
+	ch.ServeHTTP(w, req)
+	want := `{"draw":"5","recordsTotal":"100","recordsFiltered":"2","data":[]}` + "\n"
+	if w.Body.String() != want {
+		t.Errorf("unexpected body, want %s, got %s", want, w.Body.String())
+	}
+}
+
+func TestCollectionHandlerLegacy(t *testing.T) {
+	query := &QueryMock{
+		Result:     RequestTests[0].Result,
+		CountValue: 2,
+	}
+	collection := &CollectionMock{count: 100, query: query}
+	ch := &CollectionHandler{
+		Collection: collection,
+		Legacy:     true,
+	}
+	req := httptest.NewRequest("GET", "/?sEcho=4&iColumns=1&mDataProp_0=name"+
+		"&bSearchable_0=true&sSearch=foo&iDisplayStart=10&iDisplayLength=5"+
+		"&iSortingCols=1&iSortCol_0=0&sSortDir_0=desc", nil)
+	w := httptest.NewRecorder()
+	ch.ServeHTTP(w, req)
+	var dtResponse types.LegacyResponse
+	if err := json.NewDecoder(w.Body).Decode(&dtResponse); err != nil {
+		t.Fatalf("could not unmarshal response: %v", err)
+	}
+	want := types.LegacyResponse{
+		Draw:            4,
+		RecordsTotal:    100,
+		RecordsFiltered: 2,
+		Data:            RequestTests[0].ResponseData,
+	}
+	if !reflect.DeepEqual(dtResponse, want) {
+		t.Errorf("response does not match, want %+v, got %+v", want, dtResponse)
+	}
+	wantFilter := bson.M{"$or": []bson.M{
+		{"name": bson.RegEx{Pattern: "foo", Options: "i"}},
+	}}
+	if !reflect.DeepEqual(collection.filter, wantFilter) {
+		t.Errorf("filter does not match, want %+v, got %+v",
+			wantFilter, collection.filter)
+	}
+	if query.SkipValue != 10 || query.LimitValue != 5 ||
+		!reflect.DeepEqual(query.SortValue, []string{"-name", "_id"}) {
+		t.Errorf("unexpected paging skip=%d limit=%d sort=%v",
+			query.SkipValue, query.LimitValue, query.SortValue)
+	}
+}
+
+func TestCollectionHandlerBase64(t *testing.T) {
+	query := &QueryMock{}
+	collection := &CollectionMock{query: query}
+	ch := &CollectionHandler{Collection: collection}
+	blob := base64.StdEncoding.EncodeToString([]byte(`{"draw":6,` +
+		`"start":10,"length":5,"search":{"value":"foo"},` +
+		`"order":[{"column":0,"dir":"desc"}],` +
+		`"columns":[{"data":"name","searchable":true,"orderable":true}]}`))
+	// The bracketed parameters are ignored when the blob is present.
+	req := httptest.NewRequest("GET", "/?draw=1&dt="+url.QueryEscape(blob), nil)
+	w := httptest.NewRecorder()
+	ch.ServeHTTP(w, req)
+	var dtResponse types.Response
+	if err := json.NewDecoder(w.Body).Decode(&dtResponse); err != nil {
+		t.Fatalf("could not unmarshal response: %v", err)
+	}
+	if dtResponse.Draw != 6 || dtResponse.Error != "" {
+		t.Errorf("unexpected response draw=%d error=%q",
+			dtResponse.Draw, dtResponse.Error)
+	}
+	wantFilter := bson.M{"$or": []bson.M{
+		{"name": bson.RegEx{Pattern: "foo", Options: "i"}},
+	}}
+	if !reflect.DeepEqual(collection.filter, wantFilter) {
+		t.Errorf("filter does not match, want %+v, got %+v",
+			wantFilter, collection.filter)
+	}
+	if query.SkipValue != 10 || query.LimitValue != 5 ||
+		!reflect.DeepEqual(query.SortValue, []string{"-name", "_id"}) {
+		t.Errorf("unexpected paging skip=%d limit=%d sort=%v",
+			query.SkipValue, query.LimitValue, query.SortValue)
+	}
+}
+
+func TestCollectionHandlerPostForm(t *testing.T) {
+	form := url.Values{"draw": []string{"6"}}
+	for i := 0; i < 200; i++ {
+		p := "columns[" + strconv.Itoa(i) + "]"
+		form.Set(p+"[data]", "field"+strconv.Itoa(i))
+		form.Set(p+"[searchable]", "true")
+		form.Set(p+"[orderable]", "true")
+		form.Set(p+"[search][value]", "")
+		form.Set(p+"[search][regex]", "false")
+	}
+	form.Set("columns[199][search][value]", "foo")
+	body := form.Encode()
+	cases := []struct {
+		Name        string
+		MaxFormSize int64
+		Error       bool
+	}{
+		{Name: "default"},
+		{Name: "within", MaxFormSize: int64(len(body))},
+		{Name: "exceeded", MaxFormSize: int64(len(body)) - 1, Error: true},
+	}
+	for _, c := range cases {
+		collection := &CollectionMock{query: &QueryMock{}}
+		ch := &CollectionHandler{
+			Collection:  collection,
+			MaxFormSize: c.MaxFormSize,
+		}
+		req := httptest.NewRequest("POST", "/", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+		ch.ServeHTTP(w, req)
+		var dtResponse types.Response
+		if err := json.NewDecoder(w.Body).Decode(&dtResponse); err != nil {
+			t.Fatalf("case %s: could not unmarshal response: %v", c.Name, err)
+		}
+		if c.Error {
+			want := "request body exceeds the limit of " +
+				strconv.Itoa(len(body)-1) + " bytes"
+			if dtResponse.Error != want {
+				t.Errorf("case %s: unexpected error, want %q, got %q",
+					c.Name, want, dtResponse.Error)
 			}
-			for i, s := range c.SortColumns {
-				if v.SortValue[i] != s {
-					t.Errorf("case %d: sortcolumn does not match, want %s, got %s",
-						i, v.SortValue[i], s)
-				}
+			continue
+		}
+		if dtResponse.Draw != 6 || dtResponse.Error != "" {
+			t.Errorf("case %s: unexpected response %+v", c.Name, dtResponse)
+		}
+		want := bson.M{"field199": bson.RegEx{Pattern: "foo", Options: "i"}}
+		if !reflect.DeepEqual(collection.filter, want) {
+			t.Errorf("case %s: filter does not match, want %+v, got %+v",
+				c.Name, want, collection.filter)
+		}
+	}
+}
+
+func TestCollectionHandlerResponsePath(t *testing.T) {
+	cases := []struct {
+		Path string
+		Want string
+	}{
+		{
+			Path: "",
+			Want: `{"draw":5,"recordsTotal":100,"recordsFiltered":2,"data":[]}` + "\n",
+		},
+		{
+			Path: "result",
+			Want: `{"result":{"draw":5,"recordsTotal":100,"recordsFiltered":2,"data":[]}}` + "\n",
+		},
+		{
+			Path: "result.table",
+			Want: `{"result":{"table":{"draw":5,"recordsTotal":100,"recordsFiltered":2,"data":[]}}}` + "\n",
+		},
+	}
+	for _, c := range cases {
+		ch := &CollectionHandler{
+			Collection: &CollectionMock{
+				count: 100,
+				query: &QueryMock{CountValue: 2},
+			},
+			ResponsePath: c.Path,
+		}
+		req := &http.Request{
+			Method: "GET",
+			URL:    &url.URL{Path: "/"},
+			Form: url.Values{
+				"draw": []string{"5"},
+			},
+		}
+		w := httptest.NewRecorder()
+		ch.ServeHTTP(w, req)
+		if w.Body.String() != c.Want {
+			t.Errorf("case %q: unexpected body, want %s, got %s",
+				c.Path, c.Want, w.Body.String())
+		}
+	}
+}
+
+func TestCollectionHandlerEchoSearch(t *testing.T) {
+	for _, echo := range []bool{false, true} {
+		ch := &CollectionHandler{
+			Collection: &CollectionMock{
+				query: &QueryMock{},
+			},
+			EchoSearch: echo,
+		}
+		req := &http.Request{
+			Method: "GET",
+			URL:    &url.URL{Path: "/"},
+			Form: url.Values{
+				"draw":                      []string{"1"},
+				"search[value]":             []string{"foo"},
+				"columns[0][data]":          []string{"name"},
+				"columns[0][searchable]":    []string{"true"},
+				"columns[0][search][value]": []string{"^b"},
+				"columns[0][search][regex]": []string{"true"},
+				"columns[1][data]":          []string{"city"},
+			},
+		}
+		w := httptest.NewRecorder()
+		ch.ServeHTTP(w, req)
+		var dtResponse types.Response
+		if err := json.NewDecoder(w.Result().Body).Decode(&dtResponse); err != nil {
+			t.Fatalf("echo %v: could not unmarshal response: %v", echo, err)
+		}
+		var want *types.AppliedSearch
+		if echo {
+			want = &types.AppliedSearch{
+				Global: types.Search{Value: "foo"},
+				Columns: map[string]types.Search{
+					"name": {Value: "^b", Regex: true},
+				},
 			}
-		} else {
-			t.Errorf("bad query type")
+		}
+		if !reflect.DeepEqual(dtResponse.Search, want) {
+			t.Errorf("echo %v: search does not match, want %+v, got %+v",
+				echo, want, dtResponse.Search)
 		}
 	}
 }
 
-func TestRangeQuery(t *testing.T) {
-	for i, c := range RequestTests {
-		q := RangeQuery(&QueryMock{}, c.Request)
-		if v, ok := q.(*QueryMock); ok {
-			if v.LimitValue != c.Request.Length {
-				t.Errorf("case %d: limit does not match, want %d, got %d",
-					i, c.Request.Length, v.LimitValue)
+func TestFilterOptionsAppliedSearch(t *testing.T) {
+	visible := false
+	cases := []struct {
+		Name    string
+		Options FilterOptions
+		Request types.Request
+		Want    *types.AppliedSearch
+	}{
+		{
+			Name: "applied",
+			Request: types.Request{
+				Search: types.Search{Value: "foo"},
+				Columns: []types.Column{
+					{Data: "a", Searchable: true, Search: types.Search{Value: "bar"}},
+				},
+			},
+			Want: &types.AppliedSearch{
+				Global:  types.Search{Value: "foo"},
+				Columns: map[string]types.Search{"a": {Value: "bar"}},
+			},
+		},
+		{
+			Name: "not-searchable",
+			Request: types.Request{
+				Search: types.Search{Value: "foo"},
+				Columns: []types.Column{
+					{Data: "a", Searchable: true},
+					{Data: "b", Search: types.Search{Value: "ignored"}},
+				},
+			},
+			Want: &types.AppliedSearch{Global: types.Search{Value: "foo"}},
+		},
+		{
+			Name: "not-global-searchable",
+			Request: types.Request{
+				Search: types.Search{Value: "foo"},
+				Columns: []types.Column{
+					{Data: "a", Searchable: true, Visible: &visible},
+				},
+			},
+			Want: &types.AppliedSearch{},
+		},
+		{
+			Name:    "too-short",
+			Options: FilterOptions{MinSearchLength: 3, MinColumnSearchLength: 3},
+			Request: types.Request{
+				Search: types.Search{Value: "fo"},
+				Columns: []types.Column{
+					{Data: "a", Searchable: true, Search: types.Search{Value: "ba"}},
+					{Data: "b", Searchable: true, Search: types.Search{Value: "baz"}},
+				},
+			},
+			Want: &types.AppliedSearch{
+				Columns: map[string]types.Search{"b": {Value: "baz"}},
+			},
+		},
+		{
+			Name:    "truncated",
+			Options: FilterOptions{MaxSearchLength: 3},
+			Request: types.Request{
+				Search: types.Search{Value: "abcdefgh"},
+				Columns: []types.Column{
+					{Data: "a", Searchable: true, Search: types.Search{Value: "bazqux"}},
+				},
+			},
+			Want: &types.AppliedSearch{
+				Global:  types.Search{Value: "abc"},
+				Columns: map[string]types.Search{"a": {Value: "baz"}},
+			},
+		},
+	}
+	for _, c := range cases {
+		s := c.Options.AppliedSearch(c.Request)
+		if !reflect.DeepEqual(s, c.Want) {
+			t.Errorf("case %s: search does not match, want %+v, got %+v",
+				c.Name, c.Want, s)
+		}
+	}
+}
+
+func TestCollectionHandlerEchoColumns(t *testing.T) {
+	for _, echo := range []bool{false, true} {
+		ch := &CollectionHandler{
+			Collection: &CollectionMock{
+				query: &QueryMock{},
+			},
+			EchoColumns: echo,
+			FieldOrder:  []string{"name", "city"},
+		}
+		req := &http.Request{
+			Method: "GET",
+			URL:    &url.URL{Path: "/"},
+			Form: url.Values{
+				"draw":                   []string{"1"},
+				"columns[0][data]":       []string{"0"},
+				"columns[0][name]":       []string{"Name"},
+				"columns[0][searchable]": []string{"true"},
+				"columns[0][orderable]":  []string{"true"},
+				"columns[1][data]":       []string{"1"},
+			},
+		}
+		w := httptest.NewRecorder()
+		ch.ServeHTTP(w, req)
+		var raw map[string]json.RawMessage
+		if err := json.Unmarshal(w.Body.Bytes(), &raw); err != nil {
+			t.Fatalf("echo %v: could not unmarshal response: %v", echo, err)
+		}
+		if _, ok := raw["columns"]; ok != echo {
+			t.Errorf("echo %v: unexpected columns field presence %v", echo, ok)
+		}
+		var dtResponse types.Response
+		if err := json.Unmarshal(w.Body.Bytes(), &dtResponse); err != nil {
+			t.Fatalf("echo %v: could not unmarshal response: %v", echo, err)
+		}
+		var want []types.AppliedColumn
+		if echo {
+			want = []types.AppliedColumn{
+				{Data: "name", Name: "Name", Searchable: true, Orderable: true},
+				{Data: "city"},
 			}
-			if v.SkipValue != c.Request.Start {
-				t.Errorf("case %d: skip does not match, want %d, got %d",
-					i, c.Request.Start, v.SkipValue)
+		}
+		if !reflect.DeepEqual(dtResponse.Columns, want) {
+			t.Errorf("echo %v: columns do not match, want %+v, got %+v",
+				echo, want, dtResponse.Columns)
+		}
+	}
+}
+
+func TestCollectionHandlerServerTime(t *testing.T) {
+	for _, enabled := range []bool{false, true} {
+		ch := &CollectionHandler{
+			Collection: &CollectionMock{
+				query: &QueryMock{Delay: 5 * time.Millisecond},
+			},
+			ServerTime: enabled,
+		}
+		req := &http.Request{
+			Method: "GET",
+			URL:    &url.URL{Path: "/"},
+			Form: url.Values{
+				"draw": []string{"1"},
+			},
+		}
+		w := httptest.NewRecorder()
+		ch.ServeHTTP(w, req)
+		var raw map[string]interface{}
+		if err := json.NewDecoder(w.Body).Decode(&raw); err != nil {
+			t.Fatalf("enabled %v: could not unmarshal response: %v", enabled, err)
+		}
+		v, ok := raw["_serverTime"]
+		if ok != enabled {
+			t.Fatalf("enabled %v: unexpected _serverTime presence %v", enabled, ok)
+		}
+		if !enabled {
+			continue
+		}
+		if ms, _ := v.(float64); ms < 5 || ms > 60000 {
+			t.Errorf("enabled %v: implausible server time %v", enabled, v)
+		}
+	}
+}
+
+func TestCollectionHandlerEchoSort(t *testing.T) {
+	for _, echo := range []bool{false, true} {
+		query := &QueryMock{}
+		ch := &CollectionHandler{
+			Collection: &CollectionMock{query: query},
+			EchoSort:   echo,
+		}
+		req := &http.Request{
+			Method: "GET",
+			URL:    &url.URL{Path: "/"},
+			Form: url.Values{
+				"draw":             []string{"1"},
+				"columns[0][data]": []string{"name"},
+				"columns[1][data]": []string{"created"},
+				"order[0][column]": []string{"1"},
+				"order[0][dir]":    []string{"desc"},
+				"order[1][column]": []string{"0"},
+				"order[1][dir]":    []string{"asc"},
+			},
+		}
+		w := httptest.NewRecorder()
+		ch.ServeHTTP(w, req)
+		var dtResponse types.Response
+		if err := json.NewDecoder(w.Result().Body).Decode(&dtResponse); err != nil {
+			t.Fatalf("echo %v: could not unmarshal response: %v", echo, err)
+		}
+		var want []types.AppliedOrder
+		if echo {
+			want = []types.AppliedOrder{
+				{Data: "created", Dir: types.OrderDescending},
+				{Data: "name", Dir: types.OrderAscending},
+				{Data: "_id", Dir: types.OrderAscending},
 			}
-		} else {
-			t.Errorf("bad query type")
+			if sort := AppliedSort(query.SortValue); !reflect.DeepEqual(sort, want) {
+				t.Errorf("echo %v: query sort does not match, want %+v, got %+v",
+					echo, want, sort)
+			}
+		}
+		if !reflect.DeepEqual(dtResponse.Sort, want) {
+			t.Errorf("echo %v: sort does not match, want %+v, got %+v",
+				echo, want, dtResponse.Sort)
 		}
 	}
 }
 
-func TestCreateFilter(t *testing.T) {
-	for i, c := range RequestTests {
-		f := CreateFilter(c.Request)
-		if !reflect.DeepEqual(f, c.Filter) {
-			t.Errorf("case %d: filter not match, want %+v, got %+v",
-				i, c.Filter, f)
+func TestCollectionHandlerHardRowCap(t *testing.T) {
+	cases := []struct {
+		Name      string
+		Length    string
+		Rows      int
+		Limit     int
+		Data      int
+		Truncated bool
+	}{
+		{Name: "all-exceeded", Length: "-1", Rows: 4, Limit: 4, Data: 3, Truncated: true},
+		{Name: "long-exceeded", Length: "10", Rows: 4, Limit: 4, Data: 3, Truncated: true},
+		{Name: "long-within", Length: "10", Rows: 3, Limit: 4, Data: 3},
+		{Name: "short", Length: "2", Rows: 2, Limit: 2, Data: 2},
+	}
+	for _, c := range cases {
+		// Rows is the number of records returned for the limit.
+		query := &QueryMock{}
+		for i := 0; i < c.Rows; i++ {
+			query.Result = append(query.Result, map[string]string{"n": strconv.Itoa(i)})
+		}
+		ch := &CollectionHandler{
+			Collection: &CollectionMock{query: query},
+			HardRowCap: 3,
+		}
+		req := &http.Request{
+			Method: "GET",
+			URL:    &url.URL{Path: "/"},
+			Form: url.Values{
+				"draw":   []string{"1"},
+				"length": []string{c.Length},
+			},
+		}
+		w := httptest.NewRecorder()
+		ch.ServeHTTP(w, req)
+		var dtResponse types.Response
+		if err := json.NewDecoder(w.Result().Body).Decode(&dtResponse); err != nil {
+			t.Fatalf("case %s: could not unmarshal response: %v", c.Name, err)
+		}
+		if query.LimitValue != c.Limit {
+			t.Errorf("case %s: limit does not match, want %d, got %d",
+				c.Name, c.Limit, query.LimitValue)
+		}
+		if len(dtResponse.Data) != c.Data || dtResponse.Truncated != c.Truncated {
+			t.Errorf("case %s: want %d rows truncated %v, got %d rows truncated %v",
+				c.Name, c.Data, c.Truncated, len(dtResponse.Data), dtResponse.Truncated)
 		}
 	}
 }
 
-func ExampleCollectionHandler() {
-	session, _ := mgo.Dial("mymongohost")
-	c := session.DB("mydb").C("mycollection")
+func TestCollectionHandlerBatchSize(t *testing.T) {
+	query := &QueryMock{Result: RequestTests[0].Result}
+	ch := &CollectionHandler{
+		Collection: &CollectionMock{query: query},
+		BatchSize:  1,
+	}
+	req := &http.Request{
+		Method: "GET",
+		URL:    &url.URL{Path: "/"},
+		Form: url.Values{
+			"draw": []string{"1"},
+		},
+	}
+	w := httptest.NewRecorder()
+	ch.ServeHTTP(w, req)
+	var dtResponse types.Response
+	if err := json.NewDecoder(w.Result().Body).Decode(&dtResponse); err != nil {
+		t.Fatalf("could not unmarshal response: %v", err)
+	}
+	if query.BatchValue != 1 {
+		t.Errorf("batch size does not match, want %d, got %d", 1, query.BatchValue)
+	}
+	if query.AllCalled {
+		t.Errorf("unexpected All for batched fetch")
+	}
+	if !reflect.DeepEqual(dtResponse.Data, RequestTests[0].ResponseData) {
+		t.Errorf("data does not match, want %+v, got %+v",
+			RequestTests[0].ResponseData, dtResponse.Data)
+	}
+}
+
+func TestCollectionHandlerPartialOnTimeout(t *testing.T) {
+	timeout := &mgo.QueryError{Code: 50, Message: "operation exceeded time limit"}
+	cases := []struct {
+		Name      string
+		Partial   bool
+		Err       error
+		Data      []types.Row
+		Error     string
+		Truncated bool
+	}{
+		{
+			Name:      "partial",
+			Partial:   true,
+			Err:       timeout,
+			Data:      RequestTests[0].ResponseData,
+			Truncated: true,
+		},
+		{
+			Name:  "error",
+			Err:   timeout,
+			Error: timeout.Error(),
+		},
+		{
+			Name:    "other-error",
+			Partial: true,
+			Err:     errors.New("connection reset"),
+			Error:   "connection reset",
+		},
+	}
+	for _, c := range cases {
+		ch := &CollectionHandler{
+			Collection: &CollectionMock{query: &QueryMock{
+				Result:  RequestTests[0].Result,
+				IterErr: c.Err,
+			}},
+			BatchSize:        1,
+			PartialOnTimeout: c.Partial,
+		}
+		w := httptest.NewRecorder()
+		ch.ServeHTTP(w, httptest.NewRequest("GET", "/?draw=1", nil))
+		var dtResponse types.Response
+		if err := json.NewDecoder(w.Body).Decode(&dtResponse); err != nil {
+			t.Fatalf("case %s: could not unmarshal response: %v", c.Name, err)
+		}
+		if dtResponse.Error != c.Error {
+			t.Errorf("case %s: unexpected error, want %q, got %q",
+				c.Name, c.Error, dtResponse.Error)
+		}
+		if !reflect.DeepEqual(dtResponse.Data, c.Data) {
+			t.Errorf("case %s: data does not match, want %+v, got %+v",
+				c.Name, c.Data, dtResponse.Data)
+		}
+		if dtResponse.Truncated != c.Truncated ||
+			(dtResponse.Warning != "") != c.Truncated {
+			t.Errorf("case %s: unexpected truncated %v warning %q",
+				c.Name, dtResponse.Truncated, dtResponse.Warning)
+		}
+	}
+}
+
+func TestIterDataDeadline(t *testing.T) {
+	ctx, cancel := context.WithDeadline(context.Background(), time.Now())
+	defer cancel()
+	iter := &IterMock{Result: RequestTests[0].Result}
+	data, err := iterData(ctx, iter, valueFormat{})
+	if !isTimeout(err) {
+		t.Errorf("want timeout error, got %v", err)
+	}
+	if len(data) != 1 || !iter.Closed {
+		t.Errorf("unexpected partial data %+v closed %v", data, iter.Closed)
+	}
+}
+
+func TestCollectionHandlerContext(t *testing.T) {
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	expired, cancel := context.WithDeadline(context.Background(), time.Now())
+	defer cancel()
+	cases := []struct {
+		Name  string
+		Ctx   context.Context
+		Data  []types.Row
+		Error string
+	}{
+		{
+			Name: "cancelled",
+			Ctx:  cancelled,
+			Data: []types.Row{},
+		},
+		{
+			Name:  "deadline",
+			Ctx:   expired,
+			Error: context.DeadlineExceeded.Error(),
+		},
+	}
+	for _, c := range cases {
+		ch := &CollectionHandler{
+			Collection: &CollectionMock{
+				query: &QueryMock{Result: RequestTests[0].Result},
+			},
+			BatchSize: 10,
+		}
+		req := httptest.NewRequest("GET", "/?draw=1", nil).WithContext(c.Ctx)
+		w := httptest.NewRecorder()
+		ch.ServeHTTP(w, req)
+		if w.Code != http.StatusOK {
+			t.Errorf("case %s: unexpected statuscode, want %d, got %d",
+				c.Name, http.StatusOK, w.Code)
+		}
+		var dtResponse types.Response
+		if err := json.NewDecoder(w.Body).Decode(&dtResponse); err != nil {
+			t.Fatalf("case %s: could not unmarshal response: %v", c.Name, err)
+		}
+		if dtResponse.Error != c.Error {
+			t.Errorf("case %s: unexpected error, want %q, got %q",
+				c.Name, c.Error, dtResponse.Error)
+		}
+		if !reflect.DeepEqual(dtResponse.Data, c.Data) {
+			t.Errorf("case %s: data does not match, want %+v, got %+v",
+				c.Name, c.Data, dtResponse.Data)
+		}
+	}
+}
+
+func TestResponseDataFromIterContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	iter := &IterMock{Result: RequestTests[0].Result}
+	data, err := ResponseDataFromIterContext(ctx, iter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(data, RequestTests[0].ResponseData) {
+		t.Errorf("data does not match, want %+v, got %+v",
+			RequestTests[0].ResponseData, data)
+	}
+	cancel()
+	iter = &IterMock{Result: RequestTests[0].Result}
+	data, err = ResponseDataFromIterContext(ctx, iter)
+	if err != context.Canceled || data != nil {
+		t.Errorf("unexpected result for cancelled context: %v %+v", err, data)
+	}
+	if iter.next != 1 {
+		t.Errorf("iteration did not stop, got %d documents", iter.next)
+	}
+	if !iter.Closed {
+		t.Errorf("iterator not closed")
+	}
+}
+
+func TestResponseData(t *testing.T) {
+	for i, c := range RequestTests {
+		q := &QueryMock{
+			Result: c.Result,
+		}
+		data, err := ResponseData(q)
+		if err != nil {
+			t.Errorf("case %d: error %v", i, err)
+		}
+		if !reflect.DeepEqual(data, c.ResponseData) {
+			t.Errorf("case %d: data does not match, want %+v, got %+v",
+				i, c.ResponseData, data)
+		}
+	}
+}
+
+func TestResponseDataMixedTypes(t *testing.T) {
+	id := bson.ObjectIdHex("5a934e000102030405000000")
+	created := time.Date(2018, 2, 25, 23, 0, 0, 0, time.UTC)
+	q := &QueryMock{
+		Docs: []bson.M{
+			{
+				"_id":     id,
+				"name":    "foo",
+				"avatar":  bson.Binary{Kind: 0x00, Data: []byte("img")},
+				"raw":     []byte("raw"),
+				"age":     42,
+				"active":  true,
+				"created": created,
+				"deleted": nil,
+			},
+		},
+	}
+	data, err := ResponseData(q)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []types.Row{
+		{
+			Data: map[string]string{
+				"_id":     "5a934e000102030405000000",
+				"name":    "foo",
+				"avatar":  "aW1n",
+				"raw":     "cmF3",
+				"age":     "42",
+				"active":  "true",
+				"created": "2018-02-25T23:00:00Z",
+				"deleted": "",
+			},
+		},
+	}
+	if !reflect.DeepEqual(data, want) {
+		t.Errorf("data does not match, want %+v, got %+v", want, data)
+	}
+}
+
+func TestCollectionHandlerValueFormat(t *testing.T) {
+	id := bson.ObjectIdHex("5a934e000102030405000000")
+	created := time.Date(2018, 2, 25, 23, 0, 0, 500, time.UTC)
+	cases := []struct {
+		Name       string
+		IDFormat   func(id bson.ObjectId) string
+		DateFormat string
+		ID         string
+		Created    string
+	}{
+		{
+			Name:    "default",
+			ID:      "5a934e000102030405000000",
+			Created: "2018-02-25T23:00:00.0000005Z",
+		},
+		{
+			Name:       "rfc3339",
+			DateFormat: time.RFC3339,
+			ID:         "5a934e000102030405000000",
+			Created:    "2018-02-25T23:00:00Z",
+		},
+		{
+			Name:       "custom",
+			IDFormat:   func(id bson.ObjectId) string { return "user-" + id.Hex()[:8] },
+			DateFormat: "2006-01-02",
+			ID:         "user-5a934e00",
+			Created:    "2018-02-25",
+		},
+	}
+	for _, c := range cases {
+		ch := &CollectionHandler{
+			Collection: &CollectionMock{query: &QueryMock{
+				Docs: []bson.M{{"_id": id, "created": created}},
+			}},
+			IDFormat:   c.IDFormat,
+			DateFormat: c.DateFormat,
+		}
+		w := httptest.NewRecorder()
+		ch.ServeHTTP(w, httptest.NewRequest("GET",
+			"/?draw=1&columns[0][data]=_id&columns[1][data]=created", nil))
+		var dtResponse types.Response
+		if err := json.NewDecoder(w.Body).Decode(&dtResponse); err != nil {
+			t.Fatalf("case %s: could not unmarshal response: %v", c.Name, err)
+		}
+		want := []types.Row{{Data: map[string]string{
+			"_id":     c.ID,
+			"created": c.Created,
+		}}}
+		if !reflect.DeepEqual(dtResponse.Data, want) {
+			t.Errorf("case %s: data does not match, want %+v, got %+v",
+				c.Name, want, dtResponse.Data)
+		}
+	}
+}
+
+func TestResponseDataFromIter(t *testing.T) {
+	for i, c := range RequestTests {
+		iter := &IterMock{
+			Result: c.Result,
+		}
+		data, err := ResponseDataFromIter(iter)
+		if err != nil {
+			t.Errorf("case %d: error %v", i, err)
+		}
+		if !reflect.DeepEqual(data, c.ResponseData) {
+			t.Errorf("case %d: data does not match, want %+v, got %+v",
+				i, c.ResponseData, data)
+		}
+		if !iter.Closed {
+			t.Errorf("case %d: iterator not closed", i)
+		}
+	}
+	iter := &IterMock{
+		Result: RequestTests[0].Result[:1],
+		Err:    errors.New("cursor not found"),
+	}
+	data, err := ResponseDataFromIter(iter)
+	if err == nil || err.Error() != "cursor not found" {
+		t.Errorf("unexpected error, want %v, got %v", iter.Err, err)
+	}
+	if data != nil {
+		t.Errorf("unexpected data on error: %+v", data)
+	}
+}
+
+func TestDetailData(t *testing.T) {
+	data := []types.Row{
+		{
+			Data: map[string]string{
+				"name":  "Foo",
+				"notes": "Likes cake",
+				"phone": "555-1234",
+			},
+		},
+		{
+			Data: map[string]string{
+				"name": "Bar",
+			},
+		},
+	}
+	want := []types.Row{
+		{
+			Data: map[string]string{
+				"name": "Foo",
+			},
+			RowData: map[string]string{
+				"notes": "Likes cake",
+				"phone": "555-1234",
+			},
+		},
+		{
+			Data: map[string]string{
+				"name": "Bar",
+			},
+		},
+	}
+	DetailData(data, "notes", "phone")
+	if !reflect.DeepEqual(data, want) {
+		t.Errorf("data does not match, want %+v, got %+v", want, data)
+	}
+}
+
+func TestSortQuery(t *testing.T) {
+	for i, c := range RequestTests {
+		q := SortQuery(&QueryMock{}, c.Request)
+		if v, ok := q.(*QueryMock); ok {
+			if len(v.SortValue) != len(c.SortColumns) {
+				t.Errorf("case %d: sort columns count does not match, want %d, got %d",
+					i, len(c.SortColumns), len(v.SortValue))
+			}
+			for i, s := range c.SortColumns {
+				if v.SortValue[i] != s {
+					t.Errorf("case %d: sortcolumn does not match, want %s, got %s",
+						i, v.SortValue[i], s)
+				}
+			}
+		} else {
+			t.Errorf("bad query type")
+		}
+	}
+}
+
+func TestSortFieldsOrderName(t *testing.T) {
+	r := types.Request{
+		Order: []types.Order{
+			{Name: "last", Dir: types.OrderDescending},
+			{Name: "unknown", Dir: types.OrderAscending},
+			{Column: 0, Dir: types.OrderAscending},
+		},
+		Columns: []types.Column{
+			{Data: "first_name", Name: "first"},
+			{Data: "last_name", Name: "last"},
+		},
+	}
+	want := []string{"-last_name", "first_name"}
+	if sort := SortFields(r); !reflect.DeepEqual(sort, want) {
+		t.Errorf("sort fields do not match, want %v, got %v", want, sort)
+	}
+}
+
+func TestSortFieldsEmptyDir(t *testing.T) {
+	r, err := types.ParseURLValues(url.Values{
+		"columns[0][data]": []string{"first_name"},
+		"columns[1][data]": []string{"last_name"},
+		"order[0][column]": []string{"0"},
+		"order[0][dir]":    []string{""},
+		"order[1][column]": []string{"1"},
+		"order[1][dir]":    []string{"desc"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"-last_name"}
+	if sort := SortFields(r); !reflect.DeepEqual(sort, want) {
+		t.Errorf("sort fields do not match, want %v, got %v", want, sort)
+	}
+}
+
+func TestSortDocument(t *testing.T) {
+	r := types.Request{
+		Order: []types.Order{
+			{Column: 1, Dir: types.OrderDescending},
+			{Column: 0, Dir: types.OrderAscending},
+			{Column: 2, Dir: ""},
+			{Column: 1, Dir: types.OrderAscending},
+		},
+		Columns: []types.Column{
+			{Data: "first_name"},
+			{Data: "last_name"},
+			{Data: "age"},
+		},
+	}
+	want := bson.D{
+		{Name: "last_name", Value: -1},
+		{Name: "first_name", Value: 1},
+	}
+	if sort := SortDocument(r); !reflect.DeepEqual(sort, want) {
+		t.Errorf("sort document does not match, want %v, got %v", want, sort)
+	}
+	if sort := SortDocument(types.Request{}); len(sort) != 0 {
+		t.Errorf("expected empty sort document, got %v", sort)
+	}
+}
+
+func TestFilterOptionsSortFieldsTiebreaker(t *testing.T) {
+	r := types.Request{
+		Order: []types.Order{{Column: 0, Dir: types.OrderDescending}},
+		Columns: []types.Column{
+			{Data: "name", Orderable: true},
+			{Data: "_id", Orderable: true},
+		},
+	}
+	cases := []struct {
+		Name    string
+		Options FilterOptions
+		Request types.Request
+		Sort    []string
+	}{
+		{
+			Name:    "default",
+			Request: r,
+			Sort:    []string{"-name", "_id"},
+		},
+		{
+			Name:    "no-order",
+			Request: types.Request{Columns: r.Columns},
+			Sort:    []string{"_id"},
+		},
+		{
+			Name: "ordered-on-id",
+			Request: types.Request{
+				Order:   []types.Order{{Column: 1, Dir: types.OrderDescending}},
+				Columns: r.Columns,
+			},
+			Sort: []string{"-_id"},
+		},
+		{
+			Name:    "unstable",
+			Options: FilterOptions{UnstableSort: true},
+			Request: r,
+			Sort:    []string{"-name"},
+		},
+	}
+	for _, c := range cases {
+		sort := c.Options.SortFields(c.Request)
+		if !reflect.DeepEqual(sort, c.Sort) {
+			t.Errorf("case %s: sort does not match, want %v, got %v",
+				c.Name, c.Sort, sort)
+		}
+	}
+}
+
+func TestSortFieldsDuplicateColumns(t *testing.T) {
+	r := types.Request{
+		Order: []types.Order{
+			{Column: 0, Dir: types.OrderDescending},
+			{Column: 1, Dir: types.OrderAscending},
+			{Column: 0, Dir: types.OrderDescending},
+			{Column: 0, Dir: types.OrderAscending},
+		},
+		Columns: []types.Column{
+			{Data: "foo"},
+			{Data: "bar"},
+		},
+	}
+	want := []string{"-foo", "bar"}
+	q := SortQuery(&QueryMock{}, r).(*QueryMock)
+	if !reflect.DeepEqual(q.SortValue, want) {
+		t.Errorf("sort fields do not match, want %v, got %v", want, q.SortValue)
+	}
+	wantStage := bson.M{"$sort": bson.D{
+		{Name: "foo", Value: -1},
+		{Name: "bar", Value: 1},
+		{Name: "_id", Value: 1},
+	}}
+	if stage := SortStage(r); !reflect.DeepEqual(stage, wantStage) {
+		t.Errorf("sort stage does not match, want %v, got %v", wantStage, stage)
+	}
+}
+
+func TestRangeQuery(t *testing.T) {
+	for i, c := range RequestTests {
+		q := RangeQuery(&QueryMock{}, c.Request)
+		if v, ok := q.(*QueryMock); ok {
+			if v.LimitValue != c.Request.Length {
+				t.Errorf("case %d: limit does not match, want %d, got %d",
+					i, c.Request.Length, v.LimitValue)
+			}
+			if v.SkipValue != c.Request.Start {
+				t.Errorf("case %d: skip does not match, want %d, got %d",
+					i, c.Request.Start, v.SkipValue)
+			}
+		} else {
+			t.Errorf("bad query type")
+		}
+	}
+}
+
+func TestCollectionHandlerDefaultLength(t *testing.T) {
+	cases := []struct {
+		Name        string
+		Form        url.Values
+		LimitCalled bool
+		LimitValue  int
+	}{
+		{
+			Name:        "missing",
+			Form:        url.Values{"draw": []string{"1"}},
+			LimitCalled: true,
+			LimitValue:  25,
+		},
+		{
+			Name:        "explicit",
+			Form:        url.Values{"draw": []string{"1"}, "length": []string{"10"}},
+			LimitCalled: true,
+			LimitValue:  10,
+		},
+		{
+			Name:        "all",
+			Form:        url.Values{"draw": []string{"1"}, "length": []string{"-1"}},
+			LimitCalled: false,
+		},
+		{
+			Name:        "zero",
+			Form:        url.Values{"draw": []string{"1"}, "length": []string{"0"}},
+			LimitCalled: false,
+		},
+	}
+	for _, c := range cases {
+		query := &QueryMock{}
+		ch := &CollectionHandler{
+			Collection:    &CollectionMock{query: query},
+			DefaultLength: 25,
+		}
+		req := &http.Request{
+			Method: "GET",
+			URL:    &url.URL{Path: "/"},
+			Form:   c.Form,
+		}
+		ch.ServeHTTP(httptest.NewRecorder(), req)
+		if query.LimitCalled != c.LimitCalled {
+			t.Errorf("case %s: unexpected limit call, want %v, got %v",
+				c.Name, c.LimitCalled, query.LimitCalled)
+		}
+		if query.LimitValue != c.LimitValue {
+			t.Errorf("case %s: limit does not match, want %d, got %d",
+				c.Name, c.LimitValue, query.LimitValue)
+		}
+		if query.AllCalled != (c.Name != "zero") {
+			t.Errorf("case %s: unexpected data query %v", c.Name, query.AllCalled)
+		}
+	}
+}
+
+func TestCollectionHandlerMaxFilteredCount(t *testing.T) {
+	cases := []struct {
+		Name            string
+		Max             int
+		CountLimit      int
+		RecordsFiltered int
+	}{
+		{
+			Name:            "exact",
+			Max:             0,
+			CountLimit:      0,
+			RecordsFiltered: 5000,
+		},
+		{
+			Name:            "capped",
+			Max:             1000,
+			CountLimit:      1000,
+			RecordsFiltered: 1000,
+		},
+	}
+	for _, c := range cases {
+		query := &QueryMock{CountValue: 5000}
+		ch := &CollectionHandler{
+			Collection:       &CollectionMock{query: query},
+			MaxFilteredCount: c.Max,
+		}
+		req := &http.Request{
+			Method: "GET",
+			URL:    &url.URL{Path: "/"},
+			Form: url.Values{
+				"draw":   []string{"1"},
+				"length": []string{"-1"},
+			},
+		}
+		w := httptest.NewRecorder()
+		ch.ServeHTTP(w, req)
+		var dtResponse types.Response
+		if err := json.NewDecoder(w.Result().Body).Decode(&dtResponse); err != nil {
+			t.Fatalf("case %s: could not unmarshal response: %v", c.Name, err)
+		}
+		if query.CountLimit != c.CountLimit {
+			t.Errorf("case %s: count limit does not match, want %d, got %d",
+				c.Name, c.CountLimit, query.CountLimit)
+		}
+		if dtResponse.RecordsFiltered != c.RecordsFiltered {
+			t.Errorf("case %s: records filtered does not match, want %d, got %d",
+				c.Name, c.RecordsFiltered, dtResponse.RecordsFiltered)
+		}
+	}
+}
+
+func TestCollectionHandlerDeepPaging(t *testing.T) {
+	id := bson.NewObjectId()
+	cases := []struct {
+		Name       string
+		Form       url.Values
+		Filter     interface{}
+		SkipValue  int
+		SortValue  []string
+		DeepPaging bool
+	}{
+		{
+			Name: "below-threshold",
+			Form: url.Values{
+				"start":  []string{"100"},
+				"length": []string{"10"},
+				"after":  []string{id.Hex()},
+			},
+			Filter:    bson.M{},
+			SkipValue: 100,
+			SortValue: []string{"_id"},
+		},
+		{
+			Name: "keyset",
+			Form: url.Values{
+				"start":  []string{"50000"},
+				"length": []string{"10"},
+				"after":  []string{id.Hex()},
+			},
+			Filter: bson.M{"$and": []bson.M{
+				{},
+				{"_id": bson.M{"$gt": id}},
+			}},
+			SkipValue: 0,
+			SortValue: []string{"_id"},
+		},
+		{
+			Name: "no-token",
+			Form: url.Values{
+				"start":  []string{"50000"},
+				"length": []string{"10"},
+			},
+			Filter:     bson.M{},
+			SkipValue:  50000,
+			SortValue:  []string{"_id"},
+			DeepPaging: true,
+		},
+		{
+			Name: "other-order",
+			Form: url.Values{
+				"start":            []string{"50000"},
+				"length":           []string{"10"},
+				"after":            []string{id.Hex()},
+				"columns[0][data]": []string{"name"},
+				"order[0][column]": []string{"0"},
+				"order[0][dir]":    []string{"asc"},
+			},
+			Filter:     bson.M{},
+			SkipValue:  50000,
+			SortValue:  []string{"name", "_id"},
+			DeepPaging: true,
+		},
+	}
+	for _, c := range cases {
+		var deepPaging bool
+		query := &QueryMock{CountValue: 100000}
+		collection := &CollectionMock{query: query}
+		ch := &CollectionHandler{
+			Collection:          collection,
+			DeepPagingThreshold: 10000,
+			Observer: ObserverFunc(func(o Observation) {
+				deepPaging = o.DeepPaging
+			}),
+		}
+		req := &http.Request{
+			Method: "GET",
+			URL:    &url.URL{Path: "/"},
+			Form:   c.Form,
+		}
+		ch.ServeHTTP(httptest.NewRecorder(), req)
+		if !reflect.DeepEqual(collection.filter, c.Filter) {
+			t.Errorf("case %s: filter does not match, want %+v, got %+v",
+				c.Name, c.Filter, collection.filter)
+		}
+		if query.SkipValue != c.SkipValue {
+			t.Errorf("case %s: skip does not match, want %d, got %d",
+				c.Name, c.SkipValue, query.SkipValue)
+		}
+		if !reflect.DeepEqual(query.SortValue, c.SortValue) {
+			t.Errorf("case %s: sort does not match, want %v, got %v",
+				c.Name, c.SortValue, query.SortValue)
+		}
+		if deepPaging != c.DeepPaging {
+			t.Errorf("case %s: deep paging does not match, want %v, got %v",
+				c.Name, c.DeepPaging, deepPaging)
+		}
+	}
+}
+
+func TestCollectionHandlerTotalCountFunc(t *testing.T) {
+	collection := &CollectionMock{count: 100, query: &QueryMock{}}
+	calls := 0
+	ch := &CollectionHandler{
+		Collection: collection,
+		TotalCountFunc: func() (int, error) {
+			calls++
+			return 12345, nil
+		},
+	}
+	req := &http.Request{
+		Method: "GET",
+		URL:    &url.URL{Path: "/"},
+		Form: url.Values{
+			"draw": []string{"1"},
+		},
+	}
+	w := httptest.NewRecorder()
+	ch.ServeHTTP(w, req)
+	var dtResponse types.Response
+	if err := json.NewDecoder(w.Result().Body).Decode(&dtResponse); err != nil {
+		t.Fatalf("could not unmarshal response: %v", err)
+	}
+	if dtResponse.RecordsTotal != 12345 {
+		t.Errorf("records total does not match, want %d, got %d",
+			12345, dtResponse.RecordsTotal)
+	}
+	if calls != 1 {
+		t.Errorf("unexpected count func calls, want %d, got %d", 1, calls)
+	}
+	if collection.countCalled != 0 {
+		t.Errorf("unexpected collection counts, want %d, got %d",
+			0, collection.countCalled)
+	}
+}
+
+func TestCollectionHandlerCacheTotal(t *testing.T) {
+	collection := &CollectionMock{
+		count: 100,
+		query: &QueryMock{},
+	}
+	ch := &CollectionHandler{
+		Collection: collection,
+		CacheTotal: time.Minute,
+	}
+	cases := []struct {
+		Draw         string
+		Count        int
+		CountCalled  int
+		RecordsTotal int
+	}{
+		{Draw: "1", Count: 100, CountCalled: 1, RecordsTotal: 100},
+		{Draw: "2", Count: 101, CountCalled: 1, RecordsTotal: 100},
+		{Draw: "3", Count: 102, CountCalled: 1, RecordsTotal: 100},
+		{Draw: "1", Count: 103, CountCalled: 2, RecordsTotal: 103},
+		{Draw: "2", Count: 104, CountCalled: 2, RecordsTotal: 103},
+	}
+	for i, c := range cases {
+		collection.count = c.Count
+		req := &http.Request{
+			Method: "GET",
+			URL:    &url.URL{Path: "/"},
+			Form:   url.Values{"draw": []string{c.Draw}},
+		}
+		w := httptest.NewRecorder()
+		ch.ServeHTTP(w, req)
+		var dtResponse types.Response
+		if err := json.NewDecoder(w.Result().Body).Decode(&dtResponse); err != nil {
+			t.Fatalf("case %d: could not unmarshal response: %v", i, err)
+		}
+		if collection.countCalled != c.CountCalled {
+			t.Errorf("case %d: unexpected total counts, want %d, got %d",
+				i, c.CountCalled, collection.countCalled)
+		}
+		if dtResponse.RecordsTotal != c.RecordsTotal {
+			t.Errorf("case %d: records total does not match, want %d, got %d",
+				i, c.RecordsTotal, dtResponse.RecordsTotal)
+		}
+	}
+
+	ch.totals[""] = cachedTotal{
+		n:    ch.totals[""].n,
+		time: time.Now().Add(-2 * time.Minute),
+	}
+	collection.count = 105
+	req := &http.Request{
+		Method: "GET",
+		URL:    &url.URL{Path: "/"},
+		Form:   url.Values{"draw": []string{"6"}},
+	}
+	ch.ServeHTTP(httptest.NewRecorder(), req)
+	if collection.countCalled != 3 {
+		t.Errorf("expired cache: unexpected total counts, want %d, got %d",
+			3, collection.countCalled)
+	}
+}
+
+func TestCollectionHandlerStaleTotal(t *testing.T) {
+	cases := []struct {
+		Name            string
+		StaleTotal      StaleTotal
+		RecordsTotal    int
+		RecordsFiltered int
+		CountCalled     int
+	}{
+		{
+			Name:            "refresh",
+			StaleTotal:      StaleTotalRefresh,
+			RecordsTotal:    60,
+			RecordsFiltered: 50,
+			CountCalled:     2,
+		},
+		{
+			Name:            "clamp",
+			StaleTotal:      StaleTotalClamp,
+			RecordsTotal:    10,
+			RecordsFiltered: 10,
+			CountCalled:     1,
+		},
+		{
+			Name:            "ignore",
+			StaleTotal:      StaleTotalIgnore,
+			RecordsTotal:    10,
+			RecordsFiltered: 50,
+			CountCalled:     1,
+		},
+	}
+	for _, c := range cases {
+		query := &QueryMock{}
+		collection := &CollectionMock{count: 10, query: query}
+		ch := &CollectionHandler{
+			Collection: collection,
+			CacheTotal: time.Hour,
+			StaleTotal: c.StaleTotal,
+		}
+		// Cache the total of the first draw.
+		ch.ServeHTTP(httptest.NewRecorder(),
+			httptest.NewRequest("GET", "/?draw=1", nil))
+		// The collection grew, so the live filtered count exceeds the
+		// cached total.
+		collection.count = 60
+		query.CountValue = 50
+		w := httptest.NewRecorder()
+		ch.ServeHTTP(w, httptest.NewRequest("GET", "/?draw=2", nil))
+		var dtResponse types.Response
+		if err := json.NewDecoder(w.Body).Decode(&dtResponse); err != nil {
+			t.Fatalf("case %s: could not unmarshal response: %v", c.Name, err)
+		}
+		if dtResponse.RecordsTotal != c.RecordsTotal ||
+			dtResponse.RecordsFiltered != c.RecordsFiltered {
+			t.Errorf("case %s: unexpected counts, want total %d filtered %d, got %d %d",
+				c.Name, c.RecordsTotal, c.RecordsFiltered,
+				dtResponse.RecordsTotal, dtResponse.RecordsFiltered)
+		}
+		if collection.countCalled != c.CountCalled {
+			t.Errorf("case %s: unexpected total counts, want %d, got %d",
+				c.Name, c.CountCalled, collection.countCalled)
+		}
+	}
+}
+
+func TestCollectionHandlerSkipTotalCount(t *testing.T) {
+	cases := []struct {
+		Name             string
+		EstimateFiltered bool
+		Result           []map[string]string
+		RecordsFiltered  int
+	}{
+		{
+			Name:            "count",
+			RecordsFiltered: 42,
+		},
+		{
+			Name:             "estimate",
+			EstimateFiltered: true,
+			Result: []map[string]string{
+				{"name": "a"}, {"name": "b"}, {"name": "c"},
+			},
+			RecordsFiltered: 3,
+		},
+	}
+	for _, c := range cases {
+		collection := &CollectionMock{
+			count: 100,
+			query: &QueryMock{Result: c.Result, CountValue: 42},
+		}
+		ch := &CollectionHandler{
+			Collection:       collection,
+			SkipTotalCount:   true,
+			EstimateFiltered: c.EstimateFiltered,
+			CacheTotal:       time.Hour,
+		}
+		w := httptest.NewRecorder()
+		ch.ServeHTTP(w, httptest.NewRequest("GET",
+			"/?draw=1&length=10&columns[0][data]=name", nil))
+		var dtResponse types.Response
+		if err := json.NewDecoder(w.Body).Decode(&dtResponse); err != nil {
+			t.Fatalf("case %s: could not unmarshal response: %v", c.Name, err)
+		}
+		if collection.countCalled != 0 {
+			t.Errorf("case %s: total counted %d times", c.Name,
+				collection.countCalled)
+		}
+		if dtResponse.RecordsFiltered != c.RecordsFiltered ||
+			dtResponse.RecordsTotal != c.RecordsFiltered {
+			t.Errorf("case %s: unexpected counts, want %d, got total %d filtered %d",
+				c.Name, c.RecordsFiltered, dtResponse.RecordsTotal,
+				dtResponse.RecordsFiltered)
+		}
+	}
+}
+
+func TestCollectionHandlerReadMode(t *testing.T) {
+	secondary := mgo.SecondaryPreferred
+	for _, mode := range []*mgo.Mode{nil, &secondary} {
+		collection := &ModeCollectionMock{
+			CollectionMock: &CollectionMock{
+				count: 100,
+				query: &QueryMock{Result: RequestTests[0].Result},
+			},
+		}
+		ch := &CollectionHandler{
+			Collection: collection,
+			ReadMode:   mode,
+		}
+		req := &http.Request{
+			Method: "GET",
+			URL:    &url.URL{Path: "/"},
+			Form:   url.Values{"draw": []string{"1"}},
+		}
+		ch.ServeHTTP(httptest.NewRecorder(), req)
+		if !reflect.DeepEqual(collection.mode, mode) {
+			t.Errorf("mode %v: unexpected mode, got %v", mode, collection.mode)
+		}
+		if collection.closed != (mode != nil) {
+			t.Errorf("mode %v: unexpected close, want %v, got %v",
+				mode, mode != nil, collection.closed)
+		}
+		if !collection.query.AllCalled || collection.countCalled != 1 {
+			t.Errorf("mode %v: queries not run on collection", mode)
+		}
+	}
+}
+
+func TestCollectionHandlerArrayRows(t *testing.T) {
+	ch := &CollectionHandler{
+		Collection: &CollectionMock{
+			count: 100,
+			query: &QueryMock{
+				Result:     RequestTests[0].Result[:1],
+				CountValue: 1,
+			},
+		},
+		ArrayRows: true,
+	}
+	req := &http.Request{
+		Method: "GET",
+		URL:    &url.URL{Path: "/"},
+		Form: url.Values{
+			"draw":             []string{"1"},
+			"columns[0][data]": []string{"bar"},
+			"columns[1][data]": []string{"missing"},
+			"columns[2][data]": []string{"foo"},
+		},
+	}
+	w := httptest.NewRecorder()
+	ch.ServeHTTP(w, req)
+	var resp struct {
+		Data [][]string `json:"data"`
+	}
+	if err := json.NewDecoder(w.Result().Body).Decode(&resp); err != nil {
+		t.Fatalf("could not unmarshal response: %v", err)
+	}
+	want := [][]string{{"2", "", "1"}}
+	if !reflect.DeepEqual(resp.Data, want) {
+		t.Errorf("data does not match, want %v, got %v", want, resp.Data)
+	}
+}
+
+type ClosingCollectionMock struct {
+	*CollectionMock
+	closed int
+}
+
+func (c *ClosingCollectionMock) Close() error {
+	c.closed++
+	return nil
+}
+
+func TestCollectionHandlerClose(t *testing.T) {
+	var _ io.Closer = &CollectionHandler{}
+	collection := &ClosingCollectionMock{
+		CollectionMock: &CollectionMock{count: 100, query: &QueryMock{}},
+	}
+	ch := &CollectionHandler{
+		Collection: collection,
+		CacheTotal: time.Minute,
+	}
+	req := &http.Request{
+		Method: "GET",
+		URL:    &url.URL{Path: "/"},
+		Form:   url.Values{"draw": []string{"1"}},
+	}
+	ch.ServeHTTP(httptest.NewRecorder(), req)
+	for i := 0; i < 2; i++ {
+		if err := ch.Close(); err != nil {
+			t.Errorf("close %d: unexpected error: %v", i, err)
+		}
+	}
+	if collection.closed != 1 {
+		t.Errorf("unexpected collection closes, want %d, got %d",
+			1, collection.closed)
+	}
+	if ch.totals != nil {
+		t.Errorf("cached total not released")
+	}
+}
+
+func TestCollectionHandlerRequireSearchable(t *testing.T) {
+	for _, require := range []bool{false, true} {
+		query := &QueryMock{Result: RequestTests[0].Result}
+		collection := &CollectionMock{count: 100, query: query}
+		ch := &CollectionHandler{
+			Collection:        collection,
+			RequireSearchable: require,
+		}
+		req := &http.Request{
+			Method: "GET",
+			URL:    &url.URL{Path: "/"},
+			Form: url.Values{
+				"draw":                   []string{"2"},
+				"search[value]":          []string{"foo"},
+				"columns[0][data]":       []string{"name"},
+				"columns[0][searchable]": []string{"false"},
+			},
+		}
+		w := httptest.NewRecorder()
+		ch.ServeHTTP(w, req)
+		var dtResponse types.Response
+		if err := json.NewDecoder(w.Result().Body).Decode(&dtResponse); err != nil {
+			t.Fatalf("require %v: could not unmarshal response: %v", require, err)
+		}
+		wantStatus, wantError := http.StatusOK, ""
+		if require {
+			wantError = ErrNotSearchable.Error()
+		}
+		if w.Code != wantStatus {
+			t.Errorf("require %v: unexpected statuscode, want %d, got %d",
+				require, wantStatus, w.Code)
+		}
+		if dtResponse.Error != wantError {
+			t.Errorf("require %v: unexpected error, want %q, got %q",
+				require, wantError, dtResponse.Error)
+		}
+		if dtResponse.Draw != 2 {
+			t.Errorf("require %v: draw does not match, want %d, got %d",
+				require, 2, dtResponse.Draw)
+		}
+		if query.AllCalled == require {
+			t.Errorf("require %v: unexpected data query %v", require, query.AllCalled)
+		}
+		if !require && !reflect.DeepEqual(collection.filter, bson.M{}) {
+			t.Errorf("require %v: unexpected filter %+v", require, collection.filter)
+		}
+	}
+}
+
+func TestCollectionHandlerEstimateFiltered(t *testing.T) {
+	result := []map[string]string{
+		{"name": "a"}, {"name": "b"}, {"name": "c"},
+	}
+	cases := []struct {
+		Name            string
+		Length          string
+		Result          []map[string]string
+		LimitValue      int
+		Rows            int
+		RecordsFiltered int
+	}{
+		{
+			Name:            "has-more",
+			Length:          "2",
+			Result:          result,
+			LimitValue:      3,
+			Rows:            2,
+			RecordsFiltered: 13,
+		},
+		{
+			Name:            "page-boundary",
+			Length:          "3",
+			Result:          append(result, map[string]string{"name": "d"}),
+			LimitValue:      4,
+			Rows:            3,
+			RecordsFiltered: 14,
+		},
+		{
+			Name:            "last-page",
+			Length:          "3",
+			Result:          result,
+			LimitValue:      4,
+			Rows:            3,
+			RecordsFiltered: 13,
+		},
+	}
+	for _, c := range cases {
+		query := &QueryMock{Result: c.Result, CountValue: 1000}
+		ch := &CollectionHandler{
+			Collection:       &CollectionMock{count: 1000, query: query},
+			EstimateFiltered: true,
+		}
+		req := &http.Request{
+			Method: "GET",
+			URL:    &url.URL{Path: "/"},
+			Form: url.Values{
+				"draw":   []string{"1"},
+				"start":  []string{"10"},
+				"length": []string{c.Length},
+			},
+		}
+		w := httptest.NewRecorder()
+		ch.ServeHTTP(w, req)
+		var dtResponse types.Response
+		if err := json.NewDecoder(w.Result().Body).Decode(&dtResponse); err != nil {
+			t.Fatalf("case %s: could not unmarshal response: %v", c.Name, err)
+		}
+		if query.CountCalled {
+			t.Errorf("case %s: unexpected filtered count", c.Name)
+		}
+		if query.LimitValue != c.LimitValue {
+			t.Errorf("case %s: limit does not match, want %d, got %d",
+				c.Name, c.LimitValue, query.LimitValue)
+		}
+		if len(dtResponse.Data) != c.Rows {
+			t.Errorf("case %s: unexpected rows, want %d, got %d",
+				c.Name, c.Rows, len(dtResponse.Data))
+		}
+		if dtResponse.RecordsFiltered != c.RecordsFiltered {
+			t.Errorf("case %s: records filtered does not match, want %d, got %d",
+				c.Name, c.RecordsFiltered, dtResponse.RecordsFiltered)
+		}
+	}
+}
+
+func TestCollectionHandlerFilterHook(t *testing.T) {
+	collection := &CollectionMock{query: &QueryMock{}}
+	ch := &CollectionHandler{
+		Collection: collection,
+		FilterHook: func(f bson.M) bson.M {
+			f["$comment"] = "datatables"
+			return f
+		},
+	}
+	req := &http.Request{
+		Method: "GET",
+		URL:    &url.URL{Path: "/"},
+		Form: url.Values{
+			"draw":                      []string{"1"},
+			"columns[0][data]":          []string{"name"},
+			"columns[0][searchable]":    []string{"true"},
+			"columns[0][search][value]": []string{"foo"},
+		},
+	}
+	ch.ServeHTTP(httptest.NewRecorder(), req)
+	want := bson.M{
+		"name":     bson.RegEx{Pattern: "foo", Options: "i"},
+		"$comment": "datatables",
+	}
+	if !reflect.DeepEqual(collection.filters[0], want) {
+		t.Errorf("filter does not match, want %+v, got %+v",
+			want, collection.filters[0])
+	}
+}
+
+func TestCollectionHandlerFilterHookTotal(t *testing.T) {
+	ch := &CollectionHandler{
+		Collection: &FixtureCollectionMock{docs: columnCountsFixture},
+		FilterHook: func(f bson.M) bson.M {
+			return bson.M{"$and": []bson.M{f, {"office": "London"}}}
+		},
+	}
+	w := httptest.NewRecorder()
+	ch.ServeHTTP(w, httptest.NewRequest("GET", "/?draw=1", nil))
+	var dtResponse types.Response
+	if err := json.NewDecoder(w.Body).Decode(&dtResponse); err != nil {
+		t.Fatalf("could not unmarshal response: %v", err)
+	}
+	if dtResponse.RecordsTotal != 2 {
+		t.Errorf("records total not scoped by the hook, want %d, got %d",
+			2, dtResponse.RecordsTotal)
+	}
+}
+
+func TestCollectionHandlerFilterHookTotalCountFunc(t *testing.T) {
+	cases := []struct {
+		Name         string
+		Hook         func(f bson.M) bson.M
+		RecordsTotal int
+	}{
+		{
+			Name: "comment",
+			Hook: func(f bson.M) bson.M {
+				f["$comment"] = "datatables"
+				return f
+			},
+			RecordsTotal: 1000,
+		},
+		{
+			Name: "narrowing",
+			Hook: func(f bson.M) bson.M {
+				return bson.M{"$and": []bson.M{f, {"office": "London"}}}
+			},
+			RecordsTotal: 1000,
+		},
+	}
+	for _, c := range cases {
+		called := 0
+		ch := &CollectionHandler{
+			Collection: &FixtureCollectionMock{docs: columnCountsFixture},
+			FilterHook: c.Hook,
+			TotalCountFunc: func() (int, error) {
+				called++
+				return 1000, nil
+			},
+		}
+		w := httptest.NewRecorder()
+		ch.ServeHTTP(w, httptest.NewRequest("GET", "/?draw=1", nil))
+		var dtResponse types.Response
+		if err := json.NewDecoder(w.Body).Decode(&dtResponse); err != nil {
+			t.Fatalf("case %s: could not unmarshal response: %v", c.Name, err)
+		}
+		if called != 1 {
+			t.Errorf("case %s: total count func called %d times", c.Name, called)
+		}
+		if dtResponse.RecordsTotal != c.RecordsTotal {
+			t.Errorf("case %s: records total does not match, want %d, got %d",
+				c.Name, c.RecordsTotal, dtResponse.RecordsTotal)
+		}
+	}
+}
+
+func TestCollectionHandlerFilterHookCommentTotal(t *testing.T) {
+	collection := &CollectionMock{count: 57, query: &QueryMock{}}
+	ch := &CollectionHandler{
+		Collection: collection,
+		FilterHook: func(f bson.M) bson.M {
+			f["$comment"] = "datatables"
+			return f
+		},
+	}
+	w := httptest.NewRecorder()
+	ch.ServeHTTP(w, httptest.NewRequest("GET", "/?draw=1", nil))
+	var dtResponse types.Response
+	if err := json.NewDecoder(w.Body).Decode(&dtResponse); err != nil {
+		t.Fatalf("could not unmarshal response: %v", err)
+	}
+	// A hook that does not narrow the query keeps the collection count.
+	if collection.countCalled != 1 || dtResponse.RecordsTotal != 57 {
+		t.Errorf("collection not counted, want total %d, got %d (%d calls)",
+			57, dtResponse.RecordsTotal, collection.countCalled)
+	}
+}
+
+func TestCollectionHandlerHint(t *testing.T) {
+	cases := []struct {
+		Name string
+		Hint []string
+	}{
+		{Name: "no-hint"},
+		{Name: "hint", Hint: []string{"name", "-created"}},
+	}
+	for _, c := range cases {
+		query := &QueryMock{}
+		ch := &CollectionHandler{
+			Collection: &CollectionMock{query: query},
+			Hint:       c.Hint,
+		}
+		req := &http.Request{
+			Method: "GET",
+			URL:    &url.URL{Path: "/"},
+			Form: url.Values{
+				"draw":             []string{"1"},
+				"columns[0][data]": []string{"name"},
+			},
+		}
+		ch.ServeHTTP(httptest.NewRecorder(), req)
+		if !reflect.DeepEqual(query.HintValue, c.Hint) {
+			t.Errorf("case %s: hint does not match, want %v, got %v",
+				c.Name, c.Hint, query.HintValue)
+		}
+		if !query.AllCalled && !query.CountCalled {
+			t.Errorf("case %s: query was not executed", c.Name)
+		}
+	}
+}
+
+func TestCreateFilter(t *testing.T) {
+	for i, c := range RequestTests {
+		f := CreateFilter(c.Request)
+		if !reflect.DeepEqual(f, c.Filter) {
+			t.Errorf("case %d: filter not match, want %+v, got %+v",
+				i, c.Filter, f)
+		}
+	}
+}
+
+func TestCollectionHandlerModifiedSince(t *testing.T) {
+	since := time.Date(2017, 5, 27, 10, 0, 0, 0, time.UTC)
+	cases := []struct {
+		Name     string
+		Modified int
+		Data     []types.Row
+	}{
+		{
+			Name:     "up-to-date",
+			Modified: 0,
+			Data:     []types.Row{},
+		},
+		{
+			Name:     "changed",
+			Modified: 1,
+			Data:     RequestTests[0].ResponseData,
+		},
+	}
+	for _, c := range cases {
+		query := &QueryMock{
+			Result:     RequestTests[0].Result,
+			CountValue: c.Modified,
+		}
+		collection := &CollectionMock{
+			count: 100,
+			query: query,
+		}
+		ch := &CollectionHandler{
+			Collection:    collection,
+			ModifiedField: "updated",
+		}
+		req := &http.Request{
+			Method: "GET",
+			URL:    &url.URL{Path: "/"},
+			Form: url.Values{
+				"draw":             []string{"3"},
+				ModifiedSinceParam: []string{since.Format(time.RFC3339)},
+			},
+		}
+		w := httptest.NewRecorder()
+		ch.ServeHTTP(w, req)
+		var dtResponse types.Response
+		if err := json.NewDecoder(w.Result().Body).Decode(&dtResponse); err != nil {
+			t.Fatalf("case %s: could not unmarshal response: %v", c.Name, err)
+		}
+		want := ModifiedSinceFilter(CreateFilter(types.Request{Draw: 3}),
+			"updated", since)
+		if !reflect.DeepEqual(collection.filter, want) {
+			t.Errorf("case %s: filter does not match, want %+v, got %+v",
+				c.Name, want, collection.filter)
+		}
+		and, _ := collection.filter.(bson.M)["$and"].([]bson.M)
+		if len(and) != 2 || !reflect.DeepEqual(and[1], bson.M{"updated": bson.M{"$gt": since}}) {
+			t.Errorf("case %s: modified since not AND-combined: %+v",
+				c.Name, collection.filter)
+		}
+		if !reflect.DeepEqual(dtResponse.Data, c.Data) {
+			t.Errorf("case %s: data does not match, want %+v, got %+v",
+				c.Name, c.Data, dtResponse.Data)
+		}
+		if query.AllCalled != (c.Modified > 0) {
+			t.Errorf("case %s: unexpected data query, want %v, got %v",
+				c.Name, c.Modified > 0, query.AllCalled)
+		}
+	}
+}
+
+func TestCollectionHandlerETag(t *testing.T) {
+	query := &QueryMock{
+		Result: RequestTests[0].Result,
+	}
+	ch := &CollectionHandler{
+		Collection: &CollectionMock{
+			count: 100,
+			query: query,
+		},
+		ETag: true,
+	}
+	do := func(method, draw, ifNoneMatch string) *http.Response {
+		req := &http.Request{
+			Method: method,
+			URL:    &url.URL{Path: "/"},
+			Header: http.Header{},
+			Form: url.Values{
+				"draw": []string{draw},
+			},
+		}
+		if ifNoneMatch != "" {
+			req.Header.Set("If-None-Match", ifNoneMatch)
+		}
+		w := httptest.NewRecorder()
+		ch.ServeHTTP(w, req)
+		return w.Result()
+	}
+
+	first := do("GET", "1", "")
+	tag := first.Header.Get("ETag")
+	if first.StatusCode != http.StatusOK || tag == "" {
+		t.Fatalf("first request: want %d with etag, got %d %q",
+			http.StatusOK, first.StatusCode, tag)
+	}
+
+	// DataTables increases the draw counter with every request.
+	repeated := do("GET", "2", tag)
+	if repeated.StatusCode != http.StatusNotModified {
+		t.Errorf("repeated request: unexpected statuscode, want %d, got %d",
+			http.StatusNotModified, repeated.StatusCode)
+	}
+
+	post := do("POST", "3", tag)
+	if post.StatusCode != http.StatusOK {
+		t.Errorf("post request: unexpected statuscode, want %d, got %d",
+			http.StatusOK, post.StatusCode)
+	}
+
+	query.Result = RequestTests[0].Result[:1]
+	changed := do("GET", "4", tag)
+	if changed.StatusCode != http.StatusOK {
+		t.Errorf("changed request: unexpected statuscode, want %d, got %d",
+			http.StatusOK, changed.StatusCode)
+	}
+	if newTag := changed.Header.Get("ETag"); newTag == "" || newTag == tag {
+		t.Errorf("changed request: want new etag, got %q", newTag)
+	}
+	var dtResponse types.Response
+	if err := json.NewDecoder(changed.Body).Decode(&dtResponse); err != nil {
+		t.Fatalf("changed request: could not unmarshal response: %v", err)
+	}
+	if dtResponse.Draw != 4 {
+		t.Errorf("changed request: draw value does not match. want %d, got %d",
+			4, dtResponse.Draw)
+	}
+}
+
+func TestCollectionHandlerPlainTextErrors(t *testing.T) {
+	cases := []struct {
+		Name        string
+		Accept      string
+		Form        url.Values
+		CountErr    error
+		StatusCode  int
+		ContentType string
+		Body        string
+	}{
+		{
+			Name:       "parse-json",
+			Accept:     "application/json, text/javascript, */*; q=0.01",
+			Form:       url.Values{"draw": []string{"x"}},
+			StatusCode: http.StatusOK,
+			Body: `{"draw":0,"recordsTotal":0,"recordsFiltered":0,"data":[],` +
+				`"error":"invalid number: draw"}` + "\n",
+		},
+		{
+			Name:        "parse-plain",
+			Accept:      "text/plain",
+			Form:        url.Values{"draw": []string{"x"}},
+			StatusCode:  http.StatusBadRequest,
+			ContentType: "text/plain; charset=utf-8",
+			Body:        "invalid datatables request: invalid number: draw\n",
+		},
+		{
+			Name:        "query-plain",
+			Accept:      "text/plain; q=0.9, */*; q=0.1",
+			Form:        url.Values{"draw": []string{"1"}},
+			CountErr:    errors.New("no reachable servers"),
+			StatusCode:  http.StatusInternalServerError,
+			ContentType: "text/plain; charset=utf-8",
+			Body:        "query failed: no reachable servers\n",
+		},
+	}
+	for _, c := range cases {
+		ch := &CollectionHandler{
+			Collection: &CollectionMock{
+				err:   c.CountErr,
+				query: &QueryMock{},
+			},
+		}
+		req := &http.Request{
+			Method: "GET",
+			URL:    &url.URL{Path: "/"},
+			Header: http.Header{"Accept": []string{c.Accept}},
+			Form:   c.Form,
+		}
+		w := httptest.NewRecorder()
+		ch.ServeHTTP(w, req)
+		resp := w.Result()
+		if resp.StatusCode != c.StatusCode {
+			t.Errorf("case %s: unexpected statuscode, want %d, got %d",
+				c.Name, c.StatusCode, resp.StatusCode)
+		}
+		if ct := resp.Header.Get("Content-Type"); ct != c.ContentType {
+			t.Errorf("case %s: unexpected content type, want %q, got %q",
+				c.Name, c.ContentType, ct)
+		}
+		if body := w.Body.String(); body != c.Body {
+			t.Errorf("case %s: unexpected body, want %q, got %q",
+				c.Name, c.Body, body)
+		}
+	}
+}
+
+func TestCollectionHandlerErrorStatus(t *testing.T) {
+	cases := []struct {
+		Name        string
+		ErrorStatus int
+		StatusCode  int
+	}{
+		{
+			Name:       "default",
+			StatusCode: http.StatusOK,
+		},
+		{
+			Name:        "bad-request",
+			ErrorStatus: http.StatusBadRequest,
+			StatusCode:  http.StatusBadRequest,
+		},
+	}
+	for _, c := range cases {
+		ch := &CollectionHandler{
+			Collection:  &CollectionMock{query: &QueryMock{}},
+			ErrorStatus: c.ErrorStatus,
+		}
+		req := &http.Request{
+			Method: "GET",
+			URL:    &url.URL{Path: "/"},
+			Form: url.Values{
+				"draw":             []string{"3"},
+				"columns[0][data]": []string{"$where"},
+			},
+		}
+		w := httptest.NewRecorder()
+		ch.ServeHTTP(w, req)
+		if w.Code != c.StatusCode {
+			t.Errorf("case %s: unexpected statuscode, want %d, got %d",
+				c.Name, c.StatusCode, w.Code)
+		}
+		var dtResponse types.Response
+		if err := json.NewDecoder(w.Body).Decode(&dtResponse); err != nil {
+			t.Fatalf("case %s: could not unmarshal response: %v", c.Name, err)
+		}
+		if dtResponse.Draw != 3 || dtResponse.Error == "" {
+			t.Errorf("case %s: unexpected response %+v", c.Name, dtResponse)
+		}
+	}
+}
+
+// timeoutError is a net.Error of a socket timeout.
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func TestCollectionHandlerErrorCodes(t *testing.T) {
+	expired, cancel := context.WithDeadline(context.Background(), time.Now())
+	defer cancel()
+	cases := []struct {
+		Name       string
+		Query      string
+		Ctx        context.Context
+		Err        error
+		ErrorCodes bool
+		Code       types.ErrorCode
+	}{
+		{
+			Name:       "ok",
+			Query:      "draw=1",
+			ErrorCodes: true,
+		},
+		{
+			Name:       "parse",
+			Query:      "draw=abc",
+			ErrorCodes: true,
+			Code:       types.ErrorCodeBadRequest,
+		},
+		{
+			Name:       "query",
+			Query:      "draw=1",
+			Err:        errors.New("connection refused"),
+			ErrorCodes: true,
+			Code:       types.ErrorCodeQueryFailed,
+		},
+		{
+			Name:       "timeout",
+			Query:      "draw=1",
+			Ctx:        expired,
+			ErrorCodes: true,
+			Code:       types.ErrorCodeTimeout,
+		},
+		{
+			Name:       "server-timeout",
+			Query:      "draw=1",
+			Err:        &mgo.QueryError{Code: 50, Message: "operation exceeded time limit"},
+			ErrorCodes: true,
+			Code:       types.ErrorCodeTimeout,
+		},
+		{
+			Name:       "socket-timeout",
+			Query:      "draw=1",
+			Err:        timeoutError{},
+			ErrorCodes: true,
+			Code:       types.ErrorCodeTimeout,
+		},
+		{
+			Name:  "disabled",
+			Query: "draw=abc",
+		},
+	}
+	for _, c := range cases {
+		ch := &CollectionHandler{
+			Collection: &CollectionMock{
+				query: &QueryMock{Result: RequestTests[0].Result},
+				err:   c.Err,
+			},
+			BatchSize:  10,
+			ErrorCodes: c.ErrorCodes,
+		}
+		req := httptest.NewRequest("GET", "/?"+c.Query, nil)
+		if c.Ctx != nil {
+			req = req.WithContext(c.Ctx)
+		}
+		w := httptest.NewRecorder()
+		ch.ServeHTTP(w, req)
+		var dtResponse types.Response
+		if err := json.NewDecoder(w.Body).Decode(&dtResponse); err != nil {
+			t.Fatalf("case %s: could not unmarshal response: %v", c.Name, err)
+		}
+		if c.Code == "" {
+			if dtResponse.ErrorDetail != nil {
+				t.Errorf("case %s: unexpected error detail %+v",
+					c.Name, dtResponse.ErrorDetail)
+			}
+			continue
+		}
+		if dtResponse.ErrorDetail == nil {
+			t.Errorf("case %s: missing error detail", c.Name)
+			continue
+		}
+		if dtResponse.ErrorDetail.Code != c.Code ||
+			dtResponse.ErrorDetail.Message != dtResponse.Error {
+			t.Errorf("case %s: unexpected error detail, want code %s, got %+v",
+				c.Name, c.Code, dtResponse.ErrorDetail)
+		}
+	}
+}
+
+func TestCollectionHandlerDrawNotEchoed(t *testing.T) {
+	for _, accept := range []string{"application/json", "text/plain"} {
+		ch := &CollectionHandler{
+			Collection: &CollectionMock{query: &QueryMock{}},
+		}
+		req := httptest.NewRequest("GET", "/?draw=%3Cscript%3Ealert(1)%3C%2Fscript%3E"+
+			"&order[0][column]=%3Cscript%3E&columns[0][data]=name", nil)
+		req.Header.Set("Accept", accept)
+		w := httptest.NewRecorder()
+		ch.ServeHTTP(w, req)
+		if body := w.Body.String(); strings.Contains(body, "script") {
+			t.Errorf("accept %s: draw echoed in response: %s", accept, body)
+		}
+	}
+}
+
+func TestCreateFilterCaseSensitiveColumns(t *testing.T) {
+	o := FilterOptions{
+		CaseSensitiveColumns: map[string]bool{
+			"code": true,
+		},
+	}
+	r := types.Request{
+		Search: types.Search{
+			Value: "AB",
+		},
+		Columns: []types.Column{
+			{
+				Data:       "code",
+				Searchable: true,
+				Search: types.Search{
+					Value: "^X",
+					Regex: true,
+				},
+			},
+			{
+				Data:       "description",
+				Searchable: true,
+				Search: types.Search{
+					Value: "foo",
+				},
+			},
+		},
+	}
+	want := bson.M{
+		"$and": []bson.M{
+			{
+				"$or": []bson.M{
+					{"code": bson.RegEx{Pattern: "AB", Options: ""}},
+					{"description": bson.RegEx{Pattern: "AB", Options: "i"}},
+				},
+			},
+			{
+				"$and": []bson.M{
+					{"code": bson.RegEx{Pattern: "^X", Options: ""}},
+					{"description": bson.RegEx{Pattern: "foo", Options: "i"}},
+				},
+			},
+		},
+	}
+	f := o.CreateFilter(r)
+	if !reflect.DeepEqual(f, want) {
+		t.Errorf("filter not match, want %+v, got %+v", want, f)
+	}
+}
+
+func TestCreateFilterExactColumns(t *testing.T) {
+	o := FilterOptions{
+		ExactColumns: map[string]bool{"status": true},
+	}
+	r := types.Request{
+		Columns: []types.Column{
+			{
+				Data:       "status",
+				Searchable: true,
+				Search:     types.Search{Value: "active"},
+			},
+			{
+				Data:       "description",
+				Searchable: true,
+				Search:     types.Search{Value: "active"},
+			},
+		},
+	}
+	want := bson.M{
+		"$and": []bson.M{
+			{"status": bson.M{"$eq": "active"}},
+			{"description": bson.RegEx{Pattern: "active", Options: "i"}},
+		},
+	}
+	f := o.CreateFilter(r)
+	if !reflect.DeepEqual(f, want) {
+		t.Fatalf("filter not match, want %+v, got %+v", want, f)
+	}
+	// matches evaluates the condition of a single field filter.
+	matches := func(cond interface{}, v string) bool {
+		switch cond := cond.(type) {
+		case bson.M:
+			return cond["$eq"] == v
+		case bson.RegEx:
+			return regexp.MustCompile("(?i)" + cond.Pattern).MatchString(v)
+		}
+		t.Fatalf("unexpected condition %+v", cond)
+		return false
+	}
+	filters := f["$and"].([]bson.M)
+	if status := filters[0]["status"]; matches(status, "inactive") || !matches(status, "active") {
+		t.Errorf("exact column does not match exactly: %+v", status)
+	}
+	if desc := filters[1]["description"]; !matches(desc, "inactive") {
+		t.Errorf("normal column does not match substring: %+v", desc)
+	}
+}
+
+func TestCreateFilterVirtualFields(t *testing.T) {
+	o := FilterOptions{
+		VirtualFields: []VirtualField{
+			{Name: "full_name", Concat: []string{"$first_name", " ", "$last_name"}},
+		},
+	}
+	r := types.Request{
+		Search: types.Search{Value: "john d"},
+		Columns: []types.Column{
+			{Data: "full_name", Searchable: true},
+			{Data: "email", Searchable: true},
+		},
+	}
+	expr := bson.M{"$expr": bson.M{"$regexMatch": bson.M{
+		"input": bson.M{"$concat": []interface{}{
+			bson.M{"$ifNull": []interface{}{"$first_name", ""}},
+			" ",
+			bson.M{"$ifNull": []interface{}{"$last_name", ""}},
+		}},
+		"regex": bson.RegEx{Pattern: "john d", Options: "i"},
+	}}}
+	want := bson.M{
+		"$or": []bson.M{
+			expr,
+			{"email": bson.RegEx{Pattern: "john d", Options: "i"}},
+		},
+	}
+	f := o.CreateFilter(r)
+	if !reflect.DeepEqual(f, want) {
+		t.Errorf("filter not match, want %+v, got %+v", want, f)
+	}
+
+	r.Search.Value = ""
+	r.Columns[0].Search.Value = "john d"
+	if f := o.CreateFilter(r); !reflect.DeepEqual(f, expr) {
+		t.Errorf("column filter not match, want %+v, got %+v", expr, f)
+	}
+
+	// Fixed global searches match the virtual field like the global search.
+	r.Columns[0].Search.Value = ""
+	r.Search.Fixed = []types.FixedSearch{{Name: "person", Term: "john d"}}
+	if f := o.CreateFilter(r); !reflect.DeepEqual(f, want) {
+		t.Errorf("fixed filter not match, want %+v, got %+v", want, f)
+	}
+}
+
+func TestCollectionHandlerSlowQuery(t *testing.T) {
+	for _, delay := range []time.Duration{0, 20 * time.Millisecond} {
+		var slow []SlowQuery
+		ch := &CollectionHandler{
+			Collection: &CollectionMock{
+				query: &QueryMock{Delay: delay},
+			},
+			SlowQueryThreshold: 10 * time.Millisecond,
+			SlowQueryFunc: func(q SlowQuery) {
+				slow = append(slow, q)
+			},
+		}
+		req := &http.Request{
+			Method: "GET",
+			URL:    &url.URL{Path: "/"},
+			Form: url.Values{
+				"draw":                      []string{"4"},
+				"columns[0][data]":          []string{"name"},
+				"columns[0][searchable]":    []string{"true"},
+				"columns[0][search][value]": []string{"foo"},
+				"order[0][column]":          []string{"0"},
+				"order[0][dir]":             []string{"desc"},
+			},
+		}
+		ch.ServeHTTP(httptest.NewRecorder(), req)
+		if delay == 0 {
+			if len(slow) != 0 {
+				t.Errorf("delay %s: unexpected slow queries: %+v", delay, slow)
+			}
+			continue
+		}
+		if len(slow) != 1 {
+			t.Fatalf("delay %s: unexpected number of slow queries, want %d, got %d",
+				delay, 1, len(slow))
+		}
+		q := slow[0]
+		wantFilter := bson.M{"name": bson.RegEx{Pattern: "foo", Options: "i"}}
+		if q.Draw != 4 || !reflect.DeepEqual(q.Filter, wantFilter) ||
+			!reflect.DeepEqual(q.Sort, []string{"-name", "_id"}) || q.Duration < delay {
+			t.Errorf("delay %s: unexpected slow query %+v", delay, q)
+		}
+	}
+}
+
+func TestCollectionHandlerObserver(t *testing.T) {
+	var observations []Observation
+	ch := &CollectionHandler{
+		Collection: &CollectionMock{
+			count: 100,
+			query: &QueryMock{
+				Result:     RequestTests[0].Result,
+				CountValue: 2,
+			},
+		},
+		Observer: ObserverFunc(func(o Observation) {
+			observations = append(observations, o)
+		}),
+	}
+	req := &http.Request{
+		Method: "GET",
+		URL:    &url.URL{Path: "/"},
+		Form: url.Values{
+			"draw": []string{"5"},
+		},
+	}
+	ch.ServeHTTP(httptest.NewRecorder(), req)
+	if len(observations) != 1 {
+		t.Fatalf("unexpected number of observations, want %d, got %d",
+			1, len(observations))
+	}
+	o := observations[0]
+	o.Duration = 0
+	want := Observation{
+		Draw:            5,
+		RecordsTotal:    100,
+		RecordsFiltered: 2,
+	}
+	if o != want {
+		t.Errorf("observation does not match, want %+v, got %+v", want, o)
+	}
+}
+
+func TestCreateFilterAnchoredColumns(t *testing.T) {
+	o := FilterOptions{
+		AnchoredColumns: map[string]bool{
+			"code": true,
+			"sku":  true,
+		},
+	}
+	r := types.Request{
+		Search: types.Search{
+			Value: "a.b",
+		},
+		Columns: []types.Column{
+			{
+				Data:       "code",
+				Searchable: true,
+				Search:     types.Search{Value: "x|y", Regex: true},
+			},
+			{
+				Data:       "sku",
+				Searchable: true,
+				Search:     types.Search{Value: "^z", Regex: true},
+			},
+			{
+				Data:       "description",
+				Searchable: true,
+				Search:     types.Search{Value: "foo"},
+			},
+		},
+	}
+	want := bson.M{
+		"$and": []bson.M{
+			{
+				"$or": []bson.M{
+					{"code": bson.RegEx{Pattern: `^a\.b`, Options: "i"}},
+					{"sku": bson.RegEx{Pattern: `^a\.b`, Options: "i"}},
+					{"description": bson.RegEx{Pattern: `a\.b`, Options: "i"}},
+				},
+			},
+			{
+				"$and": []bson.M{
+					{"code": bson.RegEx{Pattern: "^(?:x|y)", Options: "i"}},
+					{"sku": bson.RegEx{Pattern: "^z", Options: "i"}},
+					{"description": bson.RegEx{Pattern: "foo", Options: "i"}},
+				},
+			},
+		},
+	}
+	f := o.CreateFilter(r)
+	if !reflect.DeepEqual(f, want) {
+		t.Errorf("filter not match, want %+v, got %+v", want, f)
+	}
+}
+
+// indexPrefix reports if the RegEx is a simple prefix expression that can use
+// index bounds: anchored, without options and without unescaped
+// metacharacters.
+func indexPrefix(re bson.RegEx) bool {
+	if re.Options != "" || !strings.HasPrefix(re.Pattern, "^") {
+		return false
+	}
+	p := re.Pattern[1:]
+	for i := 0; i < len(p); i++ {
+		if p[i] == '\\' {
+			i++
+			continue
+		}
+		if strings.IndexByte(`.+*?()|[]{}^$`, p[i]) >= 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func TestCreateFilterPrefixColumns(t *testing.T) {
+	o := FilterOptions{
+		PrefixColumns: map[string]bool{"name": true},
+		// The prefix columns take precedence over case folding.
+		FoldCase: true,
+	}
+	cases := []struct {
+		Name   string
+		Search types.Search
+		Want   bson.RegEx
+	}{
+		{
+			Name:   "literal",
+			Search: types.Search{Value: "Jo"},
+			Want:   bson.RegEx{Pattern: "^Jo"},
+		},
+		{
+			Name:   "metacharacters",
+			Search: types.Search{Value: "a.b*(c)"},
+			Want:   bson.RegEx{Pattern: `^a\.b\*\(c\)`},
+		},
+		{
+			Name:   "regex",
+			Search: types.Search{Value: "^(jo|ja)$", Regex: true},
+			Want:   bson.RegEx{Pattern: `^\^\(jo\|ja\)\$`},
+		},
+	}
+	for _, c := range cases {
+		r := types.Request{
+			Columns: []types.Column{
+				{Data: "name", Searchable: true, Search: c.Search},
+			},
+		}
+		want := bson.M{"name": c.Want}
+		f := o.CreateFilter(r)
+		if !reflect.DeepEqual(f, want) {
+			t.Errorf("case %s: filter not match, want %+v, got %+v",
+				c.Name, want, f)
+		}
+		if !indexPrefix(c.Want) {
+			t.Errorf("case %s: pattern %q is not index friendly",
+				c.Name, c.Want.Pattern)
+		}
+	}
+	if indexPrefix(bson.RegEx{Pattern: "^a.b"}) ||
+		indexPrefix(bson.RegEx{Pattern: "^ab", Options: "i"}) {
+		t.Error("indexPrefix accepts patterns that are not a simple prefix")
+	}
+}
+
+func TestParseBool(t *testing.T) {
+	cases := []struct {
+		Value string
+		Bool  bool
+		OK    bool
+	}{
+		{Value: "on", Bool: true, OK: true},
+		{Value: "true", Bool: true, OK: true},
+		{Value: "1", Bool: true, OK: true},
+		{Value: "off", Bool: false, OK: true},
+		{Value: "false", Bool: false, OK: true},
+		{Value: "0", Bool: false, OK: true},
+		{Value: "", Bool: false, OK: true},
+		{Value: "maybe", Bool: false, OK: false},
+	}
+	for _, c := range cases {
+		b, ok := parseBool(c.Value)
+		if b != c.Bool || ok != c.OK {
+			t.Errorf("case %q: want %v %v, got %v %v",
+				c.Value, c.Bool, c.OK, b, ok)
+		}
+	}
+}
+
+func TestCreateFilterSearchCombine(t *testing.T) {
+	columns := func(searches ...string) []types.Column {
+		c := []types.Column{{Data: "name", Searchable: true}}
+		for i, v := range searches {
+			c = append(c, types.Column{
+				Data:       []string{"office", "city"}[i],
+				Searchable: true,
+				Search:     types.Search{Value: v},
+			})
+		}
+		return c
+	}
+	re := func(v string) bson.RegEx {
+		return bson.RegEx{Pattern: v, Options: "i"}
+	}
+	cases := []struct {
+		Name    string
+		Options FilterOptions
+		Request types.Request
+		Want    bson.M
+	}{
+		{
+			Name: "and",
+			Request: types.Request{
+				Search:  types.Search{Value: "foo"},
+				Columns: columns("tokyo"),
+			},
+			Want: bson.M{"$and": []bson.M{
+				{"$or": []bson.M{
+					{"name": re("foo")},
+					{"office": re("foo")},
+				}},
+				{"$and": []bson.M{{"office": re("tokyo")}}},
+			}},
+		},
+		{
+			Name:    "or",
+			Options: FilterOptions{SearchCombine: SearchCombineOr},
+			Request: types.Request{
+				Search:  types.Search{Value: "foo"},
+				Columns: columns("tokyo"),
+			},
+			Want: bson.M{"$or": []bson.M{
+				{"name": re("foo")},
+				{"office": re("foo")},
+				{"office": re("tokyo")},
+			}},
+		},
+		{
+			Name:    "or-columns",
+			Options: FilterOptions{SearchCombine: SearchCombineOr},
+			Request: types.Request{
+				Search:  types.Search{Value: "foo"},
+				Columns: columns("tokyo", "edinburgh"),
+			},
+			Want: bson.M{"$or": []bson.M{
+				{"name": re("foo")},
+				{"office": re("foo")},
+				{"city": re("foo")},
+				{"$and": []bson.M{
+					{"office": re("tokyo")},
+					{"city": re("edinburgh")},
+				}},
+			}},
+		},
+		{
+			Name:    "or-columns-only",
+			Options: FilterOptions{SearchCombine: SearchCombineOr},
+			Request: types.Request{
+				Columns: columns("tokyo", "edinburgh"),
+			},
+			Want: bson.M{"$and": []bson.M{
+				{"office": re("tokyo")},
+				{"city": re("edinburgh")},
+			}},
+		},
+		{
+			Name: "or-word-prefix",
+			Options: FilterOptions{
+				SearchCombine:    SearchCombineOr,
+				WordPrefixSearch: true,
+			},
+			Request: types.Request{
+				Search:  types.Search{Value: "foo"},
+				Columns: columns("tokyo"),
+			},
+			Want: bson.M{"$or": []bson.M{
+				{"$or": []bson.M{
+					{"name": re("^foo")},
+					{"office": re("^foo")},
+				}},
+				{"office": re("tokyo")},
+			}},
+		},
+	}
+	for _, c := range cases {
+		f := c.Options.CreateFilter(c.Request)
+		if !reflect.DeepEqual(f, c.Want) {
+			t.Errorf("case %s: filter does not match, want %+v, got %+v",
+				c.Name, c.Want, f)
+		}
+	}
+}
+
+func TestCreateFilterColumnBool(t *testing.T) {
+	o := FilterOptions{
+		ColumnTypes: map[string]ColumnType{
+			"active":   ColumnBool,
+			"verified": ColumnBool,
+			"deleted":  ColumnBool,
+		},
+	}
+	r := types.Request{
+		Search: types.Search{Value: "foo"},
+		Columns: []types.Column{
+			{Data: "name", Searchable: true},
+			{Data: "active", Searchable: true, Search: types.Search{Value: "on"}},
+			{Data: "verified", Searchable: true, Search: types.Search{Value: "0"}},
+			{Data: "deleted", Searchable: true, Search: types.Search{Value: "maybe"}},
+		},
+	}
+	want := bson.M{
+		"$and": []bson.M{
+			{
+				"$or": []bson.M{
+					{"name": bson.RegEx{Pattern: "foo", Options: "i"}},
+				},
+			},
+			{
+				"$and": []bson.M{
+					{"active": bson.M{"$eq": true}},
+					{"verified": bson.M{"$eq": false}},
+				},
+			},
+		},
+	}
+	f := o.CreateFilter(r)
+	if !reflect.DeepEqual(f, want) {
+		t.Errorf("filter not match, want %+v, got %+v", want, f)
+	}
+}
+
+func TestCreateFilterColumnIDList(t *testing.T) {
+	o := FilterOptions{
+		ColumnTypes: map[string]ColumnType{
+			"_id":     ColumnIDList,
+			"user_id": ColumnIDList,
+		},
+	}
+	id := bson.ObjectIdHex("5f1e8a3b9c1d4e2f3a4b5c6d")
+	cases := []struct {
+		Name   string
+		Column string
+		Value  string
+		Want   bson.M
+	}{
+		{
+			Name:   "strings",
+			Column: "user_id",
+			Value:  "alice,bob, carol",
+			Want: bson.M{"user_id": bson.M{
+				"$in": []interface{}{"alice", "bob", "carol"},
+			}},
+		},
+		{
+			Name:   "object-ids",
+			Column: "_id",
+			Value:  id.Hex() + ",42",
+			Want: bson.M{"_id": bson.M{
+				"$in": []interface{}{id, "42"},
+			}},
+		},
+		{
+			Name:   "literal",
+			Column: "user_id",
+			Value:  "a.*,(b",
+			Want: bson.M{"user_id": bson.M{
+				"$in": []interface{}{"a.*", "(b"},
+			}},
+		},
+		{
+			Name:   "empty-elements",
+			Column: "user_id",
+			Value:  ",alice,,",
+			Want: bson.M{"user_id": bson.M{
+				"$in": []interface{}{"alice"},
+			}},
+		},
+		{
+			Name:   "empty",
+			Column: "user_id",
+			Value:  " , ",
+			Want:   bson.M{},
+		},
+	}
+	for _, c := range cases {
+		r := types.Request{
+			Search: types.Search{Value: "foo"},
+			Columns: []types.Column{{
+				Data:       c.Column,
+				Searchable: true,
+				Search:     types.Search{Value: c.Value},
+			}},
+		}
+		// The global search ignores ID list columns.
+		f := o.CreateFilter(r)
+		if !reflect.DeepEqual(f, c.Want) {
+			t.Errorf("case %s: filter does not match, want %+v, got %+v",
+				c.Name, c.Want, f)
+		}
+	}
+}
+
+func TestCreateFilterInvalidRegex(t *testing.T) {
+	r := types.Request{
+		Columns: []types.Column{
+			{
+				Data:       "name",
+				Searchable: true,
+				Search:     types.Search{Value: "(foo", Regex: true},
+			},
+		},
+	}
+	want := bson.M{"name": bson.RegEx{Pattern: `\(foo`, Options: "i"}}
+	f := CreateFilter(r)
+	if !reflect.DeepEqual(f, want) {
+		t.Errorf("filter not match, want %+v, got %+v", want, f)
+	}
+}
+
+func TestCollectionHandlerInvalidRegex(t *testing.T) {
+	collection := &CollectionMock{query: &QueryMock{}}
+	ch := &CollectionHandler{Collection: collection}
+	req := &http.Request{
+		Method: "GET",
+		URL:    &url.URL{Path: "/"},
+		Form: url.Values{
+			"draw":                      []string{"1"},
+			"columns[0][data]":          []string{"name"},
+			"columns[0][searchable]":    []string{"true"},
+			"columns[0][search][value]": []string{"a(b"},
+			"columns[0][search][regex]": []string{"true"},
+		},
+	}
+	w := httptest.NewRecorder()
+	ch.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Errorf("unexpected statuscode, want %d, got %d", http.StatusOK, w.Code)
+	}
+	var dtResponse types.Response
+	if err := json.NewDecoder(w.Result().Body).Decode(&dtResponse); err != nil {
+		t.Fatalf("could not unmarshal response: %v", err)
+	}
+	if dtResponse.Error != "" {
+		t.Errorf("unexpected error: %s", dtResponse.Error)
+	}
+	if re := collection.filter.(bson.M)["name"].(bson.RegEx); re.Pattern != `a\(b` {
+		t.Errorf("unexpected pattern, want %s, got %s", `a\(b`, re.Pattern)
+	}
+}
+
+func TestCreateFilterMinSearchLength(t *testing.T) {
+	o := FilterOptions{
+		MinSearchLength:       3,
+		MinColumnSearchLength: 2,
+	}
+	columns := func(search string) []types.Column {
+		return []types.Column{
+			{Data: "name", Searchable: true},
+			{Data: "city", Searchable: true, Search: types.Search{Value: search}},
+		}
+	}
+	cases := []struct {
+		Name    string
+		Request types.Request
+		Filter  bson.M
+	}{
+		{
+			Name: "below",
+			Request: types.Request{
+				Search:  types.Search{Value: "ab"},
+				Columns: columns("a"),
+			},
+			Filter: bson.M{},
+		},
+		{
+			Name: "at",
+			Request: types.Request{
+				Search:  types.Search{Value: "abc"},
+				Columns: columns(""),
+			},
+			Filter: bson.M{"$or": []bson.M{
+				{"name": bson.RegEx{Pattern: "abc", Options: "i"}},
+				{"city": bson.RegEx{Pattern: "abc", Options: "i"}},
+			}},
+		},
+		{
+			Name: "column-only",
+			Request: types.Request{
+				Search:  types.Search{Value: "a"},
+				Columns: columns("ab"),
+			},
+			Filter: bson.M{"city": bson.RegEx{Pattern: "ab", Options: "i"}},
+		},
+	}
+	for _, c := range cases {
+		f := o.CreateFilter(c.Request)
+		if !reflect.DeepEqual(f, c.Filter) {
+			t.Errorf("case %s: filter not match, want %+v, got %+v",
+				c.Name, c.Filter, f)
+		}
+	}
+}
+
+func TestCreateFilterMaxSearchLength(t *testing.T) {
+	o := FilterOptions{MaxSearchLength: 4}
+	r := types.Request{
+		Search: types.Search{Value: "ünïcode"},
+		Columns: []types.Column{
+			{Data: "name", Searchable: true},
+			{Data: "city", Searchable: true, Search: types.Search{Value: "amsterdam"}},
+		},
+	}
+	want := bson.M{"$and": []bson.M{
+		{"$or": []bson.M{
+			{"name": bson.RegEx{Pattern: "ünïc", Options: "i"}},
+			{"city": bson.RegEx{Pattern: "ünïc", Options: "i"}},
+		}},
+		{"$and": []bson.M{
+			{"city": bson.RegEx{Pattern: "amst", Options: "i"}},
+		}},
+	}}
+	f := o.CreateFilter(r)
+	if !reflect.DeepEqual(f, want) {
+		t.Errorf("filter not match, want %+v, got %+v", want, f)
+	}
+	if r.Search.Value != "ünïcode" || r.Columns[1].Search.Value != "amsterdam" {
+		t.Errorf("request was modified: %+v", r)
+	}
+	if err := o.ValidateSearch(r); err != nil {
+		t.Errorf("unexpected error without RejectLongSearch: %v", err)
+	}
+	o.RejectLongSearch = true
+	if err := o.ValidateSearch(r); !errors.Is(err, ErrSearchTooLong) {
+		t.Errorf("want error %v, got %v", ErrSearchTooLong, err)
+	}
+	r.Search.Value = "ünïc"
+	if err := o.ValidateSearch(r); !errors.Is(err, ErrSearchTooLong) {
+		t.Errorf("column: want error %v, got %v", ErrSearchTooLong, err)
+	}
+	r.Columns[1].Search.Value = "amst"
+	if err := o.ValidateSearch(r); err != nil {
+		t.Errorf("unexpected error at the limit: %v", err)
+	}
+}
+
+func TestCollectionHandlerRejectLongSearch(t *testing.T) {
+	collection := &CollectionMock{query: &QueryMock{}}
+	ch := &CollectionHandler{
+		Collection: collection,
+		Filter:     FilterOptions{MaxSearchLength: 3, RejectLongSearch: true},
+	}
+	req := httptest.NewRequest("GET", "/?draw=2&search[value]=abcd"+
+		"&columns[0][data]=name&columns[0][searchable]=true", nil)
+	w := httptest.NewRecorder()
+	ch.ServeHTTP(w, req)
+	var dtResponse types.Response
+	if err := json.NewDecoder(w.Body).Decode(&dtResponse); err != nil {
+		t.Fatalf("could not unmarshal response: %v", err)
+	}
+	if dtResponse.Error != ErrSearchTooLong.Error() || dtResponse.Draw != 2 {
+		t.Errorf("unexpected response %+v", dtResponse)
+	}
+	if collection.filter != nil {
+		t.Errorf("unexpected query with filter %+v", collection.filter)
+	}
+}
+
+func TestCreateFilterSearchFields(t *testing.T) {
+	o := FilterOptions{SearchFields: []string{"name", "email"}}
+	r := types.Request{
+		Search: types.Search{Value: "foo"},
+		Columns: []types.Column{
+			{Data: "name", Searchable: false},
+			{Data: "city", Searchable: true, Search: types.Search{Value: "ams"}},
+			{Data: "notes", Searchable: true},
+		},
+	}
+	want := bson.M{"$and": []bson.M{
+		{"$or": []bson.M{
+			{"name": bson.RegEx{Pattern: "foo", Options: "i"}},
+			{"email": bson.RegEx{Pattern: "foo", Options: "i"}},
+		}},
+		{"$and": []bson.M{
+			{"city": bson.RegEx{Pattern: "ams", Options: "i"}},
+		}},
+	}}
+	f := o.CreateFilter(r)
+	if !reflect.DeepEqual(f, want) {
+		t.Errorf("filter not match, want %+v, got %+v", want, f)
+	}
+
+	// All requested columns are still returned.
+	query := &QueryMock{Result: []map[string]string{
+		{"name": "foo", "city": "Amsterdam", "notes": "n", "email": "e"},
+	}}
+	ch := &CollectionHandler{
+		Collection: &CollectionMock{query: query},
+		Filter:     o,
+		Fields:     []string{"name", "city", "notes"},
+	}
+	req := httptest.NewRequest("GET", "/?draw=1&search[value]=foo"+
+		"&columns[0][data]=name&columns[1][data]=city&columns[2][data]=notes", nil)
+	w := httptest.NewRecorder()
+	ch.ServeHTTP(w, req)
+	var dtResponse types.Response
+	if err := json.NewDecoder(w.Body).Decode(&dtResponse); err != nil {
+		t.Fatalf("could not unmarshal response: %v", err)
+	}
+	if dtResponse.Error != "" || len(dtResponse.Data) != 1 {
+		t.Fatalf("unexpected response %+v", dtResponse)
+	}
+	for _, field := range []string{"name", "city", "notes"} {
+		if _, ok := dtResponse.Data[0].Data[field]; !ok {
+			t.Errorf("missing column %s in %+v", field, dtResponse.Data[0].Data)
+		}
+	}
+}
+
+func TestCreateFilterColumnSearchable(t *testing.T) {
+	r := types.Request{
+		Columns: []types.Column{
+			{
+				Data:       "name",
+				Searchable: true,
+				Search:     types.Search{Value: "foo"},
+			},
+			{
+				Data:       "city",
+				Searchable: true,
+				Search:     types.Search{Value: "bar"},
+			},
+			{
+				Data:       "secret",
+				Searchable: false,
+				Search:     types.Search{Value: "baz"},
+			},
+		},
+	}
+	want := bson.M{"$and": []bson.M{
+		{"name": bson.RegEx{Pattern: "foo", Options: "i"}},
+		{"city": bson.RegEx{Pattern: "bar", Options: "i"}},
+	}}
+	f := CreateFilter(r)
+	if !reflect.DeepEqual(f, want) {
+		t.Errorf("filter not match, want %+v, got %+v", want, f)
+	}
+}
+
+func TestCreateFilterFixedSearch(t *testing.T) {
+	r, err := types.ParseURLValues(url.Values{
+		"search[fixed][0][name]":             []string{"region"},
+		"search[fixed][0][term]":             []string{"emea"},
+		"columns[0][data]":                   []string{"name"},
+		"columns[0][searchable]":             []string{"true"},
+		"columns[0][search][value]":          []string{"foo"},
+		"columns[1][data]":                   []string{"region"},
+		"columns[1][searchable]":             []string{"true"},
+		"columns[1][search][fixed][0][name]": []string{"active"},
+		"columns[1][search][fixed][0][term]": []string{"e"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := bson.M{"$and": []bson.M{
+		{"name": bson.RegEx{Pattern: "foo", Options: "i"}},
+		{"$or": []bson.M{
+			{"name": bson.RegEx{Pattern: "emea", Options: "i"}},
+			{"region": bson.RegEx{Pattern: "emea", Options: "i"}},
+		}},
+		{"region": bson.RegEx{Pattern: "e", Options: "i"}},
+	}}
+	f := CreateFilter(r)
+	if !reflect.DeepEqual(f, want) {
+		t.Errorf("filter not match, want %+v, got %+v", want, f)
+	}
+}
+
+func TestCreateFilterWordPrefixSearch(t *testing.T) {
+	o := FilterOptions{WordPrefixSearch: true}
+	r := types.Request{
+		Search: types.Search{Value: " jo  sm.i "},
+		Columns: []types.Column{
+			{Data: "first", Searchable: true},
+			{Data: "last", Searchable: true},
+			{Data: "notes", Searchable: false},
+		},
+	}
+	want := bson.M{"$and": []bson.M{
+		{"$or": []bson.M{
+			{"first": bson.RegEx{Pattern: "^jo", Options: "i"}},
+			{"last": bson.RegEx{Pattern: "^jo", Options: "i"}},
+		}},
+		{"$or": []bson.M{
+			{"first": bson.RegEx{Pattern: `^sm\.i`, Options: "i"}},
+			{"last": bson.RegEx{Pattern: `^sm\.i`, Options: "i"}},
+		}},
+	}}
+	f := o.CreateFilter(r)
+	if !reflect.DeepEqual(f, want) {
+		t.Errorf("filter not match, want %+v, got %+v", want, f)
+	}
+}
+
+func TestCreateFilterHiddenColumns(t *testing.T) {
+	r, err := types.ParseURLValues(url.Values{
+		"search[value]":             []string{"foo"},
+		"columns[0][data]":          []string{"name"},
+		"columns[0][searchable]":    []string{"true"},
+		"columns[0][visible]":       []string{"true"},
+		"columns[1][data]":          []string{"notes"},
+		"columns[1][searchable]":    []string{"true"},
+		"columns[1][visible]":       []string{"false"},
+		"columns[1][search][value]": []string{"bar"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := bson.M{"$and": []bson.M{
+		{"$or": []bson.M{
+			{"name": bson.RegEx{Pattern: "foo", Options: "i"}},
+		}},
+		{"$and": []bson.M{
+			{"notes": bson.RegEx{Pattern: "bar", Options: "i"}},
+		}},
+	}}
+	f := CreateFilter(r)
+	if !reflect.DeepEqual(f, want) {
+		t.Errorf("filter not match, want %+v, got %+v", want, f)
+	}
+}
+
+func ExampleCollectionHandler() {
+	session, _ := mgo.Dial("mymongohost")
+	c := session.DB("mydb").C("mycollection")
 	http.Handle("/mycollection", NewCollectionHandler(c))
 	http.ListenAndServe(":8080", nil)
 }
+
+func ExampleRegister() {
+	session, _ := mgo.Dial("mymongohost")
+	defer session.Close()
+	mux := http.NewServeMux()
+	Register(mux, "/users", session.DB("mydb").C("users"),
+		func(ch *CollectionHandler) { ch.DefaultLength = 10 })
+	http.ListenAndServe(":8080", mux)
+}
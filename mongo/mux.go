@@ -0,0 +1,99 @@
+package mongo
+
+import (
+	"net/http"
+	"path"
+	"sync"
+
+	"gopkg.in/mgo.v2"
+)
+
+// Option configures a CollectionHandler created by Register.
+type Option func(ch *CollectionHandler)
+
+// Register creates a CollectionHandler for the collection, applies the
+// options and registers it on the mux under the pattern. The session of the
+// collection must stay open while the handler is in use.
+func Register(mux *http.ServeMux, pattern string, c *mgo.Collection, opts ...Option) *CollectionHandler {
+	ch := NewCollectionHandler(c)
+	for _, opt := range opts {
+		opt(ch)
+	}
+	mux.Handle(pattern, ch)
+	return ch
+}
+
+// CollectionMux dispatches requests to the CollectionHandler registered under
+// the last segment of the request path, so `/dt/users` is served by the
+// handler registered as `users`. Only registered collections are reachable,
+// other requests get a 404 Not Found.
+type CollectionMux struct {
+	// Name returns the name of the handler for the request, eg from a
+	// route variable of the router, see RouteVars and RouteParam.
+	// Defaults to the last segment of the request path.
+	Name func(r *http.Request) string
+
+	mu       sync.RWMutex
+	handlers map[string]*CollectionHandler
+}
+
+// NewCollectionMux returns a CollectionMux for the given collections, which
+// are registered under their collection name.
+func NewCollectionMux(collections ...*mgo.Collection) *CollectionMux {
+	m := &CollectionMux{}
+	for _, c := range collections {
+		m.Handle(c.Name, NewCollectionHandler(c))
+	}
+	return m
+}
+
+// Handle registers the handler under the name.
+func (m *CollectionMux) Handle(name string, h *CollectionHandler) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.handlers == nil {
+		m.handlers = make(map[string]*CollectionHandler)
+	}
+	m.handlers[name] = h
+}
+
+// Handler returns the handler registered under the name.
+func (m *CollectionMux) Handler(name string) (h *CollectionHandler, ok bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	h, ok = m.handlers[name]
+	return
+}
+
+// ServeHTTP implements the http.Handler interface
+func (m *CollectionMux) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	name := path.Base(r.URL.Path)
+	if m.Name != nil {
+		name = m.Name(r)
+	}
+	h, ok := m.Handler(name)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	h.ServeHTTP(w, r)
+}
+
+// RouteVars returns a CollectionMux Name function that takes the name from
+// the route variable key, as returned by vars. For gorilla/mux use
+// RouteVars(mux.Vars, "name") with the route `/tables/{name}`.
+func RouteVars(vars func(r *http.Request) map[string]string, key string) func(r *http.Request) string {
+	return func(r *http.Request) string {
+		return vars(r)[key]
+	}
+}
+
+// RouteParam returns a CollectionMux Name function that takes the name from
+// the route parameter key, as returned by param. For chi use
+// RouteParam(chi.URLParam, "name") and for the patterns of http.ServeMux
+// RouteParam((*http.Request).PathValue, "name").
+func RouteParam(param func(r *http.Request, key string) string, key string) func(r *http.Request) string {
+	return func(r *http.Request) string {
+		return param(r, key)
+	}
+}
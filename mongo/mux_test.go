@@ -0,0 +1,158 @@
+package mongo
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/basvdlei/godatatables/types"
+	"gopkg.in/mgo.v2"
+)
+
+func TestCollectionMux(t *testing.T) {
+	m := &CollectionMux{}
+	m.Handle("users", &CollectionHandler{
+		Collection: &CollectionMock{count: 10, query: &QueryMock{}},
+	})
+	m.Handle("orders", &CollectionHandler{
+		Collection: &CollectionMock{count: 20, query: &QueryMock{}},
+	})
+	cases := []struct {
+		Path         string
+		StatusCode   int
+		RecordsTotal int
+	}{
+		{
+			Path:         "/dt/users",
+			StatusCode:   http.StatusOK,
+			RecordsTotal: 10,
+		},
+		{
+			Path:         "/dt/orders",
+			StatusCode:   http.StatusOK,
+			RecordsTotal: 20,
+		},
+		{
+			Path:       "/dt/system.users",
+			StatusCode: http.StatusNotFound,
+		},
+		{
+			Path:       "/dt/",
+			StatusCode: http.StatusNotFound,
+		},
+	}
+	for _, c := range cases {
+		req := &http.Request{
+			Method: "GET",
+			URL:    &url.URL{Path: c.Path},
+			Form: url.Values{
+				"draw": []string{"1"},
+			},
+		}
+		w := httptest.NewRecorder()
+		m.ServeHTTP(w, req)
+		resp := w.Result()
+		if resp.StatusCode != c.StatusCode {
+			t.Errorf("case %s: unexpected statuscode, want %d, got %d",
+				c.Path, c.StatusCode, resp.StatusCode)
+		}
+		if c.StatusCode != http.StatusOK {
+			continue
+		}
+		var dtResponse types.Response
+		if err := json.NewDecoder(resp.Body).Decode(&dtResponse); err != nil {
+			t.Fatalf("case %s: could not unmarshal response: %v", c.Path, err)
+		}
+		if dtResponse.RecordsTotal != c.RecordsTotal {
+			t.Errorf("case %s: totalRecords does not match. want %d, got %d",
+				c.Path, c.RecordsTotal, dtResponse.RecordsTotal)
+		}
+	}
+}
+
+func TestRegister(t *testing.T) {
+	mux := http.NewServeMux()
+	collection := &CollectionMock{count: 10, query: &QueryMock{}}
+	ch := Register(mux, "/dt/users", &mgo.Collection{Name: "users"},
+		func(ch *CollectionHandler) { ch.Collection = collection },
+		func(ch *CollectionHandler) { ch.DefaultLength = 25 },
+	)
+	if ch.DefaultLength != 25 {
+		t.Errorf("option not applied, want %d, got %d", 25, ch.DefaultLength)
+	}
+	req := httptest.NewRequest("GET", "/dt/users?draw=1", nil)
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("unexpected statuscode, want %d, got %d", http.StatusOK, w.Code)
+	}
+	var dtResponse types.Response
+	if err := json.NewDecoder(w.Body).Decode(&dtResponse); err != nil {
+		t.Fatalf("could not unmarshal response: %v", err)
+	}
+	if dtResponse.RecordsTotal != 10 {
+		t.Errorf("totalRecords does not match. want %d, got %d",
+			10, dtResponse.RecordsTotal)
+	}
+}
+
+// routeVarsKey is the context key of the fake router's route variables.
+type routeVarsKey struct{}
+
+func fakeVars(r *http.Request) map[string]string {
+	vars, _ := r.Context().Value(routeVarsKey{}).(map[string]string)
+	return vars
+}
+
+func fakeParam(r *http.Request, key string) string {
+	return fakeVars(r)[key]
+}
+
+func TestCollectionMuxRouteVars(t *testing.T) {
+	for name, fn := range map[string]func(r *http.Request) string{
+		"vars":  RouteVars(fakeVars, "table"),
+		"param": RouteParam(fakeParam, "table"),
+	} {
+		m := &CollectionMux{Name: fn}
+		m.Handle("users", &CollectionHandler{
+			Collection: &CollectionMock{count: 10, query: &QueryMock{}},
+		})
+		cases := []struct {
+			Table      string
+			StatusCode int
+		}{
+			{Table: "users", StatusCode: http.StatusOK},
+			{Table: "orders", StatusCode: http.StatusNotFound},
+			{Table: "", StatusCode: http.StatusNotFound},
+		}
+		for _, c := range cases {
+			// The path does not contain the name, only the route variable.
+			req := httptest.NewRequest("GET", "/tables/x?draw=1", nil)
+			req = req.WithContext(context.WithValue(req.Context(),
+				routeVarsKey{}, map[string]string{"table": c.Table}))
+			w := httptest.NewRecorder()
+			m.ServeHTTP(w, req)
+			if w.Code != c.StatusCode {
+				t.Errorf("case %s %q: unexpected statuscode, want %d, got %d",
+					name, c.Table, c.StatusCode, w.Code)
+			}
+		}
+	}
+}
+
+func TestCollectionMuxPathValue(t *testing.T) {
+	m := &CollectionMux{Name: RouteParam((*http.Request).PathValue, "name")}
+	m.Handle("users", &CollectionHandler{
+		Collection: &CollectionMock{count: 10, query: &QueryMock{}},
+	})
+	mux := http.NewServeMux()
+	mux.Handle("/tables/{name}/data", m)
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest("GET", "/tables/users/data?draw=1", nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("unexpected statuscode, want %d, got %d", http.StatusOK, w.Code)
+	}
+}
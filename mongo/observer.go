@@ -0,0 +1,59 @@
+package mongo
+
+import (
+	"log"
+	"time"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+// Observation contains the details of a single handled Datatables request.
+type Observation struct {
+	// Draw counter of the request.
+	Draw int
+	// Duration of the queries.
+	Duration time.Duration
+	// RecordsTotal as returned in the response.
+	RecordsTotal int
+	// RecordsFiltered as returned in the response.
+	RecordsFiltered int
+	// Error as returned in the response, empty if there was no error.
+	Error string
+	// DeepPaging is set for requests beyond the deep paging threshold that
+	// had to skip all preceding records.
+	DeepPaging bool
+}
+
+// Observer is notified after a Datatables request has been queried.
+type Observer interface {
+	Observe(o Observation)
+}
+
+// ObserverFunc is an adapter to allow the use of ordinary functions as
+// Observer.
+type ObserverFunc func(o Observation)
+
+// Observe calls f(o).
+func (f ObserverFunc) Observe(o Observation) {
+	f(o)
+}
+
+// SlowQuery contains the details of a request whose queries took longer than
+// the SlowQueryThreshold.
+type SlowQuery struct {
+	// Draw counter of the request.
+	Draw int
+	// Filter as sent to MongoDB.
+	Filter bson.M
+	// Sort fields as sent to MongoDB.
+	Sort []string
+	// Duration of the queries.
+	Duration time.Duration
+}
+
+// logSlowQuery is the default slow query callback, which logs the query
+// using the standard logger.
+func logSlowQuery(q SlowQuery) {
+	log.Printf("datatables: slow query took %s: filter=%v sort=%v",
+		q.Duration, q.Filter, q.Sort)
+}
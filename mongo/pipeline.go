@@ -0,0 +1,358 @@
+package mongo
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/basvdlei/godatatables/types"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// Pipe interface contains the *mgo.Pipe methods used.
+type Pipe interface {
+	All(result interface{}) error
+	One(result interface{}) error
+}
+
+// PipeCollection interface contains the *mgo.Collection methods used by the
+// PipelineHandler.
+type PipeCollection interface {
+	Count() (n int, err error)
+	Pipe(pipeline interface{}) Pipe
+}
+
+// pipeWrapper wraps a *mgo.Pipe into Pipe interface to allow for mocked
+// testing.
+type pipeWrapper struct {
+	p *mgo.Pipe
+}
+
+// All wraps *mgo.Pipe.All().
+func (w *pipeWrapper) All(result interface{}) error {
+	return w.p.All(result)
+}
+
+// One wraps *mgo.Pipe.One().
+func (w *pipeWrapper) One(result interface{}) error {
+	return w.p.One(result)
+}
+
+// Pipe wraps *mgo.Collection.Pipe().
+func (cw *collectionWrapper) Pipe(pipeline interface{}) Pipe {
+	return &pipeWrapper{
+		p: cw.c.Pipe(pipeline),
+	}
+}
+
+// Lookup joins a single document of a foreign collection into the documents
+// under the As field. The fields of the joined document can be used as
+// columns by prefixing them with As (eg `department.name`).
+type Lookup struct {
+	// LocalField is the field of the documents to match on.
+	LocalField string
+	// From is the name of the foreign collection.
+	From string
+	// ForeignField is the field of the foreign documents to match on.
+	ForeignField string
+	// As is the field the joined document is stored under.
+	As string
+}
+
+// Stages returns the $lookup stage and the $unwind stage that turns the
+// joined array into a single (optional) document.
+func (l Lookup) Stages() []bson.M {
+	return []bson.M{
+		{
+			"$lookup": bson.M{
+				"from":         l.From,
+				"localField":   l.LocalField,
+				"foreignField": l.ForeignField,
+				"as":           l.As,
+			},
+		},
+		{
+			"$unwind": bson.M{
+				"path":                       "$" + l.As,
+				"preserveNullAndEmptyArrays": true,
+			},
+		},
+	}
+}
+
+// PipelineHandler provides a HTTP handler for a mgo collection that uses
+// aggregation pipelines to query the data. Like the CollectionHandler, the
+// sort ends with an `_id` tiebreaker unless Filter.UnstableSort is set.
+type PipelineHandler struct {
+	Collection PipeCollection
+	// Filter configures how the $match stage is created from the request.
+	Filter FilterOptions
+	// Lookups are applied before any of the filter, sort and range stages
+	// so the joined fields can be searched and sorted on.
+	Lookups []Lookup
+	// Observer is notified after every queried request.
+	Observer Observer
+	// Fields restricts the column data to these document fields. Column
+	// data containing operators is always rejected.
+	Fields []string
+	// NullsLast sorts documents with a null or missing sort field after
+	// the others, in both directions. By default MongoDB sorts them first
+	// in ascending order. This adds a computed sort key per ordered column,
+	// which can't use an index. The CollectionHandler has no equivalent
+	// since a find sort can't control the placement of nulls.
+	NullsLast bool
+	// RelevanceWeights maps column data to a weight for ranking the
+	// documents by relevance to the global search. Requests without an
+	// order are sorted by the sum of the weights of the matching columns,
+	// see RelevanceStages. Requires MongoDB 4.2 or newer.
+	RelevanceWeights map[string]int
+	// ErrorStatus is the status code of responses to invalid requests,
+	// like the ErrorStatus of the CollectionHandler.
+	ErrorStatus int
+	// DefaultLength is the number of records returned when the request
+	// does not specify a length. Without it such requests return all
+	// records. Requests with an explicit length of zero only get the
+	// counts.
+	DefaultLength int
+}
+
+// NewPipelineHandler returns a PipelineHandler for the given collection.
+func NewPipelineHandler(c *mgo.Collection, lookups ...Lookup) *PipelineHandler {
+	return &PipelineHandler{
+		Collection: &collectionWrapper{c: c},
+		Lookups:    lookups,
+	}
+}
+
+// ServeHTTP implements the http.Handler interface
+func (ph *PipelineHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		ph.invalidRequest(w, r, 0, err)
+		return
+	}
+	dtRequest, err := types.ParseURLValues(r.Form)
+	if err != nil {
+		ph.invalidRequest(w, r, 0, err)
+		return
+	}
+	if err := ValidateFields(dtRequest, ph.Filter.allowedFields(ph.Fields)...); err != nil {
+		ph.invalidRequest(w, r, dtRequest.Draw, err)
+		return
+	}
+	if err := ph.Filter.ValidateSearch(dtRequest); err != nil {
+		ph.invalidRequest(w, r, dtRequest.Draw, err)
+		return
+	}
+	dtRequest = dtRequest.WithDefaultLength(ph.DefaultLength)
+	start := time.Now()
+	var dtResponse types.Response
+	dtResponse.Draw = dtRequest.Draw
+	dtResponse.RecordsFiltered, err = ph.count(dtRequest)
+	if err != nil {
+		dtResponse.Error = err.Error()
+	}
+	dtResponse.RecordsTotal, err = ph.Collection.Count()
+	if err != nil {
+		dtResponse.Error = err.Error()
+	}
+	if dtRequest.HasLength && dtRequest.Length == 0 {
+		// Only the counts were requested.
+		dtResponse.Data = []types.Row{}
+	} else {
+		p := ph.Collection.Pipe(ph.Pipeline(dtRequest))
+		dtResponse.Data, err = ResponseData(p)
+		if err != nil {
+			dtResponse.Error = err.Error()
+		}
+	}
+	if ph.Observer != nil {
+		ph.Observer.Observe(observation(dtResponse, time.Since(start)))
+	}
+	if dtResponse.Error != "" && plainText(r) {
+		http.Error(w, "query failed: "+dtResponse.Error,
+			http.StatusInternalServerError)
+		return
+	}
+	e := json.NewEncoder(w)
+	err = e.Encode(&dtResponse)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
+
+// invalidRequest responds with the ErrorStatus containing the error in a
+// Datatables Response, like the CollectionHandler. Plain text clients receive
+// a 400 Bad Request with the reason.
+func (ph *PipelineHandler) invalidRequest(w http.ResponseWriter, r *http.Request, draw int, err error) {
+	if plainText(r) {
+		badRequest(w, r, err)
+		return
+	}
+	w.WriteHeader(errorStatus(ph.ErrorStatus))
+	json.NewEncoder(w).Encode(&types.Response{
+		Draw:  draw,
+		Data:  []types.Row{},
+		Error: err.Error(),
+	})
+}
+
+// count returns the number of documents matching the filter.
+func (ph *PipelineHandler) count(r types.Request) (int, error) {
+	var result struct {
+		N int `bson:"n"`
+	}
+	err := ph.Collection.Pipe(ph.CountPipeline(r)).One(&result)
+	if err == mgo.ErrNotFound {
+		return 0, nil
+	}
+	return result.N, err
+}
+
+// Pipeline returns the aggregation pipeline that selects the data for the
+// Datatables Request.
+func (ph *PipelineHandler) Pipeline(r types.Request) []bson.M {
+	pipeline := ph.matchPipeline(r)
+	if stages := ph.Filter.RelevanceStages(r, ph.RelevanceWeights); stages != nil {
+		pipeline = append(pipeline, stages...)
+	} else if stages := NullsLastSortStages(r); ph.NullsLast && stages != nil {
+		if !ph.Filter.UnstableSort {
+			stages[1]["$sort"] = stableDocument(stages[1]["$sort"].(bson.D))
+		}
+		pipeline = append(pipeline, stages...)
+	} else if sort := ph.Filter.SortDocument(r); len(sort) > 0 {
+		pipeline = append(pipeline, bson.M{"$sort": sort})
+	}
+	return append(pipeline, RangeStages(r)...)
+}
+
+// CountPipeline returns the aggregation pipeline that counts the documents
+// matching the Datatables Request.
+func (ph *PipelineHandler) CountPipeline(r types.Request) []bson.M {
+	return append(ph.matchPipeline(r), bson.M{"$count": "n"})
+}
+
+// matchPipeline returns the lookup stages followed by the $match stage.
+func (ph *PipelineHandler) matchPipeline(r types.Request) []bson.M {
+	var pipeline []bson.M
+	for _, l := range ph.Lookups {
+		pipeline = append(pipeline, l.Stages()...)
+	}
+	return append(pipeline, bson.M{"$match": ph.Filter.CreateFilter(r)})
+}
+
+// SortStage returns the $sort stage for the Datatables Request with the
+// default FilterOptions, which is the sort the handlers run: only the first
+// order of a column is used and `_id` is the final tiebreaker.
+func SortStage(r types.Request) bson.M {
+	return bson.M{"$sort": FilterOptions{}.SortDocument(r)}
+}
+
+// stableDocument appends `_id` as the final ascending tiebreaker to the sort
+// document, unless it is already sorted on.
+func stableDocument(sort bson.D) bson.D {
+	for _, e := range sort {
+		if e.Name == "_id" {
+			return sort
+		}
+	}
+	return append(sort, bson.DocElem{Name: "_id", Value: 1})
+}
+
+// NullsLastSortStages returns the stages sorting the Datatables Request with
+// null and missing values last, or nil if there is nothing to sort on. For
+// every ordered column an $addFields stage computes a key that is true for
+// null values, which is sorted ascending before the column itself. A final
+// $project stage removes the computed keys.
+func NullsLastSortStages(r types.Request) []bson.M {
+	keys := bson.M{}
+	sort := make(bson.D, 0, 2*len(r.Order))
+	seen := make(map[string]bool, len(r.Order))
+	for _, o := range r.Order {
+		c, ok := r.OrderColumn(o)
+		if !ok || seen[c.Data] {
+			continue
+		}
+		seen[c.Data] = true
+		dir := 1
+		if o.Dir == types.OrderDescending {
+			dir = -1
+		}
+		key := "_null" + strconv.Itoa(len(keys))
+		keys[key] = bson.M{"$eq": []interface{}{
+			bson.M{"$ifNull": []interface{}{"$" + c.Data, nil}},
+			nil,
+		}}
+		sort = append(sort,
+			bson.DocElem{Name: key, Value: 1},
+			bson.DocElem{Name: c.Data, Value: dir},
+		)
+	}
+	if len(sort) == 0 {
+		return nil
+	}
+	project := bson.M{}
+	for k := range keys {
+		project[k] = 0
+	}
+	return []bson.M{
+		{"$addFields": keys},
+		{"$sort": sort},
+		{"$project": project},
+	}
+}
+
+// RelevanceStages returns the stages sorting the documents by relevance to
+// the global search of the Datatables Request, or nil if the request is
+// ordered or there is nothing to rank on. An $addFields stage computes a
+// score of the summed weights of the columns matching the global search,
+// which is sorted descending (with _id breaking ties) and removed by a final
+// $project stage. Columns without a weight are not scored.
+func (o FilterOptions) RelevanceStages(r types.Request, weights map[string]int) []bson.M {
+	r = o.truncateSearch(r)
+	if len(weights) == 0 || len(SortFields(r)) > 0 ||
+		r.Search.Value == "" || tooShort(r.Search.Value, o.MinSearchLength) {
+		return nil
+	}
+	var scores []interface{}
+	for _, c := range r.Columns {
+		w, ok := weights[c.Data]
+		if !ok || !c.GlobalSearchable() {
+			continue
+		}
+		match := bson.M{"$regexMatch": bson.M{
+			"input": bson.M{"$convert": bson.M{
+				"input":   "$" + c.Data,
+				"to":      "string",
+				"onError": "",
+				"onNull":  "",
+			}},
+			"regex": o.regEx(c.Data, r.Search),
+		}}
+		scores = append(scores, bson.M{"$cond": []interface{}{match, w, 0}})
+	}
+	if len(scores) == 0 {
+		return nil
+	}
+	return []bson.M{
+		{"$addFields": bson.M{"_score": bson.M{"$add": scores}}},
+		{"$sort": bson.D{
+			{Name: "_score", Value: -1},
+			{Name: "_id", Value: 1},
+		}},
+		{"$project": bson.M{"_score": 0}},
+	}
+}
+
+// RangeStages returns the $skip and $limit stages for the Datatables Request.
+func RangeStages(r types.Request) []bson.M {
+	var stages []bson.M
+	if r.Start > 0 {
+		stages = append(stages, bson.M{"$skip": r.Start})
+	}
+	if r.Length > 0 {
+		stages = append(stages, bson.M{"$limit": r.Length})
+	}
+	return stages
+}
@@ -0,0 +1,384 @@
+package mongo
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"testing"
+
+	"github.com/basvdlei/godatatables/types"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+type PipeMock struct {
+	Result []map[string]string
+	Count  int
+}
+
+func (p *PipeMock) All(result interface{}) error {
+	if v, ok := result.(*[]bson.M); ok {
+		*v = append(*v, documents(p.Result)...)
+		return nil
+	}
+	return errors.New("unknown type")
+}
+func (p *PipeMock) One(result interface{}) error {
+	b, err := bson.Marshal(bson.M{"n": p.Count})
+	if err != nil {
+		return err
+	}
+	return bson.Unmarshal(b, result)
+}
+
+type PipeCollectionMock struct {
+	count     int
+	pipe      *PipeMock
+	pipelines [][]bson.M
+}
+
+func (c *PipeCollectionMock) Count() (n int, err error) {
+	return c.count, nil
+}
+func (c *PipeCollectionMock) Pipe(pipeline interface{}) Pipe {
+	c.pipelines = append(c.pipelines, pipeline.([]bson.M))
+	return c.pipe
+}
+
+var departmentLookup = Lookup{
+	LocalField:   "department_id",
+	From:         "departments",
+	ForeignField: "_id",
+	As:           "department",
+}
+
+var lookupRequest = types.Request{
+	Draw:   3,
+	Start:  10,
+	Length: 10,
+	Order: []types.Order{
+		{
+			Column: 1,
+			Dir:    types.OrderDescending,
+		},
+	},
+	Search: types.Search{
+		Value: "sales",
+	},
+	Columns: []types.Column{
+		{
+			Data:       "name",
+			Searchable: true,
+			Orderable:  true,
+		},
+		{
+			Data:       "department.name",
+			Searchable: true,
+			Orderable:  true,
+		},
+	},
+}
+
+func TestPipeline(t *testing.T) {
+	ph := &PipelineHandler{
+		Lookups: []Lookup{departmentLookup},
+	}
+	want := []bson.M{
+		{
+			"$lookup": bson.M{
+				"from":         "departments",
+				"localField":   "department_id",
+				"foreignField": "_id",
+				"as":           "department",
+			},
+		},
+		{
+			"$unwind": bson.M{
+				"path":                       "$department",
+				"preserveNullAndEmptyArrays": true,
+			},
+		},
+		{
+			"$match": CreateFilter(lookupRequest),
+		},
+		{
+			"$sort": bson.D{
+				{Name: "department.name", Value: -1},
+				{Name: "_id", Value: 1},
+			},
+		},
+		{
+			"$skip": 10,
+		},
+		{
+			"$limit": 10,
+		},
+	}
+	p := ph.Pipeline(lookupRequest)
+	if !reflect.DeepEqual(p, want) {
+		t.Errorf("pipeline does not match, want %+v, got %+v", want, p)
+	}
+}
+
+func TestPipelineNullsLast(t *testing.T) {
+	ph := &PipelineHandler{
+		NullsLast: true,
+	}
+	r := types.Request{
+		Length: 10,
+		Order: []types.Order{
+			{Column: 1, Dir: types.OrderAscending},
+			{Column: 0, Dir: types.OrderDescending},
+		},
+		Columns: []types.Column{
+			{Data: "name"},
+			{Data: "department.name"},
+		},
+	}
+	isNull := func(field string) bson.M {
+		return bson.M{"$eq": []interface{}{
+			bson.M{"$ifNull": []interface{}{field, nil}},
+			nil,
+		}}
+	}
+	want := []bson.M{
+		{"$match": CreateFilter(r)},
+		{"$addFields": bson.M{
+			"_null0": isNull("$department.name"),
+			"_null1": isNull("$name"),
+		}},
+		{"$sort": bson.D{
+			{Name: "_null0", Value: 1},
+			{Name: "department.name", Value: 1},
+			{Name: "_null1", Value: 1},
+			{Name: "name", Value: -1},
+			{Name: "_id", Value: 1},
+		}},
+		{"$project": bson.M{"_null0": 0, "_null1": 0}},
+		{"$limit": 10},
+	}
+	p := ph.Pipeline(r)
+	if !reflect.DeepEqual(p, want) {
+		t.Errorf("pipeline does not match, want %+v, got %+v", want, p)
+	}
+	if stages := NullsLastSortStages(types.Request{}); stages != nil {
+		t.Errorf("unexpected stages without order: %+v", stages)
+	}
+}
+
+func TestPipelineRelevance(t *testing.T) {
+	ph := &PipelineHandler{
+		RelevanceWeights: map[string]int{"name": 3, "department.name": 1},
+	}
+	r := types.Request{
+		Search: types.Search{Value: "sales"},
+		Columns: []types.Column{
+			{Data: "name", Searchable: true},
+			{Data: "department.name", Searchable: true},
+			{Data: "email", Searchable: true},
+		},
+	}
+	score := func(field string, weight int) bson.M {
+		return bson.M{"$cond": []interface{}{
+			bson.M{"$regexMatch": bson.M{
+				"input": bson.M{"$convert": bson.M{
+					"input":   "$" + field,
+					"to":      "string",
+					"onError": "",
+					"onNull":  "",
+				}},
+				"regex": bson.RegEx{Pattern: "sales", Options: "i"},
+			}},
+			weight,
+			0,
+		}}
+	}
+	want := []bson.M{
+		{"$match": CreateFilter(r)},
+		{"$addFields": bson.M{"_score": bson.M{"$add": []interface{}{
+			score("name", 3),
+			score("department.name", 1),
+		}}}},
+		{"$sort": bson.D{
+			{Name: "_score", Value: -1},
+			{Name: "_id", Value: 1},
+		}},
+		{"$project": bson.M{"_score": 0}},
+	}
+	p := ph.Pipeline(r)
+	if !reflect.DeepEqual(p, want) {
+		t.Errorf("pipeline does not match, want %+v, got %+v", want, p)
+	}
+
+	// An explicit order takes precedence over the relevance.
+	r.Order = []types.Order{{Column: 2, Dir: types.OrderAscending}}
+	if stages := ph.Filter.RelevanceStages(r, ph.RelevanceWeights); stages != nil {
+		t.Errorf("unexpected relevance stages for ordered request: %+v", stages)
+	}
+	r.Order = nil
+	r.Search.Value = ""
+	if stages := ph.Filter.RelevanceStages(r, ph.RelevanceWeights); stages != nil {
+		t.Errorf("unexpected relevance stages without search: %+v", stages)
+	}
+}
+
+func TestCountPipeline(t *testing.T) {
+	ph := &PipelineHandler{
+		Lookups: []Lookup{departmentLookup},
+	}
+	p := ph.CountPipeline(lookupRequest)
+	if len(p) != 4 {
+		t.Fatalf("unexpected number of stages, want %d, got %d", 4, len(p))
+	}
+	if _, ok := p[0]["$lookup"]; !ok {
+		t.Errorf("first stage is not a lookup: %+v", p[0])
+	}
+	if _, ok := p[2]["$match"]; !ok {
+		t.Errorf("lookup is not followed by match: %+v", p[2])
+	}
+	if !reflect.DeepEqual(p[3], bson.M{"$count": "n"}) {
+		t.Errorf("last stage is not a count: %+v", p[3])
+	}
+}
+
+func TestPipelineHandlerServeHTTP(t *testing.T) {
+	c := RequestTests[0]
+	collection := &PipeCollectionMock{
+		count: 100,
+		pipe: &PipeMock{
+			Result: c.Result,
+			Count:  2,
+		},
+	}
+	ph := &PipelineHandler{
+		Collection: collection,
+		Lookups:    []Lookup{departmentLookup},
+	}
+	req := &http.Request{
+		Method: "GET",
+		URL:    &url.URL{Path: "/"},
+		Form: url.Values{
+			"draw": []string{"7"},
+		},
+	}
+	w := httptest.NewRecorder()
+	ph.ServeHTTP(w, req)
+	var dtResponse types.Response
+	if err := json.NewDecoder(w.Result().Body).Decode(&dtResponse); err != nil {
+		t.Fatalf("could not unmarshal response: %v", err)
+	}
+	want := types.Response{
+		Draw:            7,
+		RecordsTotal:    100,
+		RecordsFiltered: 2,
+		Data:            c.ResponseData,
+	}
+	if !reflect.DeepEqual(dtResponse, want) {
+		t.Errorf("response does not match, want %+v, got %+v", want, dtResponse)
+	}
+	if len(collection.pipelines) != 2 {
+		t.Fatalf("unexpected number of pipelines, want %d, got %d",
+			2, len(collection.pipelines))
+	}
+	for i, p := range collection.pipelines {
+		if _, ok := p[0]["$lookup"]; !ok {
+			t.Errorf("pipeline %d: first stage is not a lookup: %+v", i, p[0])
+		}
+	}
+}
+
+func TestPipelineHandlerErrorStatus(t *testing.T) {
+	cases := []struct {
+		Name        string
+		ErrorStatus int
+		Accept      string
+		StatusCode  int
+	}{
+		{
+			Name:       "default",
+			StatusCode: http.StatusOK,
+		},
+		{
+			Name:        "bad-request",
+			ErrorStatus: http.StatusBadRequest,
+			StatusCode:  http.StatusBadRequest,
+		},
+		{
+			Name:       "plain-text",
+			Accept:     "text/plain",
+			StatusCode: http.StatusBadRequest,
+		},
+	}
+	for _, c := range cases {
+		ph := &PipelineHandler{
+			Collection:  &PipeCollectionMock{pipe: &PipeMock{}},
+			ErrorStatus: c.ErrorStatus,
+		}
+		req := &http.Request{
+			Method: "GET",
+			URL:    &url.URL{Path: "/"},
+			Header: http.Header{"Accept": []string{c.Accept}},
+			Form: url.Values{
+				"draw":             []string{"3"},
+				"columns[0][data]": []string{"$where"},
+			},
+		}
+		w := httptest.NewRecorder()
+		ph.ServeHTTP(w, req)
+		if w.Code != c.StatusCode {
+			t.Errorf("case %s: unexpected statuscode, want %d, got %d",
+				c.Name, c.StatusCode, w.Code)
+		}
+		if c.Accept != "" {
+			continue
+		}
+		var dtResponse types.Response
+		if err := json.NewDecoder(w.Body).Decode(&dtResponse); err != nil {
+			t.Fatalf("case %s: could not unmarshal response: %v", c.Name, err)
+		}
+		if dtResponse.Draw != 3 || dtResponse.Error == "" {
+			t.Errorf("case %s: unexpected response %+v", c.Name, dtResponse)
+		}
+	}
+}
+
+func TestPipelineHandlerDefaultLength(t *testing.T) {
+	cases := []struct {
+		Name          string
+		DefaultLength int
+		Query         string
+		Limit         interface{}
+		Pipelines     int
+	}{
+		{Name: "absent", Pipelines: 2},
+		{Name: "absent-default", DefaultLength: 25, Limit: 25, Pipelines: 2},
+		{Name: "present", DefaultLength: 25, Query: "&length=10", Limit: 10, Pipelines: 2},
+		{Name: "present-zero", DefaultLength: 25, Query: "&length=0", Pipelines: 1},
+	}
+	for _, c := range cases {
+		collection := &PipeCollectionMock{pipe: &PipeMock{}}
+		ph := &PipelineHandler{
+			Collection:    collection,
+			DefaultLength: c.DefaultLength,
+		}
+		ph.ServeHTTP(httptest.NewRecorder(),
+			httptest.NewRequest("GET", "/?draw=1"+c.Query, nil))
+		if len(collection.pipelines) != c.Pipelines {
+			t.Fatalf("case %s: unexpected number of pipelines, want %d, got %d",
+				c.Name, c.Pipelines, len(collection.pipelines))
+		}
+		var limit interface{}
+		for _, stage := range collection.pipelines[len(collection.pipelines)-1] {
+			if v, ok := stage["$limit"]; ok {
+				limit = v
+			}
+		}
+		if c.Pipelines > 1 && limit != c.Limit {
+			t.Errorf("case %s: limit does not match, want %v, got %v",
+				c.Name, c.Limit, limit)
+		}
+	}
+}
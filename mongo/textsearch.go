@@ -0,0 +1,49 @@
+package mongo
+
+import (
+	"github.com/basvdlei/godatatables/types"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// textScorePrefix is the prefix of sort fields sorting by the text score, as
+// used by *mgo.Query.Sort().
+const textScorePrefix = "$textScore:"
+
+// relevanceSort returns the sort fields where the RelevanceColumn sorts by
+// the text score while a TextSearch is active. The text score is always
+// sorted in descending order, the most relevant documents first. Without an
+// active text search the RelevanceColumn is not sorted on, since it has no
+// document field. Sorting by the text score without projecting it requires
+// MongoDB 4.4 or newer.
+func (o FilterOptions) relevanceSort(r types.Request, fields []string) []string {
+	if o.RelevanceColumn == "" {
+		return fields
+	}
+	active := o.TextSearch && r.Search.Value != "" &&
+		!tooShort(r.Search.Value, o.MinSearchLength)
+	sort := fields[:0]
+	for _, f := range fields {
+		if f != o.RelevanceColumn && f != "-"+o.RelevanceColumn {
+			sort = append(sort, f)
+		} else if active {
+			sort = append(sort, textScorePrefix+o.RelevanceColumn)
+		}
+	}
+	return sort
+}
+
+// allowedFields returns the allowed fields with the RelevanceColumn, which
+// has no document field but can be ordered by. Without allowed fields all
+// fields are allowed.
+func (o FilterOptions) allowedFields(fields []string) []string {
+	if len(fields) == 0 || o.RelevanceColumn == "" || contains(fields, o.RelevanceColumn) {
+		return fields
+	}
+	return append(fields[:len(fields):len(fields)], o.RelevanceColumn)
+}
+
+// SortDocument returns the ordered sort document of the SortFields, where
+// the RelevanceColumn sorts by the `$meta` text score.
+func (o FilterOptions) SortDocument(r types.Request) bson.D {
+	return keyDocument(o.SortFields(r))
+}
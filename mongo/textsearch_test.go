@@ -0,0 +1,165 @@
+package mongo
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/basvdlei/godatatables/types"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+func textSearchRequest(search string, dir types.OrderDirection) types.Request {
+	return types.Request{
+		Search: types.Search{Value: search},
+		Order: []types.Order{
+			{Column: 1, Dir: dir},
+			{Column: 0, Dir: types.OrderAscending},
+		},
+		Columns: []types.Column{
+			{Data: "name", Searchable: true, Orderable: true},
+			{Data: "score", Orderable: true},
+		},
+	}
+}
+
+func TestFilterOptionsTextSearch(t *testing.T) {
+	o := FilterOptions{TextSearch: true, RelevanceColumn: "score"}
+	cases := []struct {
+		Name     string
+		Request  types.Request
+		Filter   bson.M
+		Sort     []string
+		Document bson.D
+	}{
+		{
+			Name:    "relevance-descending",
+			Request: textSearchRequest("coffee", types.OrderDescending),
+			Filter:  bson.M{"$text": bson.M{"$search": "coffee"}},
+			Sort:    []string{"$textScore:score", "name", "_id"},
+			Document: bson.D{
+				{Name: "score", Value: bson.M{"$meta": "textScore"}},
+				{Name: "name", Value: 1},
+				{Name: "_id", Value: 1},
+			},
+		},
+		{
+			Name:    "relevance-ascending",
+			Request: textSearchRequest("coffee", types.OrderAscending),
+			Filter:  bson.M{"$text": bson.M{"$search": "coffee"}},
+			Sort:    []string{"$textScore:score", "name", "_id"},
+			Document: bson.D{
+				{Name: "score", Value: bson.M{"$meta": "textScore"}},
+				{Name: "name", Value: 1},
+				{Name: "_id", Value: 1},
+			},
+		},
+		{
+			Name:    "no-search",
+			Request: textSearchRequest("", types.OrderDescending),
+			Filter:  bson.M{},
+			Sort:    []string{"name", "_id"},
+			Document: bson.D{
+				{Name: "name", Value: 1},
+				{Name: "_id", Value: 1},
+			},
+		},
+	}
+	for _, c := range cases {
+		if f := o.CreateFilter(c.Request); !reflect.DeepEqual(f, c.Filter) {
+			t.Errorf("case %s: filter does not match, want %+v, got %+v",
+				c.Name, c.Filter, f)
+		}
+		if s := o.SortFields(c.Request); !reflect.DeepEqual(s, c.Sort) {
+			t.Errorf("case %s: sort fields do not match, want %v, got %v",
+				c.Name, c.Sort, s)
+		}
+		if d := o.SortDocument(c.Request); !reflect.DeepEqual(d, c.Document) {
+			t.Errorf("case %s: sort document does not match, want %+v, got %+v",
+				c.Name, c.Document, d)
+		}
+	}
+	// Without text search the relevance column is a regular column.
+	r := textSearchRequest("coffee", types.OrderDescending)
+	want := []string{"-score", "name", "_id"}
+	if s := (FilterOptions{}).SortFields(r); !reflect.DeepEqual(s, want) {
+		t.Errorf("sort fields do not match, want %v, got %v", want, s)
+	}
+}
+
+func TestCollectionHandlerTextScoreSort(t *testing.T) {
+	q := &QueryMock{}
+	ch := &CollectionHandler{
+		Collection: &CollectionMock{query: q},
+		Filter:     FilterOptions{TextSearch: true, RelevanceColumn: "score"},
+	}
+	req := &http.Request{
+		Method: "GET",
+		URL:    &url.URL{Path: "/"},
+		Form: url.Values{
+			"draw":             []string{"1"},
+			"search[value]":    []string{"coffee"},
+			"order[0][column]": []string{"0"},
+			"order[0][dir]":    []string{"desc"},
+			"columns[0][data]": []string{"score"},
+		},
+	}
+	ch.ServeHTTP(httptest.NewRecorder(), req)
+	want := []string{"$textScore:score", "_id"}
+	if !reflect.DeepEqual(q.SortValue, want) {
+		t.Errorf("sort does not match, want %v, got %v", want, q.SortValue)
+	}
+}
+
+func TestCollectionHandlerRelevanceColumnAllowed(t *testing.T) {
+	q := &QueryMock{}
+	ch := &CollectionHandler{
+		Collection: &CollectionMock{query: q},
+		Filter:     FilterOptions{TextSearch: true, RelevanceColumn: "score"},
+		Fields:     []string{"name"},
+	}
+	req := &http.Request{
+		Method: "GET",
+		URL:    &url.URL{Path: "/"},
+		Form: url.Values{
+			"draw":             []string{"1"},
+			"search[value]":    []string{"coffee"},
+			"order[0][column]": []string{"0"},
+			"order[0][dir]":    []string{"desc"},
+			"columns[0][data]": []string{"score"},
+			"columns[1][data]": []string{"name"},
+		},
+	}
+	w := httptest.NewRecorder()
+	ch.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("unexpected status %d: %s", w.Code, w.Body)
+	}
+	want := []string{"$textScore:score", "_id"}
+	if !reflect.DeepEqual(q.SortValue, want) {
+		t.Errorf("sort does not match, want %v, got %v", want, q.SortValue)
+	}
+	if len(ch.Fields) != 1 {
+		t.Errorf("allowed fields modified: %v", ch.Fields)
+	}
+
+	// Other fields are still rejected.
+	req.Form.Set("columns[1][data]", "secret")
+	w = httptest.NewRecorder()
+	ch.ServeHTTP(w, req)
+	var dtResponse types.Response
+	if err := json.NewDecoder(w.Body).Decode(&dtResponse); err != nil {
+		t.Fatalf("could not unmarshal response: %v", err)
+	}
+	if dtResponse.Error == "" {
+		t.Errorf("expected an error for a field that is not allowed")
+	}
+	if strings.Contains(dtResponse.Error, "secret") {
+		t.Errorf("error echoes the column data: %s", dtResponse.Error)
+	}
+}
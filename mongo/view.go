@@ -0,0 +1,44 @@
+package mongo
+
+import (
+	"context"
+	"net/http"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+// viewKey is the context key of the ViewHandler serving a request.
+type viewKey struct{}
+
+// ViewHandler serves a view of the collection of a CollectionHandler with a
+// preset filter and default sort, like "Active Users" and "Archived Users"
+// of a users collection. Multiple views can share the CollectionHandler and
+// are registered under their own routes.
+type ViewHandler struct {
+	// Handler serves the requests of the view.
+	Handler *CollectionHandler
+	// Filter is combined with the filter of every request, so only the
+	// documents of the view are reachable. It is applied before the
+	// FilterHook of the Handler.
+	Filter bson.M
+	// Sort contains the sort fields, in the format used by
+	// *mgo.Query.Sort(), of requests without an order. It replaces the
+	// DefaultSort of the Handler.
+	Sort []string
+}
+
+// NewViewHandler returns a ViewHandler of the handler with the preset filter
+// and default sort.
+func NewViewHandler(ch *CollectionHandler, filter bson.M, sort ...string) *ViewHandler {
+	return &ViewHandler{
+		Handler: ch,
+		Filter:  filter,
+		Sort:    sort,
+	}
+}
+
+// ServeHTTP implements the http.Handler interface
+func (vh *ViewHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ctx := context.WithValue(r.Context(), viewKey{}, vh)
+	vh.Handler.ServeHTTP(w, r.WithContext(ctx))
+}
@@ -0,0 +1,121 @@
+package mongo
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/basvdlei/godatatables/types"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+func TestViewHandler(t *testing.T) {
+	query := &QueryMock{}
+	collection := &CollectionMock{query: query}
+	ch := &CollectionHandler{
+		Collection: collection,
+		FilterHook: func(f bson.M) bson.M {
+			return bson.M{"$and": []bson.M{f, {"tenant": "acme"}}}
+		},
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/users/active", NewViewHandler(ch,
+		bson.M{"active": true}, "name"))
+	mux.Handle("/users/archived", NewViewHandler(ch,
+		bson.M{"archived": true}, "-archivedAt"))
+	mux.Handle("/users", ch)
+	search := bson.M{"$or": []bson.M{
+		{"name": bson.RegEx{Pattern: "jo", Options: "i"}},
+	}}
+	cases := []struct {
+		Path   string
+		Query  string
+		Filter bson.M
+		Sort   []string
+	}{
+		{
+			Path:  "/users/active",
+			Query: "search[value]=jo&columns[0][data]=name&columns[0][searchable]=true",
+			Filter: bson.M{"$and": []bson.M{
+				{"$and": []bson.M{{"active": true}, search}},
+				{"tenant": "acme"},
+			}},
+			Sort: []string{"name", "_id"},
+		},
+		{
+			Path: "/users/archived",
+			Filter: bson.M{"$and": []bson.M{
+				{"$and": []bson.M{{"archived": true}, {}}},
+				{"tenant": "acme"},
+			}},
+			Sort: []string{"-archivedAt", "_id"},
+		},
+		{
+			// An explicit order takes precedence over the preset sort.
+			Path:  "/users/archived",
+			Query: "columns[0][data]=name&order[0][column]=0&order[0][dir]=asc",
+			Filter: bson.M{"$and": []bson.M{
+				{"$and": []bson.M{{"archived": true}, {}}},
+				{"tenant": "acme"},
+			}},
+			Sort: []string{"name", "_id"},
+		},
+		{
+			Path: "/users",
+			Filter: bson.M{"$and": []bson.M{
+				{},
+				{"tenant": "acme"},
+			}},
+			Sort: []string{"_id"},
+		},
+	}
+	for _, c := range cases {
+		collection.filters = nil
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, httptest.NewRequest("GET", c.Path+"?draw=1&"+c.Query, nil))
+		if w.Code != http.StatusOK {
+			t.Errorf("case %s: unexpected statuscode %d", c.Path, w.Code)
+		}
+		if !reflect.DeepEqual(collection.filters[0], c.Filter) {
+			t.Errorf("case %s: filter does not match, want %+v, got %+v",
+				c.Path, c.Filter, collection.filters[0])
+		}
+		if !reflect.DeepEqual(query.SortValue, c.Sort) {
+			t.Errorf("case %s: sort does not match, want %v, got %v",
+				c.Path, c.Sort, query.SortValue)
+		}
+	}
+}
+
+func TestViewHandlerRecordsTotal(t *testing.T) {
+	collection := &FixtureCollectionMock{docs: columnCountsFixture}
+	ch := &CollectionHandler{
+		Collection: collection,
+		CacheTotal: time.Hour,
+	}
+	views := map[string]http.Handler{
+		"london": NewViewHandler(ch, bson.M{"office": "London"}),
+		"tokyo":  NewViewHandler(ch, bson.M{"office": "Tokyo"}),
+		"all":    ch,
+	}
+	want := map[string]int{"london": 2, "tokyo": 1, "all": 5}
+	// The later draws get the total cached for their own view.
+	for _, draw := range []string{"1", "2"} {
+		for name, h := range views {
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, httptest.NewRequest("GET", "/?draw="+draw, nil))
+			var dtResponse types.Response
+			if err := json.NewDecoder(w.Body).Decode(&dtResponse); err != nil {
+				t.Fatalf("view %s: could not unmarshal response: %v", name, err)
+			}
+			if dtResponse.RecordsTotal != want[name] {
+				t.Errorf("view %s draw %s: records total does not match, want %d, got %d",
+					name, draw, want[name], dtResponse.RecordsTotal)
+			}
+		}
+	}
+}
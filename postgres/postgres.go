@@ -0,0 +1,291 @@
+// Package postgres provides Datatables handlers for PostgreSQL tables that
+// store their documents in a jsonb column.
+package postgres
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/basvdlei/godatatables/types"
+)
+
+// JSONBHandler provides a HTTP handler for a table with a jsonb column.
+//
+// The request columns are interpreted as key paths inside the jsonb column,
+// nested keys are separated by a dot (eg `address.city`). Only key paths that
+// are listed in Keys or JoinedColumns can be searched, sorted or returned.
+type JSONBHandler struct {
+	DB *sql.DB
+	// Table is the (optionally schema qualified) table name.
+	Table string
+	// Column is the name of the jsonb column.
+	Column string
+	// Keys contains the key paths that are allowed as columns.
+	Keys []string
+	// Joins are appended to the FROM clause as is, eg
+	// `LEFT JOIN departments ON departments.id = (people.doc->>'dept')::int`.
+	// Joins should not produce more than one row per record.
+	Joins []string
+	// JoinedColumns maps column data to (qualified) columns of the joined
+	// tables, eg `dept.name` to `departments.name`.
+	JoinedColumns map[string]string
+	// DefaultLength is the number of records returned when the request
+	// does not specify a length. Without it such requests return all
+	// records. Requests with an explicit length of zero only get the
+	// counts.
+	DefaultLength int
+}
+
+// NewJSONBHandler returns a JSONBHandler for the given table and jsonb column.
+func NewJSONBHandler(db *sql.DB, table, column string, keys ...string) *JSONBHandler {
+	return &JSONBHandler{
+		DB:     db,
+		Table:  table,
+		Column: column,
+		Keys:   keys,
+	}
+}
+
+// ServeHTTP implements the http.Handler interface
+func (h *JSONBHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	dtRequest, err := types.ParseURLValues(r.Form)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	dtRequest = dtRequest.WithDefaultLength(h.DefaultLength)
+	var dtResponse types.Response
+	dtResponse.Draw = dtRequest.Draw
+	q, args := h.CountQuery(dtRequest)
+	err = h.DB.QueryRow(q, args...).Scan(&dtResponse.RecordsFiltered)
+	if err != nil {
+		dtResponse.Error = err.Error()
+	}
+	err = h.DB.QueryRow(h.TotalQuery()).Scan(&dtResponse.RecordsTotal)
+	if err != nil {
+		dtResponse.Error = err.Error()
+	}
+	q, args = h.SelectQuery(dtRequest)
+	dtResponse.Data, err = h.ResponseData(dtRequest, q, args...)
+	if err != nil {
+		dtResponse.Error = err.Error()
+	}
+	e := json.NewEncoder(w)
+	err = e.Encode(&dtResponse)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
+
+// ResponseData runs the select query and returns the rows that can be used
+// in a Datatables Response.
+func (h *JSONBHandler) ResponseData(r types.Request, query string, args ...interface{}) (data []types.Row, err error) {
+	columns := h.columns(r)
+	rows, err := h.DB.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	values := make([]sql.NullString, len(columns))
+	dest := make([]interface{}, len(columns))
+	for i := range values {
+		dest[i] = &values[i]
+	}
+	data = []types.Row{}
+	for rows.Next() {
+		if err = rows.Scan(dest...); err != nil {
+			return nil, err
+		}
+		row := types.Row{Data: make(map[string]string, len(columns))}
+		for i, c := range columns {
+			row.Data[c] = values[i].String
+		}
+		data = append(data, row)
+	}
+	return data, rows.Err()
+}
+
+// TotalQuery returns the query counting all records in the table.
+func (h *JSONBHandler) TotalQuery() string {
+	return "SELECT count(*) FROM " + quoteIdent(h.Table)
+}
+
+// CountQuery returns the query counting the records that match the filter of
+// the Datatables Request.
+func (h *JSONBHandler) CountQuery(r types.Request) (query string, args []interface{}) {
+	where, args := h.Where(r)
+	return "SELECT count(*) FROM " + h.from() + where, args
+}
+
+// SelectQuery returns the query that selects the requested page of records.
+func (h *JSONBHandler) SelectQuery(r types.Request) (query string, args []interface{}) {
+	columns := h.columns(r)
+	fields := make([]string, len(columns))
+	for i, c := range columns {
+		fields[i] = h.expression(c)
+	}
+	if len(fields) == 0 {
+		fields = []string{"1"}
+	}
+	where, args := h.Where(r)
+	var b strings.Builder
+	b.WriteString("SELECT ")
+	b.WriteString(strings.Join(fields, ", "))
+	b.WriteString(" FROM ")
+	b.WriteString(h.from())
+	b.WriteString(where)
+	b.WriteString(h.OrderBy(r))
+	if r.Length >= 0 {
+		args = append(args, r.Length)
+		b.WriteString(" LIMIT $" + strconv.Itoa(len(args)))
+	}
+	args = append(args, r.Start)
+	b.WriteString(" OFFSET $" + strconv.Itoa(len(args)))
+	return b.String(), args
+}
+
+// Where returns the WHERE clause for the searches of the Datatables Request.
+// The clause is empty if there is nothing to search for.
+func (h *JSONBHandler) Where(r types.Request) (clause string, args []interface{}) {
+	var global []string
+	var column []string
+	for _, c := range r.Columns {
+		if !c.Searchable || !h.allowed(c.Data) {
+			continue
+		}
+		// Global search
+		if r.Search.Value != "" {
+			if len(args) == 0 {
+				args = append(args, searchArg(r.Search))
+			}
+			global = append(global, h.expression(c.Data)+
+				searchOperator(r.Search)+"$1")
+		}
+		// Column specific search
+		if c.Search.Value != "" {
+			args = append(args, searchArg(c.Search))
+			column = append(column, h.expression(c.Data)+
+				searchOperator(c.Search)+"$"+strconv.Itoa(len(args)))
+		}
+	}
+	var conditions []string
+	if len(global) > 0 {
+		conditions = append(conditions, "("+strings.Join(global, " OR ")+")")
+	}
+	conditions = append(conditions, column...)
+	if len(conditions) == 0 {
+		return "", nil
+	}
+	return " WHERE " + strings.Join(conditions, " AND "), args
+}
+
+// OrderBy returns the ORDER BY clause for the Datatables Request.
+func (h *JSONBHandler) OrderBy(r types.Request) string {
+	var order []string
+	for _, o := range r.Order {
+		c, ok := r.OrderColumn(o)
+		if !ok || !h.allowed(c.Data) {
+			continue
+		}
+		dir := " ASC"
+		if o.Dir == types.OrderDescending {
+			dir = " DESC"
+		}
+		order = append(order, "("+h.expression(c.Data)+")"+dir)
+	}
+	if len(order) == 0 {
+		return ""
+	}
+	return " ORDER BY " + strings.Join(order, ", ")
+}
+
+// columns returns the allowed column data fields of the Request.
+func (h *JSONBHandler) columns(r types.Request) []string {
+	columns := make([]string, 0, len(r.Columns))
+	for _, c := range r.Columns {
+		if h.allowed(c.Data) {
+			columns = append(columns, c.Data)
+		}
+	}
+	return columns
+}
+
+// from returns the table including the joins.
+func (h *JSONBHandler) from() string {
+	if len(h.Joins) == 0 {
+		return quoteIdent(h.Table)
+	}
+	return quoteIdent(h.Table) + " " + strings.Join(h.Joins, " ")
+}
+
+// allowed reports if the key path is one of the configured Keys or
+// JoinedColumns.
+func (h *JSONBHandler) allowed(key string) bool {
+	if _, ok := h.JoinedColumns[key]; ok {
+		return true
+	}
+	for _, k := range h.Keys {
+		if k == key {
+			return true
+		}
+	}
+	return false
+}
+
+// expression returns the text expression for a key path. Keys are embedded
+// as literals so the expression can use expression indexes.
+func (h *JSONBHandler) expression(key string) string {
+	if c, ok := h.JoinedColumns[key]; ok {
+		return quoteIdent(c) + "::text"
+	}
+	column := quoteIdent(h.Column)
+	if len(h.Joins) > 0 {
+		// Qualify the column since joined tables could use the same name.
+		column = quoteIdent(h.Table) + "." + column
+	}
+	path := strings.Split(key, ".")
+	if len(path) == 1 {
+		return column + "->>" + quoteLiteral(key)
+	}
+	return column + "#>>" + quoteLiteral("{"+strings.Join(path, ",")+"}")
+}
+
+// searchOperator returns the comparison operator for a search.
+func searchOperator(s types.Search) string {
+	if s.Regex {
+		return " ~* "
+	}
+	return " ILIKE "
+}
+
+// searchArg returns the query argument for a search.
+func searchArg(s types.Search) string {
+	if s.Regex {
+		return s.Value
+	}
+	return "%" + likeReplacer.Replace(s.Value) + "%"
+}
+
+// likeReplacer escapes the LIKE wildcard characters.
+var likeReplacer = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// quoteIdent quotes a (optionally schema qualified) identifier.
+func quoteIdent(s string) string {
+	parts := strings.Split(s, ".")
+	for i, p := range parts {
+		parts[i] = `"` + strings.Replace(p, `"`, `""`, -1) + `"`
+	}
+	return strings.Join(parts, ".")
+}
+
+// quoteLiteral quotes a string literal.
+func quoteLiteral(s string) string {
+	return "'" + strings.Replace(s, "'", "''", -1) + "'"
+}
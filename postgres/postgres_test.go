@@ -0,0 +1,276 @@
+package postgres
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/basvdlei/godatatables/types"
+)
+
+type QueryTestCase struct {
+	Request    types.Request
+	CountQuery string
+	CountArgs  []interface{}
+	Query      string
+	QueryArgs  []interface{}
+}
+
+var QueryTests = []QueryTestCase{
+	{
+		Request: types.Request{
+			Draw:   1,
+			Start:  0,
+			Length: 10,
+			Columns: []types.Column{
+				{Data: "name", Searchable: true, Orderable: true},
+				{Data: "address.city", Searchable: true, Orderable: true},
+			},
+		},
+		CountQuery: `SELECT count(*) FROM "people"`,
+		Query:      `SELECT "doc"->>'name', "doc"#>>'{address,city}' FROM "people" LIMIT $1 OFFSET $2`,
+		QueryArgs:  []interface{}{10, 0},
+	},
+	{
+		Request: types.Request{
+			Draw:   2,
+			Start:  20,
+			Length: 10,
+			Order: []types.Order{
+				{Column: 1, Dir: types.OrderDescending},
+				{Column: 0, Dir: types.OrderAscending},
+			},
+			Search: types.Search{Value: "50%"},
+			Columns: []types.Column{
+				{Data: "name", Searchable: true, Orderable: true},
+				{
+					Data:       "address.city",
+					Searchable: true,
+					Orderable:  true,
+					Search:     types.Search{Value: "^Ams", Regex: true},
+				},
+				{Data: "secret", Searchable: true, Orderable: true},
+			},
+		},
+		CountQuery: `SELECT count(*) FROM "people" WHERE ("doc"->>'name' ILIKE $1 OR "doc"#>>'{address,city}' ILIKE $1) AND "doc"#>>'{address,city}' ~* $2`,
+		CountArgs:  []interface{}{`%50\%%`, "^Ams"},
+		Query:      `SELECT "doc"->>'name', "doc"#>>'{address,city}' FROM "people" WHERE ("doc"->>'name' ILIKE $1 OR "doc"#>>'{address,city}' ILIKE $1) AND "doc"#>>'{address,city}' ~* $2 ORDER BY ("doc"#>>'{address,city}') DESC, ("doc"->>'name') ASC LIMIT $3 OFFSET $4`,
+		QueryArgs:  []interface{}{`%50\%%`, "^Ams", 10, 20},
+	},
+	{
+		Request: types.Request{
+			Draw:   3,
+			Start:  0,
+			Length: -1,
+			Columns: []types.Column{
+				{
+					Data:       "name",
+					Searchable: false,
+					Orderable:  true,
+					Search:     types.Search{Value: "ignored"},
+				},
+			},
+		},
+		CountQuery: `SELECT count(*) FROM "people"`,
+		Query:      `SELECT "doc"->>'name' FROM "people" OFFSET $1`,
+		QueryArgs:  []interface{}{0},
+	},
+}
+
+func newTestHandler() *JSONBHandler {
+	return NewJSONBHandler(nil, "people", "doc", "name", "address.city")
+}
+
+func TestCountQuery(t *testing.T) {
+	h := newTestHandler()
+	for i, c := range QueryTests {
+		q, args := h.CountQuery(c.Request)
+		if q != c.CountQuery {
+			t.Errorf("case %d: query does not match, want %s, got %s",
+				i, c.CountQuery, q)
+		}
+		if !reflect.DeepEqual(args, c.CountArgs) {
+			t.Errorf("case %d: args do not match, want %v, got %v",
+				i, c.CountArgs, args)
+		}
+	}
+}
+
+func TestSelectQuery(t *testing.T) {
+	h := newTestHandler()
+	for i, c := range QueryTests {
+		q, args := h.SelectQuery(c.Request)
+		if q != c.Query {
+			t.Errorf("case %d: query does not match, want %s, got %s",
+				i, c.Query, q)
+		}
+		if !reflect.DeepEqual(args, c.QueryArgs) {
+			t.Errorf("case %d: args do not match, want %v, got %v",
+				i, c.QueryArgs, args)
+		}
+	}
+}
+
+func TestJoinedColumns(t *testing.T) {
+	h := newTestHandler()
+	h.Joins = []string{`LEFT JOIN "departments" ON "departments"."id" = ("people"."doc"->>'dept_id')::int`}
+	h.JoinedColumns = map[string]string{
+		"dept.name": "departments.name",
+	}
+	r := types.Request{
+		Start:  0,
+		Length: 10,
+		Order: []types.Order{
+			{Column: 1, Dir: types.OrderAscending},
+		},
+		Columns: []types.Column{
+			{Data: "name", Searchable: true, Orderable: true},
+			{
+				Data:       "dept.name",
+				Searchable: true,
+				Orderable:  true,
+				Search:     types.Search{Value: "sales"},
+			},
+		},
+	}
+	want := `SELECT "people"."doc"->>'name', "departments"."name"::text FROM "people" LEFT JOIN "departments" ON "departments"."id" = ("people"."doc"->>'dept_id')::int WHERE "departments"."name"::text ILIKE $1 ORDER BY ("departments"."name"::text) ASC LIMIT $2 OFFSET $3`
+	wantArgs := []interface{}{"%sales%", 10, 0}
+	q, args := h.SelectQuery(r)
+	if q != want {
+		t.Errorf("query does not match, want %s, got %s", want, q)
+	}
+	if !reflect.DeepEqual(args, wantArgs) {
+		t.Errorf("args do not match, want %v, got %v", wantArgs, args)
+	}
+	wantCount := `SELECT count(*) FROM "people" LEFT JOIN "departments" ON "departments"."id" = ("people"."doc"->>'dept_id')::int WHERE "departments"."name"::text ILIKE $1`
+	if q, _ := h.CountQuery(r); q != wantCount {
+		t.Errorf("count query does not match, want %s, got %s", wantCount, q)
+	}
+}
+
+func TestJSONBHandlerServeHTTP(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	h := newTestHandler()
+	h.DB = db
+
+	mock.ExpectQuery(`SELECT count(*) FROM "people" WHERE ("doc"->>'name' ILIKE $1 OR "doc"#>>'{address,city}' ILIKE $1)`).
+		WithArgs("%ams%").
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(2))
+	mock.ExpectQuery(`SELECT count(*) FROM "people"`).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(57))
+	mock.ExpectQuery(`SELECT "doc"->>'name', "doc"#>>'{address,city}' FROM "people" WHERE ("doc"->>'name' ILIKE $1 OR "doc"#>>'{address,city}' ILIKE $1) ORDER BY ("doc"->>'name') ASC LIMIT $2 OFFSET $3`).
+		WithArgs("%ams%", 10, 0).
+		WillReturnRows(sqlmock.NewRows([]string{"name", "city"}).
+			AddRow("Airi", "Amsterdam").
+			AddRow("Dai", nil))
+
+	req := &http.Request{
+		Method: "GET",
+		URL:    &url.URL{Path: "/"},
+		Form: url.Values{
+			"draw":                   []string{"4"},
+			"start":                  []string{"0"},
+			"length":                 []string{"10"},
+			"search[value]":          []string{"ams"},
+			"order[0][column]":       []string{"0"},
+			"order[0][dir]":          []string{"asc"},
+			"columns[0][data]":       []string{"name"},
+			"columns[0][searchable]": []string{"true"},
+			"columns[1][data]":       []string{"address.city"},
+			"columns[1][searchable]": []string{"true"},
+		},
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	resp := w.Result()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("unexpected statuscode, want %d, got %d",
+			http.StatusOK, resp.StatusCode)
+	}
+	var dtResponse types.Response
+	if err := json.NewDecoder(resp.Body).Decode(&dtResponse); err != nil {
+		t.Fatalf("could not unmarshal response: %v", err)
+	}
+	want := types.Response{
+		Draw:            4,
+		RecordsTotal:    57,
+		RecordsFiltered: 2,
+		Data: []types.Row{
+			{Data: map[string]string{"name": "Airi", "address.city": "Amsterdam"}},
+			{Data: map[string]string{"name": "Dai", "address.city": ""}},
+		},
+	}
+	if !reflect.DeepEqual(dtResponse, want) {
+		t.Errorf("response does not match, want %+v, got %+v", want, dtResponse)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestJSONBHandlerDefaultLength(t *testing.T) {
+	cases := []struct {
+		Name          string
+		DefaultLength int
+		Length        []string
+		Select        string
+		Args          []driver.Value
+	}{
+		{
+			Name:   "absent",
+			Select: `SELECT "doc"->>'name' FROM "people" OFFSET $1`,
+			Args:   []driver.Value{0},
+		},
+		{
+			Name:          "absent-default",
+			DefaultLength: 25,
+			Select:        `SELECT "doc"->>'name' FROM "people" LIMIT $1 OFFSET $2`,
+			Args:          []driver.Value{25, 0},
+		},
+		{
+			Name:          "present-zero",
+			DefaultLength: 25,
+			Length:        []string{"0"},
+			Select:        `SELECT "doc"->>'name' FROM "people" LIMIT $1 OFFSET $2`,
+			Args:          []driver.Value{0, 0},
+		},
+	}
+	for _, c := range cases {
+		db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+		if err != nil {
+			t.Fatal(err)
+		}
+		h := newTestHandler()
+		h.DB = db
+		h.DefaultLength = c.DefaultLength
+		mock.ExpectQuery(`SELECT count(*) FROM "people"`).
+			WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(57))
+		mock.ExpectQuery(`SELECT count(*) FROM "people"`).
+			WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(57))
+		mock.ExpectQuery(c.Select).
+			WithArgs(c.Args...).
+			WillReturnRows(sqlmock.NewRows([]string{"name"}))
+		form := url.Values{
+			"draw":             []string{"1"},
+			"columns[0][data]": []string{"name"},
+		}
+		if c.Length != nil {
+			form["length"] = c.Length
+		}
+		req := &http.Request{Method: "GET", URL: &url.URL{Path: "/"}, Form: form}
+		h.ServeHTTP(httptest.NewRecorder(), req)
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("case %s: %v", c.Name, err)
+		}
+		db.Close()
+	}
+}
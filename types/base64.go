@@ -0,0 +1,44 @@
+package types
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrInvalidBase64 is returned when a base64 encoded request can't be
+// decoded. The details are left out so the value is never echoed.
+var ErrInvalidBase64 = errors.New("invalid base64 request")
+
+// ParseBase64 parses a base64 encoded JSON Request, as sent in a single
+// parameter by clients whose query strings of the url.Values would exceed
+// URL length limits. Both the standard and the URL-safe alphabet are
+// accepted, with or without padding.
+func ParseBase64(s string) (r Request, err error) {
+	b, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		b, err = base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+	}
+	if err != nil {
+		return r, fmt.Errorf("%w: encoding", ErrInvalidBase64)
+	}
+	var raw struct {
+		Request
+		Length *int `json:"length"`
+	}
+	if err := json.Unmarshal(b, &raw); err != nil {
+		return r, fmt.Errorf("%w: json", ErrInvalidBase64)
+	}
+	r = raw.Request
+	if raw.Length != nil {
+		r.Length, r.HasLength = *raw.Length, true
+	}
+	for _, o := range r.Order {
+		if o.Column < 0 {
+			return Request{}, fmt.Errorf("%w: order", ErrInvalidIndex)
+		}
+	}
+	return r, nil
+}
@@ -0,0 +1,72 @@
+package types
+
+import (
+	"encoding/base64"
+	"errors"
+	"net/url"
+	"reflect"
+	"testing"
+)
+
+func TestParseBase64(t *testing.T) {
+	form, err := url.ParseQuery("draw=4&start=10&length=25" +
+		"&search[value]=foo&search[regex]=false" +
+		"&order[0][column]=1&order[0][dir]=desc" +
+		"&columns[0][data]=name&columns[0][name]=&columns[0][searchable]=true" +
+		"&columns[0][orderable]=true&columns[0][search][value]=" +
+		"&columns[0][search][regex]=false" +
+		"&columns[1][data]=office&columns[1][name]=&columns[1][searchable]=true" +
+		"&columns[1][orderable]=true&columns[1][search][value]=%5ET" +
+		"&columns[1][search][regex]=true")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := ParseURLValues(form)
+	if err != nil {
+		t.Fatal(err)
+	}
+	blob := `{"draw":4,"start":10,"length":25,` +
+		`"search":{"value":"foo","regex":false},` +
+		`"order":[{"column":1,"dir":"desc"}],` +
+		`"columns":[` +
+		`{"data":"name","name":"","searchable":true,"orderable":true,` +
+		`"search":{"value":"","regex":false}},` +
+		`{"data":"office","name":"","searchable":true,"orderable":true,` +
+		`"search":{"value":"^T","regex":true}}]}`
+	for name, enc := range map[string]*base64.Encoding{
+		"std":     base64.StdEncoding,
+		"raw-url": base64.RawURLEncoding,
+	} {
+		r, err := ParseBase64(enc.EncodeToString([]byte(blob)))
+		if err != nil {
+			t.Fatalf("case %s: %v", name, err)
+		}
+		if !reflect.DeepEqual(r, want) {
+			t.Errorf("case %s: request does not match, want %+v, got %+v",
+				name, want, r)
+		}
+	}
+}
+
+func TestParseBase64Invalid(t *testing.T) {
+	cases := map[string]struct {
+		Value string
+		Err   error
+	}{
+		"encoding": {Value: "not base64!", Err: ErrInvalidBase64},
+		"json": {
+			Value: base64.StdEncoding.EncodeToString([]byte(`{"draw":`)),
+			Err:   ErrInvalidBase64,
+		},
+		"negative-order": {
+			Value: base64.StdEncoding.EncodeToString(
+				[]byte(`{"order":[{"column":-1}]}`)),
+			Err: ErrInvalidIndex,
+		},
+	}
+	for name, c := range cases {
+		if _, err := ParseBase64(c.Value); !errors.Is(err, c.Err) {
+			t.Errorf("case %s: want error %v, got %v", name, c.Err, err)
+		}
+	}
+}
@@ -1,27 +1,64 @@
 package types
 
 import (
+	"crypto/sha1"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"net/url"
-	"regexp"
 	"strconv"
 	"strings"
 )
 
 var (
-	// columnRegexp is the column urlvalue regexp (1=id 2=field 3=subfields)
-	columnRegexp = regexp.MustCompile(`(?U)^columns\[([0-9]+)\]\[(.+)\](.*)$`)
-	// searchRegexp is the search urlvalue regexp (1=field)
-	searchRegexp = regexp.MustCompile(`(?U)^search\[(.+)\]$`)
-	// orderRegexp is the order urlvalue regexp (1=id 2=field)
-	orderRegexp = regexp.MustCompile(`(?U)^order\[([0-9]+)\]\[(.+)\]$`)
-
 	// ErrNotEnoughFields is returned when the urlvalues does not contain
 	// enough fields to parse.
 	ErrNotEnoughFields = errors.New("not enough fields")
+	// ErrInvalidNumber is returned when a value that should be an integer
+	// is not.
+	ErrInvalidNumber = errors.New("invalid number")
+	// ErrInvalidIndex is returned when an urlvalue key contains a
+	// negative index, or an index that is not less than the number of
+	// urlvalues.
+	ErrInvalidIndex = errors.New("invalid index")
+	// ErrDuplicateValue is returned in strict mode when the urlvalues
+	// contain different values for the same key.
+	ErrDuplicateValue = errors.New("duplicate value")
 )
 
+// rowMetaKeys are the keys of the optional DT_ row properties.
+var rowMetaKeys = []string{"DT_RowId", "DT_RowClass", "DT_RowData", "DT_RowAttr"}
+
+// ExtractRowMeta returns a Row with the DT_ properties found in m and the
+// remaining data, which is set as the Row data as well. DT_RowData and
+// DT_RowAttr are expected to contain JSON objects, other values are kept in
+// the remaining data. The map m is not modified.
+func ExtractRowMeta(m map[string]string) (Row, map[string]string) {
+	var r Row
+	data := make(map[string]string, len(m))
+	for k, v := range m {
+		switch k {
+		case "DT_RowId":
+			r.RowID = v
+		case "DT_RowClass":
+			r.RowClass = v
+		case "DT_RowData":
+			if json.Unmarshal([]byte(v), &r.RowData) != nil {
+				data[k] = v
+			}
+		case "DT_RowAttr":
+			if json.Unmarshal([]byte(v), &r.RowAttr) != nil {
+				data[k] = v
+			}
+		default:
+			data[k] = v
+		}
+	}
+	r.Data = data
+	return r, data
+}
+
 // UnmarshalJSON implements the json.Unmarshaler interface.
 func (r *Row) UnmarshalJSON(in []byte) error {
 	// Try to parse rowdata as an array first
@@ -56,7 +93,7 @@ func (r *Row) UnmarshalJSON(in []byte) error {
 	if err != nil {
 		return err
 	}
-	for _, v := range []string{"DT_RowId", "DT_RowClass", "DT_RowData", "DT_RowAttr"} {
+	for _, v := range rowMetaKeys {
 		delete(data, v)
 	}
 	r.Data = data
@@ -65,6 +102,13 @@ func (r *Row) UnmarshalJSON(in []byte) error {
 
 // MarshalJSON implements the json.Marshaler interface.
 func (r Row) MarshalJSON() ([]byte, error) {
+	if r.Columns != nil {
+		a := make([]string, len(r.Columns))
+		for i, k := range r.Columns {
+			a[i] = r.Data[k]
+		}
+		return json.Marshal(a)
+	}
 	c := make(map[string]interface{})
 	for k, v := range r.Data {
 		c[k] = v
@@ -84,25 +128,134 @@ func (r Row) MarshalJSON() ([]byte, error) {
 	return json.Marshal(&c)
 }
 
-// ParseURLValues parses http request url.Values into a Request.
+// StringCountersResponse is a Response that encodes the draw and records
+// counters as strings, as expected by some DataTables plugins.
+type StringCountersResponse Response
+
+// stringCountersResponse is the JSON representation of a
+// StringCountersResponse. The counters shadow the ones of the embedded
+// Response.
+type stringCountersResponse struct {
+	Draw            int `json:"draw,string"`
+	RecordsTotal    int `json:"recordsTotal,string"`
+	RecordsFiltered int `json:"recordsFiltered,string"`
+	*Response
+}
+
+// MarshalJSON implements the json.Marshaler interface.
+func (r StringCountersResponse) MarshalJSON() ([]byte, error) {
+	return json.Marshal(&stringCountersResponse{
+		Draw:            r.Draw,
+		RecordsTotal:    r.RecordsTotal,
+		RecordsFiltered: r.RecordsFiltered,
+		Response:        (*Response)(&r),
+	})
+}
+
+// UnmarshalJSON implements the json.Unmarshaler interface.
+func (r *StringCountersResponse) UnmarshalJSON(in []byte) error {
+	c := stringCountersResponse{Response: (*Response)(r)}
+	if err := json.Unmarshal(in, &c); err != nil {
+		return err
+	}
+	r.Draw = c.Draw
+	r.RecordsTotal = c.RecordsTotal
+	r.RecordsFiltered = c.RecordsFiltered
+	return nil
+}
+
+// Nested encodes the Value nested under the dot separated Path, for front
+// ends with a custom ajax dataSrc. Eg a Path of `result` encodes a Response
+// as {"result":{"draw":1,...}}. An empty Path encodes the Value as is.
+type Nested struct {
+	Path  string
+	Value interface{}
+}
+
+// MarshalJSON implements the json.Marshaler interface.
+func (n Nested) MarshalJSON() ([]byte, error) {
+	v := n.Value
+	if n.Path != "" {
+		keys := strings.Split(n.Path, ".")
+		for i := len(keys) - 1; i >= 0; i-- {
+			v = map[string]interface{}{keys[i]: v}
+		}
+	}
+	return json.Marshal(v)
+}
+
+// ETag returns a weak entity tag of the Response content. The draw counter is
+// excluded since it changes with every request.
+func (r Response) ETag() (string, error) {
+	r.Draw = 0
+	b, err := json.Marshal(&r)
+	if err != nil {
+		return "", err
+	}
+	sum := sha1.Sum(b)
+	return `W/"` + hex.EncodeToString(sum[:]) + `"`, nil
+}
+
+// CacheKey returns a key identifying the records selected by the Request, for
+// caching or deduplicating queries. The draw counter is excluded since it
+// changes with every request.
+func (r Request) CacheKey() (string, error) {
+	r.Draw = 0
+	b, err := json.Marshal(struct {
+		Request
+		HasLength bool `json:"hasLength"`
+	}{r, r.HasLength})
+	if err != nil {
+		return "", err
+	}
+	sum := sha1.Sum(b)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// ParseURLValues parses http request url.Values into a Request. When a key
+// has multiple values the last one is used.
 func ParseURLValues(u url.Values) (r Request, err error) {
-	for k, v := range u {
-		if len(v) < 1 {
+	return parseURLValues(u, false)
+}
+
+// ParseURLValuesStrict parses http request url.Values into a Request like
+// ParseURLValues, but returns ErrDuplicateValue when a key has multiple
+// different values.
+func ParseURLValuesStrict(u url.Values) (r Request, err error) {
+	return parseURLValues(u, true)
+}
+
+// parseURLValues parses the url.Values using the last value of each key, or
+// fails on different values in strict mode.
+func parseURLValues(u url.Values, strict bool) (r Request, err error) {
+	n := len(u)
+	for k, values := range u {
+		if len(values) < 1 {
 			continue
 		}
-		switch true {
-		case k == "draw":
-			r.Draw, err = strconv.Atoi(v[0])
-		case k == "start":
-			r.Start, err = strconv.Atoi(v[0])
-		case k == "length":
-			r.Length, err = strconv.Atoi(v[0])
-		case strings.HasPrefix(k, "search"):
-			r.Search, err = parseSearch(r.Search, k, v[0])
-		case strings.HasPrefix(k, "order"):
-			r.Order, err = parseOrder(r.Order, k, v[0])
-		case strings.HasPrefix(k, "column"):
-			r.Columns, err = parseColumn(r.Columns, k, v[0])
+		if strict {
+			for _, v := range values[1:] {
+				if v != values[0] {
+					return r, fmt.Errorf("%w: %s", ErrDuplicateValue, k)
+				}
+			}
+		}
+		v := values[len(values)-1]
+		root, path := parseKey(k)
+		switch root {
+		case "draw":
+			r.Draw, err = parseInt(v, "draw")
+		case "start":
+			r.Start, err = parseInt(v, "start")
+		case "length":
+			r.Length, err = parseInt(v, "length")
+			r.HasLength = true
+		case "search":
+			r.Search, err = parseSearch(r.Search, path, v, n)
+		case "order":
+			r.Order, err = parseOrder(r.Order, path, v, n)
+		case "columns":
+			r.Columns, err = parseColumn(r.Columns, path, v, n)
 		}
 		if err != nil {
 			return
@@ -111,14 +264,57 @@ func ParseURLValues(u url.Values) (r Request, err error) {
 	return
 }
 
+// parseKey splits an urlvalue key into the root and the bracketed path
+// segments, eg `columns[0][search][value]` into "columns" and
+// ["0" "search" "value"]. The path is nil for keys that are not a sequence of
+// bracketed segments after the root.
+func parseKey(k string) (root string, path []string) {
+	i := strings.IndexByte(k, '[')
+	if i < 0 {
+		return k, nil
+	}
+	root, rest := k[:i], k[i:]
+	for rest != "" {
+		j := strings.IndexByte(rest, ']')
+		if rest[0] != '[' || j < 0 {
+			return root, nil
+		}
+		path = append(path, rest[1:j])
+		rest = rest[j+1:]
+	}
+	return root, path
+}
+
+// parseInt parses the integer value of the named parameter. The error does
+// not contain the value, since it is sent by the client and the error can end
+// up in the response.
+func parseInt(v, name string) (int, error) {
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		return 0, fmt.Errorf("%w: %s", ErrInvalidNumber, name)
+	}
+	return n, nil
+}
+
+// parseIndex parses the index of an urlvalue key path segment. Since every
+// column, order and fixed search has at least one urlvalue, the index must be
+// less than the number of urlvalues n. This bounds the allocated slices by
+// the size of the request.
+func parseIndex(s string, n int) (int, error) {
+	id, err := parseInt(s, "index")
+	if err == nil && (id < 0 || id >= n) {
+		return 0, ErrInvalidIndex
+	}
+	return id, err
+}
+
 // parseOrder parses the order urlvalue fields.
 // eg `order[0][...]`
-func parseOrder(o []Order, k, v string) (out []Order, err error) {
-	m := orderRegexp.FindStringSubmatch(k)
-	if len(m) < 3 {
+func parseOrder(o []Order, path []string, v string, n int) (out []Order, err error) {
+	if len(path) < 2 {
 		return o, ErrNotEnoughFields
 	}
-	id, err := strconv.Atoi(m[1])
+	id, err := parseIndex(path[0], n)
 	if err != nil {
 		return nil, err
 	}
@@ -128,9 +324,11 @@ func parseOrder(o []Order, k, v string) (out []Order, err error) {
 	} else {
 		out = o
 	}
-	switch m[2] {
+	switch path[1] {
 	case "column":
-		out[id].Column, err = strconv.Atoi(v)
+		out[id].Column, err = parseInt(v, "order column")
+	case "name":
+		out[id].Name = v
 	case "dir":
 		if v == "asc" {
 			out[id].Dir = OrderAscending
@@ -142,14 +340,13 @@ func parseOrder(o []Order, k, v string) (out []Order, err error) {
 }
 
 // parseSearch parses the search urlvalue fields.
-// eg `search[i][...]`
-func parseSearch(s Search, k, v string) (out Search, err error) {
-	m := searchRegexp.FindStringSubmatch(k)
-	if len(m) < 2 {
+// eg `search[...]`
+func parseSearch(s Search, path []string, v string, n int) (out Search, err error) {
+	if len(path) < 1 {
 		return s, ErrNotEnoughFields
 	}
 	out = s
-	switch m[1] {
+	switch path[0] {
 	case "value":
 		out.Value = v
 	case "regex":
@@ -158,19 +355,44 @@ func parseSearch(s Search, k, v string) (out Search, err error) {
 		} else {
 			out.Regex = false
 		}
+	case "fixed":
+		if len(path) < 3 {
+			return s, ErrNotEnoughFields
+		}
+		out.Fixed, err = parseFixed(s.Fixed, path[1], path[2], v, n)
+	}
+	return
+}
 
+// parseFixed parses the fixed search urlvalue fields.
+// eg `search[fixed][i][...]`
+func parseFixed(in []FixedSearch, i, k, v string, n int) (out []FixedSearch, err error) {
+	id, err := parseIndex(i, n)
+	if err != nil {
+		return in, err
+	}
+	if id+1 > len(in) {
+		out = make([]FixedSearch, id+1)
+		copy(out, in)
+	} else {
+		out = in
+	}
+	switch k {
+	case "name":
+		out[id].Name = v
+	case "term":
+		out[id].Term = v
 	}
 	return
 }
 
 // parseColumn parses the column urlvalue fields.
-// eg `cloumns[i][...]
-func parseColumn(in []Column, k, v string) (out []Column, err error) {
-	m := columnRegexp.FindStringSubmatch(k)
-	if len(m) < 2 {
+// eg `columns[i][...]`
+func parseColumn(in []Column, path []string, v string, n int) (out []Column, err error) {
+	if len(path) < 2 {
 		return in, ErrNotEnoughFields
 	}
-	id, err := strconv.Atoi(m[1])
+	id, err := parseIndex(path[0], n)
 	if err != nil {
 		return in, err
 	}
@@ -181,7 +403,7 @@ func parseColumn(in []Column, k, v string) (out []Column, err error) {
 		out = in
 	}
 
-	switch m[2] {
+	switch path[1] {
 	case "data":
 		out[id].Data = v
 	case "name":
@@ -198,9 +420,12 @@ func parseColumn(in []Column, k, v string) (out []Column, err error) {
 		} else {
 			out[id].Orderable = false
 		}
+	case "visible":
+		visible := v == "true"
+		out[id].Visible = &visible
 	case "search":
-		if len(m) > 3 {
-			out[id].Search, err = parseSearch(out[id].Search, "search"+m[3], v)
+		if len(path) > 2 {
+			out[id].Search, err = parseSearch(out[id].Search, path[2:], v, n)
 		}
 	}
 	return
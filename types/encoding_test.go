@@ -2,8 +2,10 @@ package types
 
 import (
 	"encoding/json"
+	"errors"
 	"net/url"
 	"reflect"
+	"strings"
 	"testing"
 )
 
@@ -227,6 +229,50 @@ func TestMarshalResponse(t *testing.T) {
 	}
 }
 
+func TestMarshalStringCountersResponse(t *testing.T) {
+	for _, v := range marshalRespTests {
+		out, err := json.Marshal(StringCountersResponse(v.Input))
+		if err != nil {
+			t.Errorf("case %s: error %v", v.Name, err)
+		}
+		want := `{"draw":"5","recordsTotal":"2","recordsFiltered":"2","data":[`
+		if !strings.HasPrefix(string(out), want) {
+			t.Errorf("case %s: want prefix: %s, got %s", v.Name, want, out)
+		}
+		var r StringCountersResponse
+		err = json.Unmarshal(out, &r)
+		if err != nil {
+			t.Errorf("case %s: could not unmarshal, marshaled response: %v",
+				v.Name, err)
+		}
+		if !reflect.DeepEqual(Response(r), v.Input) {
+			t.Errorf("case %s: want: %+v, got %+v",
+				v.Name, v.Input, r)
+		}
+	}
+}
+
+func TestMarshalNested(t *testing.T) {
+	cases := []struct {
+		Path  string
+		Value interface{}
+		Want  string
+	}{
+		{Path: "", Value: Response{Draw: 1}, Want: `{"draw":1,"recordsTotal":0,"recordsFiltered":0,"data":null}`},
+		{Path: "result", Value: Response{Draw: 1}, Want: `{"result":{"draw":1,"recordsTotal":0,"recordsFiltered":0,"data":null}}`},
+		{Path: "a.b", Value: []Row{}, Want: `{"a":{"b":[]}}`},
+	}
+	for _, c := range cases {
+		out, err := json.Marshal(Nested{Path: c.Path, Value: c.Value})
+		if err != nil {
+			t.Errorf("case %q: error %v", c.Path, err)
+		}
+		if string(out) != c.Want {
+			t.Errorf("case %q: want %s, got %s", c.Path, c.Want, out)
+		}
+	}
+}
+
 type unmarshalReqTestCase struct {
 	Name   string
 	Input  string
@@ -457,8 +503,9 @@ var decTests = []decTestCase{
 					Dir:    OrderAscending,
 				},
 			},
-			Start:  0,
-			Length: 10,
+			Start:     0,
+			Length:    10,
+			HasLength: true,
 			Search: Search{
 				Value: "t",
 				Regex: false,
@@ -478,3 +525,369 @@ func TestParseURLValues(t *testing.T) {
 	}
 
 }
+
+func TestResponseETag(t *testing.T) {
+	a := marshalRespTests[0].Input
+	b := a
+	b.Draw = a.Draw + 1
+	tagA, err := a.ETag()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tagB, err := b.ETag()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tagA != tagB {
+		t.Errorf("etag depends on draw: %s != %s", tagA, tagB)
+	}
+	if !strings.HasPrefix(tagA, `W/"`) {
+		t.Errorf("etag is not weak: %s", tagA)
+	}
+	c := a
+	c.RecordsFiltered = a.RecordsFiltered - 1
+	tagC, err := c.ETag()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tagA == tagC {
+		t.Errorf("etag does not change with content: %s", tagC)
+	}
+}
+
+func TestRequestCacheKey(t *testing.T) {
+	a := Request{
+		Draw:   1,
+		Length: 10,
+		Search: Search{Value: "foo"},
+		Columns: []Column{
+			{Data: "name", Searchable: true},
+		},
+	}
+	b := a
+	b.Draw = 2
+	keyA, err := a.CacheKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyB, err := b.CacheKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if keyA != keyB {
+		t.Errorf("cache key depends on draw: %s != %s", keyA, keyB)
+	}
+	for name, c := range map[string]Request{
+		"search":     {Draw: 1, Length: 10, Search: Search{Value: "bar"}, Columns: a.Columns},
+		"has-length": {Draw: 1, Length: 10, HasLength: true, Search: a.Search, Columns: a.Columns},
+	} {
+		key, err := c.CacheKey()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if key == keyA {
+			t.Errorf("case %s: cache key does not change: %s", name, key)
+		}
+	}
+}
+
+func TestParseURLValuesOrderName(t *testing.T) {
+	r, err := ParseURLValues(url.Values{
+		"columns[0][data]": []string{"first_name"},
+		"columns[0][name]": []string{"first"},
+		"columns[1][data]": []string{"last_name"},
+		"columns[1][name]": []string{"last"},
+		"order[0][name]":   []string{"last"},
+		"order[0][dir]":    []string{"desc"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Order{
+		{
+			Name: "last",
+			Dir:  OrderDescending,
+		},
+	}
+	if !reflect.DeepEqual(r.Order, want) {
+		t.Errorf("want %+v, got %+v", want, r.Order)
+	}
+	c, ok := r.OrderColumn(r.Order[0])
+	if !ok || c.Data != "last_name" {
+		t.Errorf("order column does not match, want %s, got %+v (%v)",
+			"last_name", c, ok)
+	}
+}
+
+func TestParseURLValuesDuplicates(t *testing.T) {
+	u := url.Values{
+		"draw":             []string{"1", "1"},
+		"columns[0][data]": []string{"first_name", "last_name"},
+	}
+	r, err := ParseURLValues(u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Columns[0].Data != "last_name" {
+		t.Errorf("lenient: want last value %s, got %s",
+			"last_name", r.Columns[0].Data)
+	}
+	if _, err := ParseURLValuesStrict(u); !errors.Is(err, ErrDuplicateValue) {
+		t.Errorf("strict: want %v, got %v", ErrDuplicateValue, err)
+	}
+	delete(u, "columns[0][data]")
+	r, err = ParseURLValuesStrict(u)
+	if err != nil {
+		t.Errorf("strict: unexpected error for equal values: %v", err)
+	}
+	if r.Draw != 1 {
+		t.Errorf("strict: draw does not match, want %d, got %d", 1, r.Draw)
+	}
+}
+
+func TestExtractRowMeta(t *testing.T) {
+	m := map[string]string{
+		"name":        "Airi",
+		"DT_RowId":    "row_1",
+		"DT_RowClass": "highlight",
+		"DT_RowData":  `{"pkey":"1"}`,
+		"DT_RowAttr":  "invalid",
+	}
+	row, data := ExtractRowMeta(m)
+	wantData := map[string]string{
+		"name":       "Airi",
+		"DT_RowAttr": "invalid",
+	}
+	want := Row{
+		Data:     wantData,
+		RowID:    "row_1",
+		RowClass: "highlight",
+		RowData:  map[string]string{"pkey": "1"},
+	}
+	if !reflect.DeepEqual(row, want) {
+		t.Errorf("row does not match, want %+v, got %+v", want, row)
+	}
+	if !reflect.DeepEqual(data, wantData) {
+		t.Errorf("data does not match, want %+v, got %+v", wantData, data)
+	}
+	if len(m) != 5 {
+		t.Errorf("input map modified: %+v", m)
+	}
+}
+
+func TestParseURLValuesFixedSearch(t *testing.T) {
+	r, err := ParseURLValues(url.Values{
+		"search[value]":                      []string{"foo"},
+		"search[fixed][0][name]":             []string{"region"},
+		"search[fixed][0][term]":             []string{"emea"},
+		"search[fixed][1][name]":             []string{"status"},
+		"search[fixed][1][term]":             []string{"active"},
+		"columns[0][data]":                   []string{"name"},
+		"columns[0][search][fixed][0][name]": []string{"initial"},
+		"columns[0][search][fixed][0][term]": []string{"a"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Search{
+		Value: "foo",
+		Fixed: []FixedSearch{
+			{Name: "region", Term: "emea"},
+			{Name: "status", Term: "active"},
+		},
+	}
+	if !reflect.DeepEqual(r.Search, want) {
+		t.Errorf("search does not match, want %+v, got %+v", want, r.Search)
+	}
+	wantColumn := []FixedSearch{{Name: "initial", Term: "a"}}
+	if !reflect.DeepEqual(r.Columns[0].Search.Fixed, wantColumn) {
+		t.Errorf("column fixed search does not match, want %+v, got %+v",
+			wantColumn, r.Columns[0].Search.Fixed)
+	}
+}
+
+func TestParseURLValuesLength(t *testing.T) {
+	cases := []struct {
+		Name      string
+		Values    url.Values
+		Length    int
+		HasLength bool
+	}{
+		{Name: "absent", Values: url.Values{}, Length: 0, HasLength: false},
+		{Name: "zero", Values: url.Values{"length": []string{"0"}}, Length: 0, HasLength: true},
+		{Name: "positive", Values: url.Values{"length": []string{"10"}}, Length: 10, HasLength: true},
+	}
+	for _, c := range cases {
+		r, err := ParseURLValues(c.Values)
+		if err != nil {
+			t.Fatalf("case %s: %v", c.Name, err)
+		}
+		if r.Length != c.Length || r.HasLength != c.HasLength {
+			t.Errorf("case %s: want length %d (%v), got %d (%v)",
+				c.Name, c.Length, c.HasLength, r.Length, r.HasLength)
+		}
+	}
+}
+
+func TestParseURLValuesVisible(t *testing.T) {
+	r, err := ParseURLValues(url.Values{
+		"columns[0][data]":       []string{"name"},
+		"columns[0][searchable]": []string{"true"},
+		"columns[0][visible]":    []string{"true"},
+		"columns[1][data]":       []string{"notes"},
+		"columns[1][searchable]": []string{"true"},
+		"columns[1][visible]":    []string{"false"},
+		"columns[2][data]":       []string{"city"},
+		"columns[2][searchable]": []string{"true"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []bool{true, false, true}
+	for i, c := range r.Columns {
+		if c.GlobalSearchable() != want[i] {
+			t.Errorf("column %s: want globally searchable %v, got %v",
+				c.Data, want[i], c.GlobalSearchable())
+		}
+	}
+	if r.Columns[2].Visible != nil {
+		t.Errorf("column city: unexpected visibility %v", *r.Columns[2].Visible)
+	}
+}
+
+func TestParseKey(t *testing.T) {
+	cases := []struct {
+		Key  string
+		Root string
+		Path []string
+	}{
+		{Key: "draw", Root: "draw"},
+		{Key: "search[value]", Root: "search", Path: []string{"value"}},
+		{Key: "order[0][dir]", Root: "order", Path: []string{"0", "dir"}},
+		{
+			Key:  "columns[0][search][value]",
+			Root: "columns",
+			Path: []string{"0", "search", "value"},
+		},
+		{
+			Key:  "columns[12][search][fixed][3][term]",
+			Root: "columns",
+			Path: []string{"12", "search", "fixed", "3", "term"},
+		},
+		{Key: "columns[0][]", Root: "columns", Path: []string{"0", ""}},
+		{Key: "columns[0][data", Root: "columns"},
+		{Key: "columns[0]data", Root: "columns"},
+		{Key: "columns[0][data]x", Root: "columns"},
+		{Key: "columns[0]]", Root: "columns"},
+		{Key: "[0]", Root: "", Path: []string{"0"}},
+	}
+	for _, c := range cases {
+		root, path := parseKey(c.Key)
+		if root != c.Root || !reflect.DeepEqual(path, c.Path) {
+			t.Errorf("case %s: want %q %q, got %q %q",
+				c.Key, c.Root, c.Path, root, path)
+		}
+	}
+}
+
+func TestParseURLValuesMalformedKeys(t *testing.T) {
+	cases := []struct {
+		Key string
+		Err error
+	}{
+		{Key: "columns[0]", Err: ErrNotEnoughFields},
+		{Key: "columns[0][data", Err: ErrNotEnoughFields},
+		{Key: "order[1]", Err: ErrNotEnoughFields},
+		{Key: "search", Err: ErrNotEnoughFields},
+		{Key: "search[fixed][0]", Err: ErrNotEnoughFields},
+		{Key: "columns[-1][data]", Err: ErrInvalidIndex},
+		{Key: "order[-1][dir]", Err: ErrInvalidIndex},
+		{Key: "search[fixed][-1][name]", Err: ErrInvalidIndex},
+		{Key: "columns[999999999][data]", Err: ErrInvalidIndex},
+		{Key: "order[9223372036854775807][dir]", Err: ErrInvalidIndex},
+		{Key: "search[fixed][1][name]", Err: ErrInvalidIndex},
+		{Key: "columns[0][search][fixed][1][term]", Err: ErrInvalidIndex},
+		{Key: "columns[99999999999999999999][data]", Err: ErrInvalidNumber},
+		{Key: "_", Err: nil},
+		{Key: "columnsX[0][data]", Err: nil},
+	}
+	for _, c := range cases {
+		_, err := ParseURLValues(url.Values{c.Key: []string{"x"}})
+		if !errors.Is(err, c.Err) {
+			t.Errorf("case %s: unexpected error, want %v, got %v",
+				c.Key, c.Err, err)
+		}
+	}
+}
+
+func TestNonIntegerDraw(t *testing.T) {
+	const script = "<script>alert(1)</script>"
+	_, err := ParseURLValues(url.Values{"draw": []string{script}})
+	if !errors.Is(err, ErrInvalidNumber) || strings.Contains(err.Error(), "script") {
+		t.Errorf("unexpected error for url values: %v", err)
+	}
+	for _, in := range []string{
+		`{"draw":"` + script + `"}`,
+		`{"draw":"3"}`,
+		`{"draw":3.5}`,
+	} {
+		var r Request
+		err := json.Unmarshal([]byte(in), &r)
+		if err == nil || strings.Contains(err.Error(), "script") {
+			t.Errorf("input %s: unexpected error %v", in, err)
+		}
+	}
+	out, err := json.Marshal(Response{Draw: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(out), `{"draw":3,`) {
+		t.Errorf("draw not encoded as integer: %s", out)
+	}
+}
+
+func FuzzParseURLValues(f *testing.F) {
+	for _, seed := range []string{
+		"draw=1&start=0&length=10&search[value]=foo&search[regex]=false",
+		"columns[0][data]=name&columns[0][search][value]=x&order[0][column]=0&order[0][dir]=asc",
+		"search[fixed][0][name]=region&search[fixed][0][term]=emea",
+		"columns[0][search][fixed][0][name]=a&columns[0][search][fixed][0][term]=b",
+		"columns[999999999][data]=x",
+		"order[9223372036854775807][dir]=asc",
+		"search[fixed][9223372036854775807][name]=x",
+		"columns[0][data",
+		"columns]0[[data]",
+		"columns[][data]=x",
+		"columns[0][search]=x",
+		"columns[0][0][0][0]=x",
+		"order[0][column]=-1&columns[-1][data]=x",
+		"draw=1&draw=2",
+	} {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, query string) {
+		u, err := url.ParseQuery(query)
+		if err != nil {
+			return
+		}
+		for _, strict := range []bool{false, true} {
+			r, err := parseURLValues(u, strict)
+			if err != nil {
+				continue
+			}
+			// Every column, order and fixed search requires at least
+			// one key, so they are bounded by the input.
+			n := len(u)
+			if len(r.Columns) > n || len(r.Order) > n || len(r.Search.Fixed) > n {
+				t.Fatalf("allocated %d columns, %d orders and %d fixed searches for %d keys",
+					len(r.Columns), len(r.Order), len(r.Search.Fixed), n)
+			}
+			for _, c := range r.Columns {
+				if len(c.Search.Fixed) > n {
+					t.Fatalf("allocated %d column fixed searches for %d keys",
+						len(c.Search.Fixed), n)
+				}
+			}
+		}
+	})
+}
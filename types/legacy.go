@@ -0,0 +1,149 @@
+package types
+
+import (
+	"encoding/json"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// ParseLegacyURLValues parses the http request url.Values of DataTables 1.9
+// (eg `sEcho`, `iDisplayStart` and `iSortCol_0`) into a Request. Columns
+// without a `mDataProp_i` use their index as data, like array sourced tables.
+func ParseLegacyURLValues(u url.Values) (r Request, err error) {
+	if r.Draw, err = legacyInt(u, "sEcho"); err != nil {
+		return
+	}
+	if r.Start, err = legacyInt(u, "iDisplayStart"); err != nil {
+		return
+	}
+	if _, r.HasLength = u["iDisplayLength"]; r.HasLength {
+		if r.Length, err = legacyInt(u, "iDisplayLength"); err != nil {
+			return
+		}
+	}
+	r.Search = Search{
+		Value: legacyValue(u, "sSearch"),
+		Regex: legacyValue(u, "bRegex") == "true",
+	}
+	n, err := legacyCount(u, "iColumns")
+	if err != nil {
+		return
+	}
+	var names []string
+	if v := legacyValue(u, "sColumns"); v != "" {
+		names = strings.Split(v, ",")
+	}
+	r.Columns = make([]Column, n)
+	for i := range r.Columns {
+		s := strconv.Itoa(i)
+		c := &r.Columns[i]
+		c.Data = s
+		if v := legacyValue(u, "mDataProp_"+s); v != "" {
+			c.Data = v
+		}
+		if i < len(names) {
+			c.Name = names[i]
+		}
+		c.Searchable = legacyValue(u, "bSearchable_"+s) == "true"
+		c.Orderable = legacyValue(u, "bSortable_"+s) == "true"
+		c.Search = Search{
+			Value: legacyValue(u, "sSearch_"+s),
+			Regex: legacyValue(u, "bRegex_"+s) == "true",
+		}
+	}
+	n, err = legacyCount(u, "iSortingCols")
+	if err != nil {
+		return
+	}
+	for i := 0; i < n; i++ {
+		s := strconv.Itoa(i)
+		var o Order
+		if o.Column, err = legacyInt(u, "iSortCol_"+s); err != nil {
+			return
+		}
+		switch legacyValue(u, "sSortDir_"+s) {
+		case "asc":
+			o.Dir = OrderAscending
+		case "desc":
+			o.Dir = OrderDescending
+		}
+		r.Order = append(r.Order, o)
+	}
+	return
+}
+
+// LegacyResponse is a Response that is encoded with the field names of
+// DataTables 1.9, eg `sEcho` and `aaData`. The non-standard fields are not
+// encoded.
+type LegacyResponse Response
+
+// legacyResponse is the JSON representation of a LegacyResponse.
+type legacyResponse struct {
+	Echo                int    `json:"sEcho"`
+	TotalRecords        int    `json:"iTotalRecords"`
+	TotalDisplayRecords int    `json:"iTotalDisplayRecords"`
+	Data                []Row  `json:"aaData"`
+	Error               string `json:"sError,omitempty"`
+}
+
+// MarshalJSON implements the json.Marshaler interface.
+func (r LegacyResponse) MarshalJSON() ([]byte, error) {
+	return json.Marshal(&legacyResponse{
+		Echo:                r.Draw,
+		TotalRecords:        r.RecordsTotal,
+		TotalDisplayRecords: r.RecordsFiltered,
+		Data:                r.Data,
+		Error:               r.Error,
+	})
+}
+
+// UnmarshalJSON implements the json.Unmarshaler interface.
+func (r *LegacyResponse) UnmarshalJSON(in []byte) error {
+	var c legacyResponse
+	if err := json.Unmarshal(in, &c); err != nil {
+		return err
+	}
+	*r = LegacyResponse{
+		Draw:            c.Echo,
+		RecordsTotal:    c.TotalRecords,
+		RecordsFiltered: c.TotalDisplayRecords,
+		Data:            c.Data,
+		Error:           c.Error,
+	}
+	return nil
+}
+
+// legacyValue returns the last value of the key, like ParseURLValues.
+func legacyValue(u url.Values, key string) string {
+	if v := u[key]; len(v) > 0 {
+		return v[len(v)-1]
+	}
+	return ""
+}
+
+// legacyInt parses the integer value of the key, which is 0 when missing.
+func legacyInt(u url.Values, key string) (int, error) {
+	v := legacyValue(u, key)
+	if v == "" {
+		return 0, nil
+	}
+	return parseInt(v, key)
+}
+
+// legacyCount parses the number of columns or orders of the key, which can't
+// be more than the number of values since every column and order has its
+// own parameters.
+func legacyCount(u url.Values, key string) (int, error) {
+	n, err := legacyInt(u, key)
+	if err != nil {
+		return 0, err
+	}
+	if n < 0 {
+		return 0, ErrInvalidIndex
+	}
+	if n > len(u) {
+		return 0, ErrNotEnoughFields
+	}
+	return n, nil
+}
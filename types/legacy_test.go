@@ -0,0 +1,127 @@
+package types
+
+import (
+	"encoding/json"
+	"errors"
+	"net/url"
+	"reflect"
+	"testing"
+)
+
+func TestParseLegacyURLValues(t *testing.T) {
+	u, err := url.ParseQuery("sEcho=3&iColumns=3&sColumns=name%2C%2Coffice" +
+		"&iDisplayStart=20&iDisplayLength=10" +
+		"&mDataProp_0=name&sSearch_0=&bRegex_0=false&bSearchable_0=true&bSortable_0=true" +
+		"&mDataProp_1=position&sSearch_1=dev&bRegex_1=false&bSearchable_1=true&bSortable_1=false" +
+		"&mDataProp_2=office&sSearch_2=%5ET&bRegex_2=true&bSearchable_2=false&bSortable_2=true" +
+		"&sSearch=foo&bRegex=false" +
+		"&iSortCol_0=2&sSortDir_0=desc&iSortCol_1=0&sSortDir_1=asc&iSortingCols=2" +
+		"&_=1496072340000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := ParseLegacyURLValues(u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Request{
+		Draw:      3,
+		Start:     20,
+		Length:    10,
+		HasLength: true,
+		Search:    Search{Value: "foo"},
+		Order: []Order{
+			{Column: 2, Dir: OrderDescending},
+			{Column: 0, Dir: OrderAscending},
+		},
+		Columns: []Column{
+			{Data: "name", Name: "name", Searchable: true, Orderable: true},
+			{Data: "position", Searchable: true, Search: Search{Value: "dev"}},
+			{Data: "office", Name: "office", Orderable: true,
+				Search: Search{Value: "^T", Regex: true}},
+		},
+	}
+	if !reflect.DeepEqual(r, want) {
+		t.Errorf("request does not match, want %+v, got %+v", want, r)
+	}
+}
+
+func TestParseLegacyURLValuesArrayColumns(t *testing.T) {
+	r, err := ParseLegacyURLValues(url.Values{
+		"sEcho":         []string{"1"},
+		"iColumns":      []string{"2"},
+		"bSearchable_0": []string{"true"},
+		"bSearchable_1": []string{"true"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Column{
+		{Data: "0", Searchable: true},
+		{Data: "1", Searchable: true},
+	}
+	if !reflect.DeepEqual(r.Columns, want) {
+		t.Errorf("columns do not match, want %+v, got %+v", want, r.Columns)
+	}
+	if r.HasLength {
+		t.Errorf("unexpected length")
+	}
+}
+
+func TestParseLegacyURLValuesErrors(t *testing.T) {
+	cases := []struct {
+		Name   string
+		Values url.Values
+		Err    error
+	}{
+		{
+			Name:   "negative-columns",
+			Values: url.Values{"iColumns": []string{"-1"}},
+			Err:    ErrInvalidIndex,
+		},
+		{
+			Name:   "too-many-columns",
+			Values: url.Values{"iColumns": []string{"1000000000"}},
+			Err:    ErrNotEnoughFields,
+		},
+	}
+	for _, c := range cases {
+		if _, err := ParseLegacyURLValues(c.Values); !errors.Is(err, c.Err) {
+			t.Errorf("case %s: unexpected error, want %v, got %v",
+				c.Name, c.Err, err)
+		}
+	}
+	if _, err := ParseLegacyURLValues(url.Values{"sEcho": []string{"x"}}); err == nil {
+		t.Errorf("expected error for invalid sEcho")
+	}
+}
+
+func TestMarshalLegacyResponse(t *testing.T) {
+	in := Response{
+		Draw:            3,
+		RecordsTotal:    57,
+		RecordsFiltered: 2,
+		Data: []Row{
+			{Data: map[string]string{"name": "Airi"}},
+		},
+		Error: "failed",
+		Sort:  []AppliedOrder{{Data: "name", Dir: OrderAscending}},
+	}
+	out, err := json.Marshal(LegacyResponse(in))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"sEcho":3,"iTotalRecords":57,"iTotalDisplayRecords":2,` +
+		`"aaData":[{"name":"Airi"}],"sError":"failed"}`
+	if string(out) != want {
+		t.Errorf("want %s, got %s", want, out)
+	}
+	var r LegacyResponse
+	if err := json.Unmarshal(out, &r); err != nil {
+		t.Fatal(err)
+	}
+	in.Sort = nil
+	if !reflect.DeepEqual(Response(r), in) {
+		t.Errorf("want %+v, got %+v", in, r)
+	}
+}
@@ -0,0 +1,133 @@
+package types
+
+import "strconv"
+
+// AppliedColumns returns the configuration of the columns of the Request.
+func (r Request) AppliedColumns() []AppliedColumn {
+	columns := make([]AppliedColumn, len(r.Columns))
+	for i, c := range r.Columns {
+		columns[i] = AppliedColumn{
+			Data:       c.Data,
+			Name:       c.Name,
+			Searchable: c.Searchable,
+			Orderable:  c.Orderable,
+		}
+	}
+	return columns
+}
+
+// WithDefaultLength returns the Request with the length n if the request did
+// not specify a length. A default of zero or less selects all records, like
+// a length of -1. Requests with an explicit length are returned as is.
+func (r Request) WithDefaultLength(n int) Request {
+	if r.HasLength {
+		return r
+	}
+	if n <= 0 {
+		n = -1
+	}
+	r.Length = n
+	return r
+}
+
+// HasSearch reports if the Request filters the records, which is when the
+// global search or any column search has a value or a fixed search term.
+func (r Request) HasSearch() bool {
+	if r.Search.active() {
+		return true
+	}
+	for _, c := range r.Columns {
+		if c.Search.active() {
+			return true
+		}
+	}
+	return false
+}
+
+// active reports if the search has a value or a fixed search term.
+func (s Search) active() bool {
+	if s.Value != "" {
+		return true
+	}
+	for _, f := range s.Fixed {
+		if f.Term != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// OrderColumn returns the column the Order applies to. Orders with a name
+// refer to the column with that name, otherwise the column index is used. It
+// returns false if there is no such column or if the Order has no direction,
+// which DataTables 2 uses to remove the ordering of a column.
+func (r Request) OrderColumn(o Order) (Column, bool) {
+	if o.Dir == "" {
+		return Column{}, false
+	}
+	if o.Name != "" {
+		for _, c := range r.Columns {
+			if c.Name == o.Name {
+				return c, true
+			}
+		}
+		return Column{}, false
+	}
+	if o.Column < 0 || o.Column >= len(r.Columns) {
+		return Column{}, false
+	}
+	return r.Columns[o.Column], true
+}
+
+// ArrayRows sets the rows to be encoded as arrays in the order of the
+// Request columns. Missing data is encoded as an empty string.
+func (r Request) ArrayRows(rows []Row) {
+	columns := make([]string, len(r.Columns))
+	for i, c := range r.Columns {
+		columns[i] = c.Data
+	}
+	for i := range rows {
+		rows[i].Columns = columns
+	}
+}
+
+// IndexRows rekeys the data of the rows by the position of the Request
+// columns, `0`, `1` and so on, as used by array sourced tables. Data of
+// fields that are not requested is dropped and missing data is set to an
+// empty string. The DT_ row properties are kept.
+func (r Request) IndexRows(rows []Row) {
+	for i := range rows {
+		data := make(map[string]string, len(r.Columns))
+		for j, c := range r.Columns {
+			data[strconv.Itoa(j)] = rows[i].Data[c.Data]
+		}
+		rows[i].Data = data
+	}
+}
+
+// GlobalSearchable reports if the global search applies to the column, which
+// is when it is searchable and not hidden.
+func (c Column) GlobalSearchable() bool {
+	return c.Searchable && (c.Visible == nil || *c.Visible)
+}
+
+// FromPage returns a Request for the given 1-based page number, where each
+// page contains pageSize records. Page numbers below 1 return the first page.
+func FromPage(page, pageSize int) Request {
+	if page < 1 {
+		page = 1
+	}
+	return Request{
+		Start:  (page - 1) * pageSize,
+		Length: pageSize,
+	}
+}
+
+// Page returns the 1-based page number and page size of the Request. Requests
+// for all records (a negative or zero length) are on the first page.
+func (r Request) Page() (page, pageSize int) {
+	if r.Length <= 0 {
+		return 1, r.Length
+	}
+	return r.Start/r.Length + 1, r.Length
+}
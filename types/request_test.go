@@ -0,0 +1,174 @@
+package types
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestPage(t *testing.T) {
+	cases := []struct {
+		Page     int
+		PageSize int
+		Start    int
+	}{
+		{Page: 1, PageSize: 10, Start: 0},
+		{Page: 3, PageSize: 10, Start: 20},
+		{Page: 3, PageSize: 25, Start: 50},
+	}
+	for i, c := range cases {
+		r := FromPage(c.Page, c.PageSize)
+		if r.Start != c.Start || r.Length != c.PageSize {
+			t.Errorf("case %d: unexpected range, want %d/%d, got %d/%d",
+				i, c.Start, c.PageSize, r.Start, r.Length)
+		}
+		page, pageSize := r.Page()
+		if page != c.Page || pageSize != c.PageSize {
+			t.Errorf("case %d: unexpected page, want %d/%d, got %d/%d",
+				i, c.Page, c.PageSize, page, pageSize)
+		}
+	}
+	if page, _ := (Request{Start: 25, Length: 10}).Page(); page != 3 {
+		t.Errorf("unaligned start: unexpected page, want %d, got %d", 3, page)
+	}
+	if page, _ := (Request{Length: -1}).Page(); page != 1 {
+		t.Errorf("all records: unexpected page, want %d, got %d", 1, page)
+	}
+}
+
+func TestArrayRows(t *testing.T) {
+	r := Request{
+		Columns: []Column{
+			{Data: "name"},
+			{Data: "position"},
+			{Data: "office"},
+		},
+	}
+	rows := []Row{
+		{
+			Data:  map[string]string{"office": "Tokyo", "name": "Airi"},
+			RowID: "row_1",
+		},
+		{
+			Data: map[string]string{"position": "CEO", "office": "London", "name": "Angelica"},
+		},
+	}
+	r.ArrayRows(rows)
+	b, err := json.Marshal(rows)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `[["Airi","","Tokyo"],["Angelica","CEO","London"]]`
+	if string(b) != want {
+		t.Errorf("unexpected rows, want %s, got %s", want, b)
+	}
+}
+
+func TestIndexRows(t *testing.T) {
+	r := Request{
+		Columns: []Column{
+			{Data: "name"},
+			{Data: "position"},
+			{Data: "office"},
+		},
+	}
+	rows := []Row{
+		{
+			Data:  map[string]string{"office": "Tokyo", "name": "Airi", "salary": "1"},
+			RowID: "row_1",
+		},
+	}
+	r.IndexRows(rows)
+	want := []Row{
+		{
+			Data:  map[string]string{"0": "Airi", "1": "", "2": "Tokyo"},
+			RowID: "row_1",
+		},
+	}
+	if !reflect.DeepEqual(rows, want) {
+		t.Errorf("unexpected rows, want %+v, got %+v", want, rows)
+	}
+}
+
+func TestAppliedColumns(t *testing.T) {
+	r := Request{
+		Columns: []Column{
+			{Data: "name", Name: "Name", Searchable: true, Orderable: true},
+			{Data: "office", Search: Search{Value: "Tokyo"}},
+		},
+	}
+	want := []AppliedColumn{
+		{Data: "name", Name: "Name", Searchable: true, Orderable: true},
+		{Data: "office"},
+	}
+	if got := r.AppliedColumns(); !reflect.DeepEqual(got, want) {
+		t.Errorf("columns do not match, want %+v, got %+v", want, got)
+	}
+}
+
+func TestWithDefaultLength(t *testing.T) {
+	cases := []struct {
+		Name    string
+		Request Request
+		Default int
+		Want    int
+	}{
+		{Name: "absent", Request: Request{}, Default: 25, Want: 25},
+		{Name: "absent-no-default", Request: Request{}, Want: -1},
+		{Name: "present-zero", Request: Request{HasLength: true}, Default: 25},
+		{
+			Name:    "present-positive",
+			Request: Request{Length: 10, HasLength: true},
+			Default: 25,
+			Want:    10,
+		},
+	}
+	for _, c := range cases {
+		if got := c.Request.WithDefaultLength(c.Default).Length; got != c.Want {
+			t.Errorf("case %s: length does not match, want %d, got %d",
+				c.Name, c.Want, got)
+		}
+	}
+}
+
+func TestHasSearch(t *testing.T) {
+	columns := func(search Search) []Column {
+		return []Column{{Data: "name"}, {Data: "office", Search: search}}
+	}
+	cases := []struct {
+		Name    string
+		Request Request
+		Want    bool
+	}{
+		{Name: "unfiltered", Request: Request{Columns: columns(Search{})}},
+		{
+			Name:    "global",
+			Request: Request{Search: Search{Value: "foo"}, Columns: columns(Search{})},
+			Want:    true,
+		},
+		{
+			Name:    "column",
+			Request: Request{Columns: columns(Search{Value: "Tokyo"})},
+			Want:    true,
+		},
+		{
+			Name: "fixed",
+			Request: Request{
+				Search:  Search{Fixed: []FixedSearch{{Name: "region", Term: "emea"}}},
+				Columns: columns(Search{}),
+			},
+			Want: true,
+		},
+		{
+			Name: "empty-fixed",
+			Request: Request{
+				Columns: columns(Search{Fixed: []FixedSearch{{Name: "region"}}}),
+			},
+		},
+	}
+	for _, c := range cases {
+		if got := c.Request.HasSearch(); got != c.Want {
+			t.Errorf("case %s: want %v, got %v", c.Name, c.Want, got)
+		}
+	}
+}
@@ -37,12 +37,109 @@ type Response struct {
 	// back the error message to be displayed using this parameter. Do not
 	// include if there is no error.
 	Error string `json:"error,omitempty"`
+	// Non-standard: Debug information about the executed query. Only set
+	// when explicitly enabled on the handler.
+	Debug *Debug `json:"_debug,omitempty"`
+	// Non-standard: The search values that were applied. Only set when
+	// explicitly enabled on the handler.
+	Search *AppliedSearch `json:"_search,omitempty"`
+	// Non-standard: The sort that was applied, in order of precedence.
+	// Only set when explicitly enabled on the handler.
+	Sort []AppliedOrder `json:"_sort,omitempty"`
+	// Non-standard: The state to fetch the next page with, for backends
+	// that can't skip records.
+	PageState string `json:"_pageState,omitempty"`
+	// Non-standard: Set when the data was truncated to the row cap of the
+	// handler, so not all requested records were returned.
+	Truncated bool `json:"_truncated,omitempty"`
+	// Non-standard: A warning about the returned data, eg that the query
+	// timed out and the data is incomplete.
+	Warning string `json:"_warning,omitempty"`
+	// Non-standard: The time the server spent processing the request in
+	// milliseconds. Only set when explicitly enabled on the handler.
+	ServerTime *float64 `json:"_serverTime,omitempty"`
+	// Non-standard: The number of records matching each active column
+	// search on its own, by column data. Only set when explicitly enabled
+	// on the handler.
+	ColumnCounts map[string]int `json:"_columnCounts,omitempty"`
+	// Non-standard: The error with a machine readable code, for
+	// programmatic clients. Only set when explicitly enabled on the
+	// handler, the Error is still set for DataTables.
+	ErrorDetail *ErrorDetail `json:"_errorDetail,omitempty"`
+	// Non-standard: The column configuration that was used, for front
+	// ends that build their columns from the server. Only set when
+	// explicitly enabled on the handler.
+	Columns []AppliedColumn `json:"columns,omitempty"`
+}
+
+// ErrorCode is a stable machine readable code of an error.
+type ErrorCode string
+
+const (
+	// ErrorCodeBadRequest indicates the request could not be parsed or is
+	// not allowed.
+	ErrorCodeBadRequest ErrorCode = "BAD_REQUEST"
+	// ErrorCodeQueryFailed indicates the backend query failed.
+	ErrorCodeQueryFailed ErrorCode = "QUERY_FAILED"
+	// ErrorCodeTimeout indicates the backend query did not finish before
+	// the deadline of the request.
+	ErrorCodeTimeout ErrorCode = "TIMEOUT"
+)
+
+// ErrorDetail contains an error of a response with its code.
+type ErrorDetail struct {
+	// Code of the error.
+	Code ErrorCode `json:"code"`
+	// Message is the human readable error, the same as the Error of the
+	// Response.
+	Message string `json:"message"`
+}
+
+// AppliedSearch contains the search values that were applied to a response.
+type AppliedSearch struct {
+	// Global search.
+	Global Search `json:"global"`
+	// Columns contains the column specific searches by column data.
+	Columns map[string]Search `json:"columns,omitempty"`
+}
+
+// AppliedOrder contains a sort field that was applied to a response.
+type AppliedOrder struct {
+	// Data is the column data that was sorted on.
+	Data string `json:"data"`
+	// Dir is the ordering direction.
+	Dir OrderDirection `json:"dir"`
+}
+
+// AppliedColumn contains the configuration of a column that was used for a
+// response.
+type AppliedColumn struct {
+	// Data is the column data source.
+	Data string `json:"data"`
+	// Name is the column name.
+	Name string `json:"name,omitempty"`
+	// Searchable is set when the column was searchable.
+	Searchable bool `json:"searchable"`
+	// Orderable is set when the column was orderable.
+	Orderable bool `json:"orderable"`
+}
+
+// Debug contains the query details of a response for debugging.
+type Debug struct {
+	// Filter as sent to the backend.
+	Filter interface{} `json:"filter"`
+	// Sort fields as sent to the backend.
+	Sort []string `json:"sort"`
 }
 
 // Row contains the data columns.
 type Row struct {
 	// Column data.
 	Data map[string]string `json:"-"`
+	// Columns sets the order of the column data for array based
+	// rendering. When set the row is encoded as an array of the data in
+	// this order, without the optional DT_ properties.
+	Columns []string `json:"-"`
 
 	// Optional: Set the ID property of the tr node to this value
 	RowID string `json:"DT_RowId,omitempty"`
@@ -77,6 +174,9 @@ type Request struct {
 	// that this can be -1 to indicate that all records should be returned
 	// (although that negates any benefits of server-side processing!)
 	Length int `json:"length"`
+	// HasLength is set by ParseURLValues when the length was present, to
+	// distinguish a request for zero records from an omitted length.
+	HasLength bool `json:"-"`
 	// Global search value. To be applied to all columns which have
 	// searchable as true.
 	Search Search `json:"search"`
@@ -97,6 +197,17 @@ type Search struct {
 	// sets, but it is technically possible and at the discretion of your
 	// script.
 	Regex bool `json:"regex"`
+	// Named search terms that are always applied, as sent by DataTables
+	// 2.1 and newer for search.fixed.
+	Fixed []FixedSearch `json:"fixed,omitempty"`
+}
+
+// FixedSearch is a named search term that is always applied.
+type FixedSearch struct {
+	// Name of the fixed search.
+	Name string `json:"name"`
+	// Term to search for.
+	Term string `json:"term"`
 }
 
 // Order contains ordering information.
@@ -105,8 +216,14 @@ type Order struct {
 	// reference to the columns array of information that is also
 	// submitted to the server.
 	Column int `json:"column"`
+	// Name of the column to which ordering should be applied, as defined
+	// by columns.name. DataTables 2 can send this instead of the column
+	// index.
+	Name string `json:"name,omitempty"`
 	// Ordering direction for this column. It will be asc or desc to
 	// indicate ascending ordering or descending ordering, respectively.
+	// DataTables 2 can send an empty direction to indicate the column
+	// should not be ordered.
 	Dir OrderDirection `json:"dir"`
 }
 
@@ -124,4 +241,7 @@ type Column struct {
 	Orderable bool `json:"orderable"`
 	// Search to apply to this specific column.
 	Search Search `json:"search"`
+	// Non-standard: Column visibility as restored from the saved state,
+	// nil when not sent.
+	Visible *bool `json:"visible,omitempty"`
 }
@@ -0,0 +1,105 @@
+// Package typestest provides utilities for testing Datatables backends.
+package typestest
+
+import (
+	"net/url"
+	"strconv"
+
+	"github.com/basvdlei/godatatables/types"
+)
+
+// RequestBuilder builds a types.Request using chainable methods, eg
+//
+//	r := typestest.NewRequestBuilder().
+//		Draw(1).
+//		Page(0, 10).
+//		Column("name", true, true).
+//		Order(0, types.OrderAscending).
+//		Search("foo").
+//		Build()
+type RequestBuilder struct {
+	r types.Request
+}
+
+// NewRequestBuilder returns an empty RequestBuilder.
+func NewRequestBuilder() *RequestBuilder {
+	return &RequestBuilder{}
+}
+
+// Draw sets the draw counter.
+func (b *RequestBuilder) Draw(n int) *RequestBuilder {
+	b.r.Draw = n
+	return b
+}
+
+// Page sets the start and length of the requested records.
+func (b *RequestBuilder) Page(start, length int) *RequestBuilder {
+	b.r.Start = start
+	b.r.Length = length
+	b.r.HasLength = true
+	return b
+}
+
+// Order adds the ordering of the column with the given index.
+func (b *RequestBuilder) Order(column int, dir types.OrderDirection) *RequestBuilder {
+	b.r.Order = append(b.r.Order, types.Order{Column: column, Dir: dir})
+	return b
+}
+
+// Column adds a column with the given data.
+func (b *RequestBuilder) Column(data string, searchable, orderable bool) *RequestBuilder {
+	b.r.Columns = append(b.r.Columns, types.Column{
+		Data:       data,
+		Searchable: searchable,
+		Orderable:  orderable,
+	})
+	return b
+}
+
+// ColumnSearch sets the search value of the last added column.
+func (b *RequestBuilder) ColumnSearch(value string, regex bool) *RequestBuilder {
+	if n := len(b.r.Columns); n > 0 {
+		b.r.Columns[n-1].Search = types.Search{Value: value, Regex: regex}
+	}
+	return b
+}
+
+// Search sets the global search value.
+func (b *RequestBuilder) Search(value string) *RequestBuilder {
+	b.r.Search.Value = value
+	return b
+}
+
+// Build returns the Request.
+func (b *RequestBuilder) Build() types.Request {
+	r := b.r
+	r.Order = append([]types.Order(nil), b.r.Order...)
+	r.Columns = append([]types.Column(nil), b.r.Columns...)
+	return r
+}
+
+// Values returns the Request as url.Values as sent by DataTables, which can
+// be used as the form of a test HTTP request.
+func (b *RequestBuilder) Values() url.Values {
+	v := url.Values{}
+	v.Set("draw", strconv.Itoa(b.r.Draw))
+	v.Set("start", strconv.Itoa(b.r.Start))
+	v.Set("length", strconv.Itoa(b.r.Length))
+	v.Set("search[value]", b.r.Search.Value)
+	v.Set("search[regex]", strconv.FormatBool(b.r.Search.Regex))
+	for i, o := range b.r.Order {
+		p := "order[" + strconv.Itoa(i) + "]"
+		v.Set(p+"[column]", strconv.Itoa(o.Column))
+		v.Set(p+"[dir]", string(o.Dir))
+	}
+	for i, c := range b.r.Columns {
+		p := "columns[" + strconv.Itoa(i) + "]"
+		v.Set(p+"[data]", c.Data)
+		v.Set(p+"[name]", c.Name)
+		v.Set(p+"[searchable]", strconv.FormatBool(c.Searchable))
+		v.Set(p+"[orderable]", strconv.FormatBool(c.Orderable))
+		v.Set(p+"[search][value]", c.Search.Value)
+		v.Set(p+"[search][regex]", strconv.FormatBool(c.Search.Regex))
+	}
+	return v
+}
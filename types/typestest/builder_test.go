@@ -0,0 +1,72 @@
+package typestest
+
+import (
+	"net/url"
+	"reflect"
+	"testing"
+
+	"github.com/basvdlei/godatatables/types"
+)
+
+func TestRequestBuilder(t *testing.T) {
+	b := NewRequestBuilder().
+		Draw(2).
+		Page(20, 10).
+		Column("name", true, true).
+		Column("city", true, false).
+		ColumnSearch("^Ams", true).
+		Order(0, types.OrderDescending).
+		Search("foo")
+	want := types.Request{
+		Draw:      2,
+		Start:     20,
+		Length:    10,
+		HasLength: true,
+		Search:    types.Search{Value: "foo"},
+		Order: []types.Order{
+			{Column: 0, Dir: types.OrderDescending},
+		},
+		Columns: []types.Column{
+			{Data: "name", Searchable: true, Orderable: true},
+			{
+				Data:       "city",
+				Searchable: true,
+				Search:     types.Search{Value: "^Ams", Regex: true},
+			},
+		},
+	}
+	r := b.Build()
+	if !reflect.DeepEqual(r, want) {
+		t.Errorf("request does not match, want %+v, got %+v", want, r)
+	}
+
+	parsed, err := types.ParseURLValues(url.Values{
+		"draw":                      []string{"2"},
+		"start":                     []string{"20"},
+		"length":                    []string{"10"},
+		"search[value]":             []string{"foo"},
+		"order[0][column]":          []string{"0"},
+		"order[0][dir]":             []string{"desc"},
+		"columns[0][data]":          []string{"name"},
+		"columns[0][searchable]":    []string{"true"},
+		"columns[0][orderable]":     []string{"true"},
+		"columns[1][data]":          []string{"city"},
+		"columns[1][searchable]":    []string{"true"},
+		"columns[1][search][value]": []string{"^Ams"},
+		"columns[1][search][regex]": []string{"true"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(parsed, r) {
+		t.Errorf("parsed request does not match, want %+v, got %+v", r, parsed)
+	}
+
+	parsed, err = types.ParseURLValues(b.Values())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(parsed, r) {
+		t.Errorf("values do not round trip, want %+v, got %+v", r, parsed)
+	}
+}